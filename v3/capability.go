@@ -0,0 +1,82 @@
+package oracle
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// propCapabilities names what a restricted key can do. It is derived from the key, so it is only there for context.
+// Unrestricted keys don't have it.
+const propCapabilities = "capabilities"
+
+func expoundCapabilities(props Props, caps delphi.Capability) {
+	if caps != delphi.CanAll {
+		props[propCapabilities] = caps.String()
+	}
+}
+
+// checkCapabilities makes sure that the capabilities a PEM claims are the ones its key has.
+func checkCapabilities(props Props, caps delphi.Capability) error {
+	claim, ok := props[propCapabilities]
+	if !ok {
+		return nil
+	}
+	claimed, err := delphi.ParseCapability(claim)
+	if err != nil {
+		return err
+	}
+	if claimed != caps {
+		return fmt.Errorf("%w. key can %s, but claims %s", delphi.ErrCapability, caps, claimed)
+	}
+	return nil
+}
+
+// Capabilities is what the Peer's key can be used for.
+func (p *Peer) Capabilities() delphi.Capability {
+	return p.PublicKey.Capabilities()
+}
+
+// Restrict returns a copy of the Peer that exposes only c. Use it to hand out, say, an encryption-only key.
+func (p *Peer) Restrict(c delphi.Capability) (Peer, error) {
+	pub, err := p.PublicKey.Restrict(c)
+	if err != nil {
+		return Peer{}, err
+	}
+	props := maps.Clone(p.Props)
+	if props == nil {
+		props = make(Props)
+	}
+	return Peer{PublicKey: pub, Props: props}, nil
+}
+
+// Capabilities is what the Principal's KeyPair can be used for.
+func (pr *Principal) Capabilities() delphi.Capability {
+	return pr.KeyPair.Capabilities()
+}
+
+// Restrict returns a copy of the Principal that can only do c. Retired KeyPairs are restricted too.
+// A signing-only Principal can be kept offline, while an encryption-only one does the day-to-day work.
+func (pr *Principal) Restrict(c delphi.Capability) (*Principal, error) {
+	kp, err := pr.KeyPair.Restrict(c)
+	if err != nil {
+		return nil, err
+	}
+	restricted := *pr
+	restricted.KeyPair = kp
+	restricted.Props = maps.Clone(pr.Props)
+	restricted.Peers = maps.Clone(pr.Peers)
+	restricted.Endorsements = maps.Clone(pr.Endorsements)
+	restricted.Derivations = maps.Clone(pr.Derivations)
+	restricted.Retired = nil
+	for i := range pr.Retired {
+		r := &pr.Retired[i]
+		if kp, err := r.KeyPair.Restrict(c); err == nil {
+			restricted.Retired = append(restricted.Retired, RetiredKey{KeyPair: kp, Until: r.Until})
+		}
+	}
+	restricted.condense()
+	restricted.expound()
+	return &restricted, nil
+}
@@ -0,0 +1,99 @@
+package oracle
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeer_Restrict(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	allie := alice.AsPeer()
+	assert.Equal(t, delphi.CanAll, allie.Capabilities())
+
+	encryptOnly, err := allie.Restrict(delphi.CanEncrypt)
+	require.NoError(t, err)
+	assert.Equal(t, delphi.CanEncrypt, encryptOnly.Capabilities())
+
+	data, err := encryptOnly.MarshalPEM()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "capabilities: encrypt\n")
+
+	got := new(Peer)
+	require.NoError(t, got.UnmarshalPEM(data))
+	assert.Equal(t, encryptOnly.PublicKey, got.PublicKey)
+	assert.Empty(t, got.Props["capabilities"])
+
+	t.Run("full keys say nothing", func(t *testing.T) {
+		data, err := allie.MarshalPEM()
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "capabilities")
+	})
+
+	t.Run("claims must match the key", func(t *testing.T) {
+		lying := bytes.Replace(data, []byte("capabilities: encrypt"), []byte("capabilities: encrypt,sign"), 1)
+		assert.ErrorIs(t, new(Peer).UnmarshalPEM(lying), delphi.ErrCapability)
+	})
+}
+
+func TestPrincipal_Restrict(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	alice.AddPeer(bob.AsPeer())
+
+	offline, err := alice.Restrict(delphi.CanSign)
+	require.NoError(t, err)
+	online, err := alice.Restrict(delphi.CanEncrypt)
+	require.NoError(t, err)
+	assert.Equal(t, delphi.CanAll, alice.Capabilities())
+	assert.True(t, online.HasPeer(bob.KeyPair.PublicKey()))
+
+	t.Run("the online key decrypts but can't sign", func(t *testing.T) {
+		msg := message.NewMessage(fakeRand(5))
+		msg.PlainText = []byte("hello")
+		require.NoError(t, msg.Encrypt(fakeRand(6), online.KeyPair.PublicKey(), &bob.KeyPair))
+		require.NoError(t, online.Decrypt(msg, revokedAt))
+		assert.Equal(t, "hello", string(msg.PlainText))
+		assert.ErrorIs(t, msg.Sign(&online.KeyPair), delphi.ErrCapability)
+	})
+
+	t.Run("the offline key signs but can't decrypt", func(t *testing.T) {
+		msg := message.NewMessage(fakeRand(5))
+		msg.PlainText = []byte("hello")
+		require.NoError(t, msg.Sign(&offline.KeyPair))
+		assert.True(t, msg.Verify(alice.KeyPair.PublicKey().Signing(), &bob.KeyPair))
+
+		msg = message.NewMessage(fakeRand(5))
+		msg.PlainText = []byte("hello")
+		require.NoError(t, msg.Encrypt(fakeRand(6), alice.KeyPair.PublicKey(), &bob.KeyPair))
+		assert.ErrorIs(t, offline.Decrypt(msg, revokedAt), delphi.ErrCapability)
+	})
+
+	t.Run("round trips", func(t *testing.T) {
+		for _, pr := range []*Principal{online, offline} {
+			data, err := pr.MarshalPEM()
+			require.NoError(t, err)
+			got := new(Principal)
+			require.NoError(t, got.UnmarshalPEM(data))
+			assert.Equal(t, pr.KeyPair, got.KeyPair)
+
+			buf := new(bytes.Buffer)
+			require.NoError(t, pr.SaveJSON(buf))
+			loaded, err := LoadJSON(buf)
+			require.NoError(t, err)
+			assert.Equal(t, pr.KeyPair, loaded.KeyPair)
+		}
+	})
+
+	t.Run("a tampered encryption-only config is detected", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, online.SaveJSON(buf))
+		tampered := bytes.Replace(buf.Bytes(), []byte(`"Props": {`), []byte(`"Props": {"evil": "yes",`), 1)
+		_, err := LoadJSON(bytes.NewReader(tampered))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+}
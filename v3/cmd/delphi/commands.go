@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/sean9999/go-oracle/v3/relay"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+func newFlagSet(e env, name string) *flag.FlagSet {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	fset.SetOutput(e.err)
+	return fset
+}
+
+func parse(fset *flag.FlagSet, args []string) error {
+	if err := fset.Parse(args); err != nil {
+		return fmt.Errorf("%w. %s", ErrUsage, err)
+	}
+	return nil
+}
+
+// keyFile is where a principal was loaded from.
+// passphrase is what unlocked it, and is nil if the file was not encrypted.
+type keyFile struct {
+	path       string
+	passphrase []byte
+}
+
+// loadPrincipal reads a private key from path, falling back to $ORACLE_KEY.
+// The file may be either an ORACLE PRIVATE KEY PEM or a JSON config.
+func loadPrincipal(e env, path string) (*oracle.Principal, keyFile, error) {
+	path, data, err := readKeyFile(e, path)
+	if err != nil {
+		return nil, keyFile{path: path}, err
+	}
+	pr, pass, err := parsePrincipal(e, data)
+	return pr, keyFile{path: path, passphrase: pass}, err
+}
+
+// readKeyFile reads the private key at path, falling back to $ORACLE_KEY, and returns the path it used.
+func readKeyFile(e env, path string) (string, []byte, error) {
+	if path == "" {
+		path = e.getenv("ORACLE_KEY")
+	}
+	if path == "" {
+		return "", nil, fmt.Errorf("%w. no private key. Use -key or set ORACLE_KEY", ErrUsage)
+	}
+	data, err := os.ReadFile(path)
+	return path, data, err
+}
+
+// readPrincipal reads a private key from stdin, as either a PEM or a JSON config.
+func readPrincipal(e env) (*oracle.Principal, error) {
+	data, err := io.ReadAll(e.in)
+	if err != nil {
+		return nil, err
+	}
+	pr, _, err := parsePrincipal(e, data)
+	return pr, err
+}
+
+// parsePrincipal decodes a PEM or a JSON config, unlocking it with $ORACLE_PASSPHRASE if it is encrypted.
+// The passphrase is returned if it was needed, so that the principal can be sealed again when it is saved.
+func parsePrincipal(e env, data []byte) (*oracle.Principal, []byte, error) {
+	var used []byte
+	getPass := func() ([]byte, error) {
+		pass, err := passphrase(e)()
+		used = pass
+		return pass, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		pr := new(oracle.Principal)
+		err := pr.UnmarshalPEMWithPassphrase(data, getPass)
+		return pr, used, err
+	}
+	pr, err := oracle.LoadJSONWithPassphrase(bytes.NewReader(data), getPass)
+	if errors.Is(err, oracle.ErrUnsignedConfig) {
+		err = fmt.Errorf("%w. If it predates signing, and you trust it, sign it with \"delphi migrate\"", err)
+	}
+	return pr, used, err
+}
+
+// passphrase unlocks encrypted private keys using $ORACLE_PASSPHRASE.
+func passphrase(e env) oracle.PassphraseFunc {
+	return func() ([]byte, error) {
+		pass := e.getenv("ORACLE_PASSPHRASE")
+		if pass == "" {
+			return nil, fmt.Errorf("%w. set ORACLE_PASSPHRASE", oracle.ErrPassphraseRequired)
+		}
+		return []byte(pass), nil
+	}
+}
+
+// savePrincipal writes a principal back to disk. Only JSON configs can hold peers.
+// A config that was encrypted is encrypted again under the same passphrase, so it is never written out in the clear.
+func savePrincipal(e env, pr *oracle.Principal, kf keyFile) error {
+	if !strings.HasSuffix(kf.path, ".json") {
+		return fmt.Errorf("%w. peers can only be saved to a JSON config, not %q", ErrUsage, kf.path)
+	}
+	buf := new(bytes.Buffer)
+	if kf.passphrase != nil {
+		if err := pr.SaveEncryptedJSON(buf, e.randy, kf.passphrase); err != nil {
+			return err
+		}
+	} else if err := pr.SaveJSON(buf); err != nil {
+		return err
+	}
+	return os.WriteFile(kf.path, buf.Bytes(), 0600)
+}
+
+func loadPeer(path string) (*oracle.Peer, error) {
+	if path == "" {
+		return nil, fmt.Errorf("%w. no peer", ErrUsage)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := new(oracle.Peer)
+	err = p.UnmarshalPEM(data)
+	return p, err
+}
+
+func readMessage(r io.Reader) (*message.Message, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	msg := new(message.Message)
+	err = msg.UnmarshalPEM(data)
+	return msg, err
+}
+
+func writeMessage(w io.Writer, msg *message.Message) error {
+	bin, err := msg.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bin)
+	return err
+}
+
+func keygen(e env, args []string) error {
+	fset := newFlagSet(e, "keygen")
+	asJSON := fset.Bool("json", false, "emit a JSON config instead of a PEM")
+	withMnemonic := fset.Bool("mnemonic", false, "derive the key from a new recovery phrase, written to stderr")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	if !*withMnemonic {
+		return emitPrincipal(e, oracle.NewPrincipal(e.randy), *asJSON)
+	}
+	phrase, err := delphi.NewMnemonic(e.randy)
+	if err != nil {
+		return err
+	}
+	pr, err := oracle.NewPrincipalFromMnemonic(phrase)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(e.err, phrase)
+	return emitPrincipal(e, pr, *asJSON)
+}
+
+// recoverKey rebuilds a private key from the recovery phrase on stdin.
+func recoverKey(e env, args []string) error {
+	fset := newFlagSet(e, "recover")
+	asJSON := fset.Bool("json", false, "emit a JSON config instead of a PEM")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	phrase, err := io.ReadAll(e.in)
+	if err != nil {
+		return err
+	}
+	pr, err := oracle.NewPrincipalFromMnemonic(string(phrase))
+	if err != nil {
+		return err
+	}
+	return emitPrincipal(e, pr, *asJSON)
+}
+
+func emitPrincipal(e env, pr *oracle.Principal, asJSON bool) error {
+	if asJSON {
+		return pr.SaveJSON(e.out)
+	}
+	bin, err := pr.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	_, err = e.out.Write(bin)
+	return err
+}
+
+func pubkey(e env, args []string) error {
+	fset := newFlagSet(e, "pubkey")
+	only := fset.String("only", "", "expose only one capability: encrypt or sign")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	pr, err := readPrincipal(e)
+	if err != nil {
+		return err
+	}
+	peer := pr.AsPeer()
+	if *only != "" {
+		caps, err := delphi.ParseCapability(*only)
+		if err != nil {
+			return fmt.Errorf("%w. %s", ErrUsage, err)
+		}
+		if peer, err = peer.Restrict(caps); err != nil {
+			return err
+		}
+	}
+	bin, err := peer.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	_, err = e.out.Write(bin)
+	return err
+}
+
+// restrict reads a private key from stdin and emits a copy that can only do one thing,
+// such as an encryption-only key for a server, while the signing key stays offline.
+func restrict(e env, args []string) error {
+	fset := newFlagSet(e, "restrict")
+	only := fset.String("only", "", "the capability to keep: encrypt or sign")
+	asJSON := fset.Bool("json", false, "emit a JSON config instead of a PEM")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	if *only == "" {
+		return fmt.Errorf("%w. restrict needs -only", ErrUsage)
+	}
+	caps, err := delphi.ParseCapability(*only)
+	if err != nil {
+		return fmt.Errorf("%w. %s", ErrUsage, err)
+	}
+	pr, err := readPrincipal(e)
+	if err != nil {
+		return err
+	}
+	restricted, err := pr.Restrict(caps)
+	if err != nil {
+		return err
+	}
+	return emitPrincipal(e, restricted, *asJSON)
+}
+
+func encrypt(e env, args []string) error {
+	fset := newFlagSet(e, "encrypt")
+	var recipients []delphi.PublicKey
+	fset.Func("to", "recipient's peer PEM. May be repeated", func(path string) error {
+		p, err := loadPeer(path)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, p.PublicKey)
+		return nil
+	})
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("%w. no recipient", ErrUsage)
+	}
+	body, err := io.ReadAll(e.in)
+	if err != nil {
+		return err
+	}
+	msg := message.NewMessage(e.randy)
+	msg.PlainText = body
+	//	encryption uses one-time ephemeral keys, so the sender's own key material is not needed
+	var sealer delphi.KeyPair
+	if len(recipients) == 1 {
+		err = msg.Encrypt(e.randy, recipients[0], &sealer)
+	} else {
+		err = msg.EncryptToMany(e.randy, recipients, &sealer)
+	}
+	if err != nil {
+		return err
+	}
+	return writeMessage(e.out, msg)
+}
+
+func decrypt(e env, args []string) error {
+	fset := newFlagSet(e, "decrypt")
+	keyPath := fset.String("key", "", "private key")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	pr, _, err := loadPrincipal(e, *keyPath)
+	if err != nil {
+		return err
+	}
+	msg, err := readMessage(e.in)
+	if err != nil {
+		return err
+	}
+	if err := msg.Decrypt(&pr.KeyPair); err != nil {
+		return err
+	}
+	_, err = e.out.Write(msg.PlainText)
+	return err
+}
+
+func sign(e env, args []string) error {
+	fset := newFlagSet(e, "sign")
+	keyPath := fset.String("key", "", "private key")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	pr, _, err := loadPrincipal(e, *keyPath)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(e.in)
+	if err != nil {
+		return err
+	}
+	msg := message.NewMessage(e.randy)
+	msg.PlainText = body
+	if err := msg.Sign(&pr.KeyPair); err != nil {
+		return err
+	}
+	return writeMessage(e.out, msg)
+}
+
+// verify checks a signed message. On success, the message body is written to stdout.
+func verify(e env, args []string) error {
+	fset := newFlagSet(e, "verify")
+	from := fset.String("from", "", "signer's peer PEM")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	signer, err := loadPeer(*from)
+	if err != nil {
+		return err
+	}
+	msg, err := readMessage(e.in)
+	if err != nil {
+		return err
+	}
+	//	verification only needs the signer's public key
+	var verifier delphi.KeyPair
+	if !msg.Verify(signer.PublicKey.Signing(), &verifier) {
+		return ErrInvalidSignature
+	}
+	_, err = e.out.Write(msg.Body())
+	return err
+}
+
+func peer(e env, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w. peer needs a subcommand", ErrUsage)
+	}
+	sub, args := args[0], args[1:]
+	fset := newFlagSet(e, "peer "+sub)
+	keyPath := fset.String("key", "", "private key, as a JSON config")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	pr, kf, err := loadPrincipal(e, *keyPath)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "add":
+		data, err := io.ReadAll(e.in)
+		if err != nil {
+			return err
+		}
+		p := new(oracle.Peer)
+		if err := p.UnmarshalPEM(data); err != nil {
+			return err
+		}
+		pr.AddPeer(*p)
+		return savePrincipal(e, pr, kf)
+	case "list":
+		return listPeers(e, pr)
+	case "rm":
+		if fset.NArg() != 1 {
+			return fmt.Errorf("%w. peer rm needs exactly one fingerprint, public key or nick", ErrUsage)
+		}
+		p, err := pr.Peers.Find(fset.Arg(0))
+		if err != nil {
+			return err
+		}
+		delete(pr.Peers, p.PublicKey)
+		return savePrincipal(e, pr, kf)
+	default:
+		return fmt.Errorf("%w. unknown peer command %q", ErrUsage, sub)
+	}
+}
+
+// migrate signs a config written before configs were signed.
+func migrate(e env, args []string) error {
+	fset := newFlagSet(e, "migrate")
+	keyPath := fset.String("key", "", "private key, as a JSON config")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	path, data, err := readKeyFile(e, *keyPath)
+	if err != nil {
+		return err
+	}
+	kf := keyFile{path: path}
+	pr, err := oracle.LoadUnsignedJSONWithPassphrase(bytes.NewReader(data), func() ([]byte, error) {
+		pass, err := passphrase(e)()
+		kf.passphrase = pass
+		return pass, err
+	})
+	if err != nil {
+		return err
+	}
+	if !pr.Unsigned() {
+		fmt.Fprintln(e.err, "this config is already signed")
+		return nil
+	}
+	if err := savePrincipal(e, pr, kf); err != nil {
+		return err
+	}
+	fmt.Fprintln(e.err, "signed "+path)
+	return nil
+}
+
+// listPeers writes every peer as a PEM, ordered by nickname.
+func listPeers(e env, pr *oracle.Principal) error {
+	keys := make([]delphi.PublicKey, 0, len(pr.Peers))
+	for pub := range pr.Peers {
+		keys = append(keys, pub)
+	}
+	slices.SortFunc(keys, func(a, b delphi.PublicKey) int {
+		return strings.Compare(a.Nickname(), b.Nickname())
+	})
+	for _, pub := range keys {
+		props := pr.Peers[pub]
+		if props == nil {
+			props = make(oracle.Props)
+		}
+		p := oracle.Peer{PublicKey: pub, Props: props}
+		bin, err := p.MarshalPEM()
+		if err != nil {
+			return err
+		}
+		if _, err := e.out.Write(bin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serveRelay(e env, args []string) error {
+	fset := newFlagSet(e, "relay")
+	addr := fset.String("addr", "localhost:8080", "address to listen on")
+	dir := fset.String("dir", "", "directory to keep mail in. Mail is kept in memory if this is empty")
+	if err := parse(fset, args); err != nil {
+		return err
+	}
+	var store relay.Store = relay.NewMemoryStore()
+	if *dir != "" {
+		fs, err := relay.NewFileStore(*dir)
+		if err != nil {
+			return err
+		}
+		store = fs
+	}
+	fmt.Fprintf(e.err, "relaying on %s\n", *addr)
+	return relayServer(*addr, relay.NewServer(store, e.randy)).ListenAndServe()
+}
+
+// relayServer is an http.Server whose timeouts stop slow or idle clients from holding connections open.
+// Request bodies are capped by the relay itself.
+func relayServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
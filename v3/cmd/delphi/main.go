@@ -0,0 +1,102 @@
+// Command delphi is the command-line companion to oracle.
+// It reads from stdin and writes to stdout, so it composes well in shell pipelines.
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: delphi <command> [flags]
+
+commands:
+	keygen [-mnemonic]         generate a new private key, optionally with a recovery phrase
+	recover                    rebuild a private key from the recovery phrase on stdin
+	pubkey [-only <cap>]       read a private key from stdin and emit its public key,
+	                           optionally exposing only "encrypt" or "sign"
+	restrict -only <cap>       read a private key from stdin and emit one that can only "encrypt" or "sign"
+	encrypt -to <peer.pem>     encrypt stdin for one or more peers
+	decrypt -key <priv>        decrypt an encrypted message from stdin
+	sign    -key <priv>        sign stdin
+	verify  -from <peer.pem>   verify a signed message from stdin
+	peer add  -key <priv.json> add the peer on stdin to the principal's peers
+	peer list -key <priv.json> list the principal's peers
+	peer rm   -key <priv.json> <fingerprint|pubkey|nick>
+	                           remove a peer
+	migrate -key <priv.json>   sign a config written before configs were signed
+	relay -addr <host:port>    run a mailbox for encrypted messages
+	      [-dir <path>]        keeping mail on disk rather than in memory
+
+The private key can also be supplied through the ORACLE_KEY environment variable.
+Encrypted private keys are unlocked with the ORACLE_PASSPHRASE environment variable.
+`
+
+var ErrUsage = errors.New("bad usage")
+
+// env is everything a command needs from the outside world.
+type env struct {
+	in     io.Reader
+	out    io.Writer
+	err    io.Writer
+	randy  io.Reader
+	getenv func(string) string
+}
+
+func main() {
+	e := env{
+		in:     os.Stdin,
+		out:    os.Stdout,
+		err:    os.Stderr,
+		randy:  rand.Reader,
+		getenv: os.Getenv,
+	}
+	err := run(e, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(e.err, err)
+		if errors.Is(err, ErrUsage) {
+			fmt.Fprint(e.err, usage)
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run dispatches to a subcommand.
+func run(e env, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w. no command", ErrUsage)
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "keygen":
+		return keygen(e, args)
+	case "recover":
+		return recoverKey(e, args)
+	case "pubkey":
+		return pubkey(e, args)
+	case "restrict":
+		return restrict(e, args)
+	case "encrypt":
+		return encrypt(e, args)
+	case "decrypt":
+		return decrypt(e, args)
+	case "sign":
+		return sign(e, args)
+	case "verify":
+		return verify(e, args)
+	case "peer":
+		return peer(e, args)
+	case "migrate":
+		return migrate(e, args)
+	case "relay":
+		return serveRelay(e, args)
+	case "help", "-h", "--help":
+		fmt.Fprint(e.out, usage)
+		return nil
+	default:
+		return fmt.Errorf("%w. unknown command %q", ErrUsage, cmd)
+	}
+}
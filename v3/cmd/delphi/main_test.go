@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEnv(t testing.TB, stdin []byte, vars map[string]string) (env, *bytes.Buffer) {
+	t.Helper()
+	out := new(bytes.Buffer)
+	e := env{
+		in:    bytes.NewReader(stdin),
+		out:   out,
+		err:   io.Discard,
+		randy: rand.Reader,
+		getenv: func(k string) string {
+			return vars[k]
+		},
+	}
+	return e, out
+}
+
+// invoke runs a command and returns its stdout.
+func invoke(t testing.TB, stdin []byte, vars map[string]string, args ...string) []byte {
+	t.Helper()
+	e, out := testEnv(t, stdin, vars)
+	err := run(e, args)
+	require.NoError(t, err)
+	return out.Bytes()
+}
+
+// writeTemp writes data to a file in a temporary directory and returns its path.
+func writeTemp(t testing.TB, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, data, 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestRun_Usage(t *testing.T) {
+	e, _ := testEnv(t, nil, nil)
+	assert.ErrorIs(t, run(e, nil), ErrUsage)
+	assert.ErrorIs(t, run(e, []string{"frobnicate"}), ErrUsage)
+	assert.ErrorIs(t, run(e, []string{"peer"}), ErrUsage)
+	assert.ErrorIs(t, run(e, []string{"sign"}), ErrUsage)
+	assert.ErrorIs(t, run(e, []string{"relay", "-nope"}), ErrUsage)
+}
+
+func TestRelayServer_Timeouts(t *testing.T) {
+	srv := relayServer("localhost:0", http.NotFoundHandler())
+	assert.NotZero(t, srv.ReadHeaderTimeout)
+	assert.NotZero(t, srv.ReadTimeout)
+	assert.NotZero(t, srv.WriteTimeout)
+	assert.NotZero(t, srv.IdleTimeout)
+}
+
+func TestRun_EncryptDecrypt(t *testing.T) {
+	bobKey := writeTemp(t, "bob.pem", invoke(t, nil, nil, "keygen"))
+	bobPriv, err := os.ReadFile(bobKey)
+	require.NoError(t, err)
+	bobPeer := writeTemp(t, "bob.peer.pem", invoke(t, bobPriv, nil, "pubkey"))
+
+	plain := []byte("hello bob")
+	ciph := invoke(t, plain, nil, "encrypt", "-to", bobPeer)
+	assert.Contains(t, string(ciph), "ORACLE ENCRYPTED MESSAGE")
+	assert.NotContains(t, string(ciph), "hello bob")
+
+	t.Run("with -key", func(t *testing.T) {
+		got := invoke(t, ciph, nil, "decrypt", "-key", bobKey)
+		assert.Equal(t, plain, got)
+	})
+
+	t.Run("with ORACLE_KEY", func(t *testing.T) {
+		got := invoke(t, ciph, map[string]string{"ORACLE_KEY": bobKey}, "decrypt")
+		assert.Equal(t, plain, got)
+	})
+
+	t.Run("many recipients", func(t *testing.T) {
+		carolKey := writeTemp(t, "carol.pem", invoke(t, nil, nil, "keygen"))
+		carolPriv, err := os.ReadFile(carolKey)
+		require.NoError(t, err)
+		carolPeer := writeTemp(t, "carol.peer.pem", invoke(t, carolPriv, nil, "pubkey"))
+		ciph := invoke(t, plain, nil, "encrypt", "-to", bobPeer, "-to", carolPeer)
+		assert.Equal(t, plain, invoke(t, ciph, nil, "decrypt", "-key", bobKey))
+		assert.Equal(t, plain, invoke(t, ciph, nil, "decrypt", "-key", carolKey))
+	})
+
+	t.Run("encrypted key", func(t *testing.T) {
+		pr := new(oracle.Principal)
+		require.NoError(t, pr.UnmarshalPEM(bobPriv))
+		locked, err := pr.MarshalEncryptedPEM(rand.Reader, []byte("hunter2"))
+		require.NoError(t, err)
+		lockedKey := writeTemp(t, "bob.locked.pem", locked)
+
+		e, _ := testEnv(t, ciph, nil)
+		err = run(e, []string{"decrypt", "-key", lockedKey})
+		assert.ErrorIs(t, err, oracle.ErrPassphraseRequired)
+
+		got := invoke(t, ciph, map[string]string{"ORACLE_PASSPHRASE": "hunter2"}, "decrypt", "-key", lockedKey)
+		assert.Equal(t, plain, got)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		eveKey := writeTemp(t, "eve.pem", invoke(t, nil, nil, "keygen"))
+		e, _ := testEnv(t, ciph, nil)
+		err := run(e, []string{"decrypt", "-key", eveKey})
+		assert.Error(t, err)
+	})
+}
+
+func TestRun_KeygenRecover(t *testing.T) {
+	e, out := testEnv(t, nil, nil)
+	phrase := new(bytes.Buffer)
+	e.err = phrase
+	require.NoError(t, run(e, []string{"keygen", "-mnemonic"}))
+	assert.Len(t, strings.Fields(phrase.String()), 24)
+
+	recovered := invoke(t, phrase.Bytes(), nil, "recover")
+	assert.Equal(t, out.String(), string(recovered))
+
+	e, _ = testEnv(t, []byte("not a phrase"), nil)
+	assert.Error(t, run(e, []string{"recover"}))
+}
+
+func TestRun_Restrict(t *testing.T) {
+	bobPriv := invoke(t, nil, nil, "keygen")
+	online := writeTemp(t, "bob.online.pem", invoke(t, bobPriv, nil, "restrict", "-only", "encrypt"))
+	bobPeer := invoke(t, bobPriv, nil, "pubkey", "-only", "encrypt")
+	assert.Contains(t, string(bobPeer), "capabilities: encrypt")
+	bobPeerPath := writeTemp(t, "bob.peer.pem", bobPeer)
+
+	ciph := invoke(t, []byte("hello bob"), nil, "encrypt", "-to", bobPeerPath)
+	assert.Equal(t, []byte("hello bob"), invoke(t, ciph, nil, "decrypt", "-key", online))
+
+	e, _ := testEnv(t, []byte("hello"), nil)
+	assert.Error(t, run(e, []string{"sign", "-key", online}))
+	e, _ = testEnv(t, bobPriv, nil)
+	assert.ErrorIs(t, run(e, []string{"restrict"}), ErrUsage)
+	e, _ = testEnv(t, bobPriv, nil)
+	assert.ErrorIs(t, run(e, []string{"pubkey", "-only", "fly"}), ErrUsage)
+}
+
+func TestRun_UnsignedConfig(t *testing.T) {
+	legacy, err := os.ReadFile("../../testdata/alice.priv.json")
+	require.NoError(t, err)
+	e, _ := testEnv(t, legacy, nil)
+	err = run(e, []string{"pubkey"})
+	assert.ErrorIs(t, err, oracle.ErrUnsignedConfig)
+	assert.ErrorContains(t, err, "delphi migrate")
+
+	t.Run("migrate", func(t *testing.T) {
+		path := writeTemp(t, "alice.json", legacy)
+		invoke(t, nil, nil, "migrate", "-key", path)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "verity")
+		assert.Contains(t, string(invoke(t, data, nil, "pubkey")), "ORACLE PEER")
+	})
+
+	t.Run("migrate encrypted", func(t *testing.T) {
+		pr, err := oracle.LoadUnsignedJSON(bytes.NewReader(legacy))
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		require.NoError(t, pr.SaveEncryptedJSON(buf, rand.Reader, []byte("hunter2")))
+		var conf map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &conf))
+		delete(conf, "verity")
+		stripped, err := json.Marshal(conf)
+		require.NoError(t, err)
+		path := writeTemp(t, "alice.json", stripped)
+		pass := map[string]string{"ORACLE_PASSPHRASE": "hunter2"}
+
+		e, _ := testEnv(t, nil, pass)
+		assert.ErrorIs(t, run(e, []string{"peer", "list", "-key", path}), oracle.ErrUnsignedConfig)
+		invoke(t, nil, pass, "migrate", "-key", path)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "sealed_keypair")
+		assert.NotContains(t, string(data), `"keypair"`)
+		invoke(t, nil, pass, "peer", "list", "-key", path)
+	})
+}
+
+func TestRun_EncryptedKeyOnStdin(t *testing.T) {
+	bobPriv := invoke(t, nil, nil, "keygen")
+	pr := new(oracle.Principal)
+	require.NoError(t, pr.UnmarshalPEM(bobPriv))
+	locked, err := pr.MarshalEncryptedPEM(rand.Reader, []byte("hunter2"))
+	require.NoError(t, err)
+	pass := map[string]string{"ORACLE_PASSPHRASE": "hunter2"}
+
+	e, _ := testEnv(t, locked, nil)
+	assert.ErrorIs(t, run(e, []string{"pubkey"}), oracle.ErrPassphraseRequired)
+	e, _ = testEnv(t, locked, nil)
+	assert.ErrorIs(t, run(e, []string{"restrict", "-only", "encrypt"}), oracle.ErrPassphraseRequired)
+
+	assert.Equal(t, invoke(t, bobPriv, nil, "pubkey"), invoke(t, locked, pass, "pubkey"))
+	assert.Equal(t,
+		invoke(t, bobPriv, nil, "restrict", "-only", "encrypt"),
+		invoke(t, locked, pass, "restrict", "-only", "encrypt"))
+}
+
+func TestRun_SignVerify(t *testing.T) {
+	aliceKey := writeTemp(t, "alice.json", invoke(t, nil, nil, "keygen", "-json"))
+	alicePriv, err := os.ReadFile(aliceKey)
+	require.NoError(t, err)
+	alicePeer := writeTemp(t, "alice.peer.pem", invoke(t, alicePriv, nil, "pubkey"))
+
+	signed := invoke(t, []byte("release v3"), nil, "sign", "-key", aliceKey)
+	assert.Contains(t, string(signed), "sig:")
+
+	got := invoke(t, signed, nil, "verify", "-from", alicePeer)
+	assert.Equal(t, []byte("release v3"), got)
+
+	t.Run("tampered", func(t *testing.T) {
+		bobKey := writeTemp(t, "bob.pem", invoke(t, nil, nil, "keygen"))
+		bobPriv, err := os.ReadFile(bobKey)
+		require.NoError(t, err)
+		bobPeer := writeTemp(t, "bob.peer.pem", invoke(t, bobPriv, nil, "pubkey"))
+		e, _ := testEnv(t, signed, nil)
+		err = run(e, []string{"verify", "-from", bobPeer})
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+}
+
+func TestRun_Peer(t *testing.T) {
+	aliceKey := writeTemp(t, "alice.json", invoke(t, nil, nil, "keygen", "-json"))
+	bobPriv := invoke(t, nil, nil, "keygen")
+	bobPeer := invoke(t, bobPriv, nil, "pubkey")
+
+	invoke(t, bobPeer, nil, "peer", "add", "-key", aliceKey)
+	list := invoke(t, nil, nil, "peer", "list", "-key", aliceKey)
+	assert.Equal(t, 1, strings.Count(string(list), "BEGIN ORACLE PEER"))
+	assert.Equal(t, string(bobPeer), string(list))
+
+	t.Run("rm unknown", func(t *testing.T) {
+		e, _ := testEnv(t, nil, nil)
+		err := run(e, []string{"peer", "rm", "-key", aliceKey, "nobody"})
+		assert.ErrorIs(t, err, oracle.ErrNoSuchPeer)
+	})
+
+	t.Run("rm", func(t *testing.T) {
+		nick := strings.TrimSpace(strings.Split(strings.Split(string(bobPeer), "nick: ")[1], "\n")[0])
+		invoke(t, nil, nil, "peer", "rm", "-key", aliceKey, nick)
+		list := invoke(t, nil, nil, "peer", "list", "-key", aliceKey)
+		assert.Empty(t, list)
+	})
+
+	t.Run("encrypted config stays encrypted", func(t *testing.T) {
+		alice, err := oracle.LoadJSON(bytes.NewReader(invoke(t, nil, nil, "keygen", "-json")))
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveEncryptedJSON(buf, rand.Reader, []byte("hunter2")))
+		sealedKey := writeTemp(t, "alice.json", buf.Bytes())
+		pass := map[string]string{"ORACLE_PASSPHRASE": "hunter2"}
+		assertSealed := func() {
+			t.Helper()
+			data, err := os.ReadFile(sealedKey)
+			require.NoError(t, err)
+			assert.Contains(t, string(data), "sealed_keypair")
+			assert.NotContains(t, string(data), `"keypair"`)
+			got, err := oracle.LoadJSONWithPassphrase(bytes.NewReader(data), func() ([]byte, error) {
+				return []byte("hunter2"), nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, alice.KeyPair, got.KeyPair)
+		}
+
+		e, _ := testEnv(t, bobPeer, nil)
+		assert.ErrorIs(t, run(e, []string{"peer", "add", "-key", sealedKey}), oracle.ErrPassphraseRequired)
+
+		invoke(t, bobPeer, pass, "peer", "add", "-key", sealedKey)
+		assertSealed()
+		assert.Equal(t, string(bobPeer), string(invoke(t, nil, pass, "peer", "list", "-key", sealedKey)))
+
+		nick := strings.TrimSpace(strings.Split(strings.Split(string(bobPeer), "nick: ")[1], "\n")[0])
+		invoke(t, nil, pass, "peer", "rm", "-key", sealedKey, nick)
+		assertSealed()
+		assert.Empty(t, invoke(t, nil, pass, "peer", "list", "-key", sealedKey))
+	})
+
+	t.Run("PEM keys cannot hold peers", func(t *testing.T) {
+		pemKey := writeTemp(t, "carol.pem", invoke(t, nil, nil, "keygen"))
+		e, _ := testEnv(t, bobPeer, nil)
+		err := run(e, []string{"peer", "add", "-key", pemKey})
+		assert.ErrorIs(t, err, ErrUsage)
+	})
+}
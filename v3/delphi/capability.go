@@ -0,0 +1,128 @@
+package delphi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A Capability is something a key can do. A key lacking a capability has a zero SubKey in its place,
+// so a restricted key carries no material for what it can't do.
+type Capability uint8
+
+const (
+	CanEncrypt Capability = 1 << iota
+	CanSign
+	CanAll = CanEncrypt | CanSign
+)
+
+var ErrCapability = errors.New("key lacks capability")
+
+var capabilityNames = []struct {
+	c    Capability
+	name string
+}{
+	{CanEncrypt, "encrypt"},
+	{CanSign, "sign"},
+}
+
+// String is a comma-separated list, such as "encrypt,sign".
+func (c Capability) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c&n.c != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseCapability is the inverse of Capability.String.
+func ParseCapability(s string) (Capability, error) {
+	if strings.TrimSpace(s) == "none" {
+		return 0, nil
+	}
+	var c Capability
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, n := range capabilityNames {
+			if strings.TrimSpace(name) == n.name {
+				c |= n.c
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %q", ErrCapability, name)
+		}
+	}
+	return c, nil
+}
+
+// Has reports whether c includes all of d.
+func (c Capability) Has(d Capability) bool {
+	return c&d == d
+}
+
+func (s SubKey) IsZero() bool {
+	return s == zeroSubKey
+}
+
+func capabilitiesOf(k Key) Capability {
+	var c Capability
+	if !k[0].IsZero() {
+		c |= CanEncrypt
+	}
+	if !k[1].IsZero() {
+		c |= CanSign
+	}
+	return c
+}
+
+// Capabilities is what the key can be used for. An unrestricted key can do everything.
+func (k PublicKey) Capabilities() Capability {
+	return capabilitiesOf(Key(k))
+}
+
+// Capabilities is what the KeyPair can be used for: what both of its Keys can do.
+func (kp *KeyPair) Capabilities() Capability {
+	return capabilitiesOf(kp[0]) & capabilitiesOf(kp[1])
+}
+
+// Can returns an error wrapping ErrCapability if k lacks c.
+func (k PublicKey) Can(c Capability) error {
+	if !k.Capabilities().Has(c) {
+		return fmt.Errorf("%w. %s can't %s", ErrCapability, k.Nickname(), c&^k.Capabilities())
+	}
+	return nil
+}
+
+func restrict(k Key, c Capability) Key {
+	if !c.Has(CanEncrypt) {
+		k[0] = zeroSubKey
+	}
+	if !c.Has(CanSign) {
+		k[1] = zeroSubKey
+	}
+	return k
+}
+
+// Restrict returns a copy of the key that can only do c, for handing to someone who only needs c.
+// A restricted key is a different key, with its own Fingerprint.
+func (k PublicKey) Restrict(c Capability) (PublicKey, error) {
+	if c == 0 || !k.Capabilities().Has(c) {
+		return PublicKey{}, fmt.Errorf("%w. %s can't be restricted to %s", ErrCapability, k.Nickname(), c)
+	}
+	return PublicKey(restrict(Key(k), c)), nil
+}
+
+// Restrict returns a copy of the KeyPair that can only do c.
+// For example, a signing key can be kept offline while an encryption-only KeyPair is used online.
+func (kp *KeyPair) Restrict(c Capability) (KeyPair, error) {
+	if c == 0 || !kp.Capabilities().Has(c) {
+		return ZeroKeyPair, fmt.Errorf("%w. %s can't be restricted to %s", ErrCapability, kp.PublicKey().Nickname(), c)
+	}
+	return KeyPair{restrict(kp[0], c), restrict(kp[1], c)}, nil
+}
@@ -0,0 +1,95 @@
+package delphi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapability(t *testing.T) {
+	assert.Equal(t, "encrypt,sign", CanAll.String())
+	assert.Equal(t, "none", Capability(0).String())
+	for c := Capability(0); c <= CanAll; c++ {
+		got, err := ParseCapability(c.String())
+		require.NoError(t, err, c)
+		assert.Equal(t, c, got)
+	}
+	for _, bad := range []string{"fly", "", "none,sign"} {
+		_, err := ParseCapability(bad)
+		assert.ErrorIs(t, err, ErrCapability, bad)
+	}
+}
+
+func TestKeyPair_Restrict(t *testing.T) {
+	alice := deterministicKeyPair(t, 1)
+	bob := deterministicKeyPair(t, 2)
+	assert.Equal(t, CanAll, alice.Capabilities())
+
+	signer, err := alice.Restrict(CanSign)
+	require.NoError(t, err)
+	require.NoError(t, signer.Validate())
+	assert.Equal(t, CanSign, signer.Capabilities())
+	assert.True(t, signer.PublicKey().Encryption().IsZero())
+	assert.Equal(t, alice.PublicKey().Signing(), signer.PublicKey().Signing())
+
+	encrypter, err := alice.Restrict(CanEncrypt)
+	require.NoError(t, err)
+	require.NoError(t, encrypter.Validate())
+	assert.Equal(t, CanEncrypt, encrypter.Capabilities())
+
+	t.Run("nicknames", func(t *testing.T) {
+		assert.NotEqual(t, ZeroKeyPair.PublicKey().Nickname(), signer.PublicKey().Nickname())
+		assert.Equal(t, alice.PublicKey().Nickname(), encrypter.PublicKey().Nickname())
+	})
+
+	t.Run("signing", func(t *testing.T) {
+		sig, err := signer.Sign(nil, []byte("data"), nil)
+		require.NoError(t, err)
+		assert.True(t, bob.Verify(alice.PublicKey().Signing(), []byte("data"), sig))
+		_, err = encrypter.Sign(nil, []byte("data"), nil)
+		assert.ErrorIs(t, err, ErrCapability)
+		assert.False(t, bob.Verify(encrypter.PublicKey().Signing(), []byte("data"), sig))
+	})
+
+	t.Run("encrypting", func(t *testing.T) {
+		sec, eph, err := bob.GenerateSharedSecret(deterministicReader(t, 3), encrypter.PublicKey())
+		require.NoError(t, err)
+		got, err := encrypter.ExtractSharedSecret(eph)
+		require.NoError(t, err)
+		assert.Equal(t, sec, got)
+		_, err = signer.ExtractSharedSecret(eph)
+		assert.ErrorIs(t, err, ErrCapability)
+	})
+
+	t.Run("hybrid keys survive restriction", func(t *testing.T) {
+		hybrid, err := NewHybridKeyPair(deterministicReader(t, 3), &alice)
+		require.NoError(t, err)
+		full, err := hybrid.HybridPublicKey()
+		require.NoError(t, err)
+		online, err := hybrid.Restrict(CanEncrypt)
+		require.NoError(t, err)
+		restricted, err := online.HybridPublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, full.KEM, restricted.KEM)
+		assert.Equal(t, encrypter.PublicKey(), restricted.PublicKey)
+		_, err = hybrid.Restrict(CanSign)
+		assert.ErrorIs(t, err, ErrCapability)
+		_, err = NewHybridKeyPair(deterministicReader(t, 3), &signer)
+		assert.ErrorIs(t, err, ErrCapability)
+	})
+
+	t.Run("restrictions can't be lifted", func(t *testing.T) {
+		_, err := signer.Restrict(CanEncrypt)
+		assert.ErrorIs(t, err, ErrCapability)
+		_, err = alice.Restrict(0)
+		assert.ErrorIs(t, err, ErrCapability)
+		_, err = signer.PublicKey().Restrict(CanAll)
+		assert.ErrorIs(t, err, ErrCapability)
+	})
+
+	t.Run("both keys must agree", func(t *testing.T) {
+		mixed := KeyPair{alice[0], signer[1]}
+		assert.ErrorIs(t, mixed.Validate(), ErrKeyMismatch)
+	})
+}
@@ -0,0 +1,91 @@
+package delphi
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Child keys are derived from the parent's private key, like so:
+//
+//	encryption key = HKDF-SHA256(ikm: parent private key, salt: "oracle/derive/v1", info: "oracle/derive/x25519\x00" + label)
+//	signing seed   = HKDF-SHA256(ikm: parent private key, salt: "oracle/derive/v1", info: "oracle/derive/ed25519\x00" + label)
+//
+// so children with different labels are independent of each other, and reveal nothing about their parent.
+const (
+	deriveSalt    = "oracle/derive/v1"
+	deriveX25519  = "oracle/derive/x25519\x00"
+	deriveEd25519 = "oracle/derive/ed25519\x00"
+	// MaxLabelSize is the longest label a child key can have, in bytes.
+	MaxLabelSize = 255
+)
+
+var ErrBadLabel = errors.New("bad label")
+
+// ValidateLabel checks that a label can name a child key.
+// Labels are non-empty UTF-8, without control characters, such as "service/billing".
+func ValidateLabel(label string) error {
+	if label == "" || len(label) > MaxLabelSize || !utf8.ValidString(label) {
+		return fmt.Errorf("%w: %q", ErrBadLabel, label)
+	}
+	if strings.ContainsFunc(label, func(r rune) bool { return r < 0x20 || r == 0x7f }) {
+		return fmt.Errorf("%w: %q", ErrBadLabel, label)
+	}
+	return nil
+}
+
+// Derive deterministically derives a child KeyPair. The same parent and label always give the same child.
+// Both halves of the child come from the whole of the parent's private key, so a restricted parent can't derive.
+func (kp *KeyPair) Derive(label string) (KeyPair, error) {
+	if err := ValidateLabel(label); err != nil {
+		return ZeroKeyPair, err
+	}
+	if err := kp.Validate(); err != nil {
+		return ZeroKeyPair, fmt.Errorf("could not derive. %w", err)
+	}
+	if caps := kp.Capabilities(); !caps.Has(CanAll) {
+		return ZeroKeyPair, fmt.Errorf("could not derive. %w. %s can't %s", ErrCapability, kp.PublicKey().Nickname(), CanAll&^caps)
+	}
+	return keyPairFromSecret(kp.PrivateKey().Bytes(), deriveSalt, deriveX25519+label, deriveEd25519+label)
+}
+
+// keyPairFromSecret expands a secret into an X25519 key and an ed25519 seed, using HKDF-SHA256.
+func keyPairFromSecret(secret []byte, salt, encInfo, signInfo string) (KeyPair, error) {
+	derive := func(info string) ([]byte, error) {
+		b := make([]byte, subKeySize)
+		_, err := io.ReadFull(hkdf.New(sha256.New, secret, []byte(salt), []byte(info)), b)
+		return b, err
+	}
+	encSeed, err := derive(encInfo)
+	defer Wipe(encSeed)
+	if err != nil {
+		return ZeroKeyPair, err
+	}
+	signSeed, err := derive(signInfo)
+	defer Wipe(signSeed)
+	if err != nil {
+		return ZeroKeyPair, err
+	}
+	encPriv, err := ecdh.X25519().NewPrivateKey(encSeed)
+	if err != nil {
+		return ZeroKeyPair, fmt.Errorf("could not derive encryption key. %w", err)
+	}
+	signPriv := ed25519.NewKeyFromSeed(signSeed)
+	defer Wipe(signPriv)
+	pub := PublicKey{
+		SubKey(encPriv.PublicKey().Bytes()),
+		SubKey(signPriv.Public().(ed25519.PublicKey)),
+	}
+	priv := PrivateKey{
+		SubKey(encPriv.Bytes()),
+		SubKey(signSeed),
+	}
+	return KeyPair{Key(pub), Key(priv)}, nil
+}
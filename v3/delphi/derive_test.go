@@ -0,0 +1,64 @@
+package delphi
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPair_Derive(t *testing.T) {
+	root := deterministicKeyPair(t, 1)
+
+	billing, err := root.Derive("service/billing")
+	require.NoError(t, err)
+	require.NoError(t, billing.Validate())
+
+	t.Run("deterministic", func(t *testing.T) {
+		parent := deterministicKeyPair(t, 1)
+		again, err := parent.Derive("service/billing")
+		require.NoError(t, err)
+		assert.Equal(t, billing, again)
+	})
+
+	t.Run("independent", func(t *testing.T) {
+		search, err := root.Derive("service/search")
+		require.NoError(t, err)
+		assert.NotEqual(t, billing.PublicKey(), search.PublicKey())
+		assert.NotEqual(t, root.PublicKey(), billing.PublicKey())
+		assert.NotEqual(t, billing.PublicKey().Encryption(), search.PublicKey().Encryption())
+		assert.NotEqual(t, billing.PublicKey().Signing(), search.PublicKey().Signing())
+
+		parent := deterministicKeyPair(t, 2)
+		other, err := parent.Derive("service/billing")
+		require.NoError(t, err)
+		assert.NotEqual(t, billing.PublicKey(), other.PublicKey())
+	})
+
+	t.Run("grandchildren", func(t *testing.T) {
+		grandchild, err := billing.Derive("invoices")
+		require.NoError(t, err)
+		require.NoError(t, grandchild.Validate())
+		assert.NotEqual(t, billing.PublicKey(), grandchild.PublicKey())
+	})
+
+	t.Run("bad labels", func(t *testing.T) {
+		for _, label := range []string{"", "tab\there", "\xff", strings.Repeat("a", MaxLabelSize+1)} {
+			_, err := root.Derive(label)
+			assert.ErrorIs(t, err, ErrBadLabel, label)
+		}
+		_, err := ZeroKeyPair.Derive("service/billing")
+		assert.Error(t, err)
+	})
+
+	t.Run("restricted parents", func(t *testing.T) {
+		for _, c := range []Capability{CanSign, CanEncrypt} {
+			restricted, err := root.Restrict(c)
+			require.NoError(t, err)
+			child, err := restricted.Derive("service/billing")
+			assert.ErrorIs(t, err, ErrCapability, c.String())
+			assert.Equal(t, ZeroKeyPair, child)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package delphi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// FingerprintSize is the size of a Fingerprint in bytes.
+const FingerprintSize = sha256.Size
+
+// shortFingerprintSize is how many bytes of a Fingerprint its short form shows.
+const shortFingerprintSize = 8
+
+// A Fingerprint is a collision-resistant identifier for a public key: the SHA-256 of its canonical bytes.
+// Unlike a Nickname, two different keys will not share a Fingerprint.
+type Fingerprint [FingerprintSize]byte
+
+var ZeroFingerprint Fingerprint
+
+func (k PublicKey) Fingerprint() Fingerprint {
+	return sha256.Sum256(k.Bytes())
+}
+
+// String is the long form of a Fingerprint: lowercase hex.
+func (f Fingerprint) String() string {
+	return hex.EncodeToString(f[:])
+}
+
+// Short is the short form of a Fingerprint, good for display.
+// It is a prefix of the long form, so it can be used to look up a key.
+func (f Fingerprint) Short() string {
+	return hex.EncodeToString(f[:shortFingerprintSize])
+}
+
+// HasPrefix reports whether s is a prefix of the long form. Case is ignored.
+func (f Fingerprint) HasPrefix(s string) bool {
+	return s != "" && strings.HasPrefix(f.String(), strings.ToLower(s))
+}
+
+func (f Fingerprint) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *Fingerprint) UnmarshalText(text []byte) error {
+	fp, err := FingerprintFromString(string(text))
+	if err != nil {
+		return err
+	}
+	*f = fp
+	return nil
+}
+
+// FingerprintFromString parses the long form of a Fingerprint.
+func FingerprintFromString(s string) (Fingerprint, error) {
+	var fp Fingerprint
+	bin, err := hex.DecodeString(s)
+	if err != nil {
+		return fp, fmt.Errorf("could not decode fingerprint. %w", err)
+	}
+	if len(bin) != FingerprintSize {
+		return fp, fmt.Errorf("%w. fingerprint should be %d bytes, but it is %d", ErrWrongSize, FingerprintSize, len(bin))
+	}
+	copy(fp[:], bin)
+	return fp, nil
+}
@@ -0,0 +1,49 @@
+package delphi
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicKey_Fingerprint(t *testing.T) {
+
+	pub := PublicKey(NewKey(deterministicReader(t, 5)))
+
+	t.Run("is a hash of the canonical bytes", func(t *testing.T) {
+		assert.Equal(t, Fingerprint(sha256.Sum256(pub.Bytes())), pub.Fingerprint())
+	})
+
+	t.Run("keys sharing a nickname do not share a fingerprint", func(t *testing.T) {
+		twin := pub
+		twin[1][0] ^= 1
+		assert.Equal(t, pub.Nickname(), twin.Nickname())
+		assert.NotEqual(t, pub.Fingerprint(), twin.Fingerprint())
+	})
+
+	t.Run("short form is a prefix of the long form", func(t *testing.T) {
+		fp := pub.Fingerprint()
+		assert.Len(t, fp.String(), 2*FingerprintSize)
+		assert.Len(t, fp.Short(), 2*shortFingerprintSize)
+		assert.True(t, fp.HasPrefix(fp.Short()))
+		assert.False(t, fp.HasPrefix(""))
+	})
+
+	t.Run("text round trip", func(t *testing.T) {
+		fp := pub.Fingerprint()
+		text, err := fp.MarshalText()
+		require.NoError(t, err)
+		var got Fingerprint
+		require.NoError(t, got.UnmarshalText(text))
+		assert.Equal(t, fp, got)
+	})
+
+	t.Run("wrong size", func(t *testing.T) {
+		fp := pub.Fingerprint()
+		_, err := FingerprintFromString(fp.Short())
+		assert.ErrorIs(t, err, ErrWrongSize)
+	})
+
+}
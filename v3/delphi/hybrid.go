@@ -0,0 +1,271 @@
+package delphi
+
+import (
+	"bytes"
+	"crypto/mlkem"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// PEM types for hybrid keys, which carry an ML-KEM-768 key alongside the usual sub-keys.
+const (
+	PEMHybridPublicKey  = "ORACLE HYBRID PUBLIC KEY"
+	PEMHybridPrivateKey = "ORACLE HYBRID PRIVATE KEY"
+)
+
+const (
+	hybridInfo    = "oracle/hybrid/x25519+mlkem768"
+	kemSeedSize   = mlkem.SeedSize
+	EncapsKeySize = mlkem.EncapsulationKeySize768
+	KEMCipherSize = mlkem.CiphertextSize768
+)
+
+var ErrNotHybrid = errors.New("not a hybrid key")
+
+// A HybridKeyPair is a KeyPair plus an ML-KEM-768 decapsulation key, kept as its seed.
+// The seed is drawn from randomness of its own, not derived from the KeyPair,
+// so that whoever recovers the X25519 key, say with a quantum computer, learns nothing about the ML-KEM key.
+type HybridKeyPair struct {
+	KeyPair KeyPair
+	kemSeed [kemSeedSize]byte
+}
+
+// NewHybridKeyPair pairs kp with a new ML-KEM-768 key, seeded from randy. kp must be able to encrypt.
+// The HybridKeyPair holds its own copy of kp, which its Destroy wipes. kp is still the caller's to Destroy.
+func NewHybridKeyPair(randy io.Reader, kp *KeyPair) (*HybridKeyPair, error) {
+	if randy == nil {
+		return nil, errors.New("nil source of randomness")
+	}
+	if err := kp.Validate(); err != nil {
+		return nil, err
+	}
+	if !kp.Capabilities().Has(CanEncrypt) {
+		return nil, fmt.Errorf("%w. %w", ErrNotHybrid, ErrCapability)
+	}
+	h := &HybridKeyPair{KeyPair: *kp}
+	if _, err := io.ReadFull(randy, h.kemSeed[:]); err != nil {
+		return nil, fmt.Errorf("could not generate ML-KEM key. %w", err)
+	}
+	return h, nil
+}
+
+// DecapsulationKey is the HybridKeyPair's ML-KEM-768 private key.
+func (h *HybridKeyPair) DecapsulationKey() (*mlkem.DecapsulationKey768, error) {
+	if h.kemSeed == [kemSeedSize]byte{} {
+		return nil, fmt.Errorf("%w. %w", ErrNotHybrid, ErrZeroKey)
+	}
+	return mlkem.NewDecapsulationKey768(h.kemSeed[:])
+}
+
+// HybridPublicKey is the public half of the HybridKeyPair, to be published in place of its PublicKey.
+func (h *HybridKeyPair) HybridPublicKey() (HybridPublicKey, error) {
+	dk, err := h.DecapsulationKey()
+	if err != nil {
+		return HybridPublicKey{}, err
+	}
+	return HybridPublicKey{PublicKey: h.KeyPair.PublicKey(), KEM: dk.EncapsulationKey().Bytes()}, nil
+}
+
+// MarshalBinary is the KeyPair followed by the ML-KEM seed.
+func (h *HybridKeyPair) MarshalBinary() ([]byte, error) {
+	return append(h.KeyPair.Bytes(), h.kemSeed[:]...), nil
+}
+
+func (h *HybridKeyPair) UnmarshalBinary(b []byte) error {
+	const kpSize = subKeySize * 4
+	if len(b) != kpSize+kemSeedSize {
+		return fmt.Errorf("%w. hybrid keypair should be %d bytes, but it is %d", ErrWrongSize, kpSize+kemSeedSize, len(b))
+	}
+	kp, err := KeyPairFromBytes(b[:kpSize])
+	if err != nil {
+		return err
+	}
+	h.KeyPair = kp
+	copy(h.kemSeed[:], b[kpSize:])
+	if _, err := h.DecapsulationKey(); err != nil {
+		h.Destroy()
+		return err
+	}
+	return nil
+}
+
+func (h *HybridKeyPair) MarshalPEM() ([]byte, error) {
+	bin, _ := h.MarshalBinary()
+	defer Wipe(bin)
+	block := &pem.Block{
+		Type:  PEMHybridPrivateKey,
+		Bytes: bin,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (h *HybridKeyPair) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	defer Wipe(block.Bytes)
+	if block.Type != PEMHybridPrivateKey {
+		return fmt.Errorf("%w. wrong PEM type: %s", ErrNotHybrid, block.Type)
+	}
+	return h.UnmarshalBinary(block.Bytes)
+}
+
+// Restrict is like KeyPair.Restrict. The ML-KEM key is kept, so c must include CanEncrypt.
+func (h *HybridKeyPair) Restrict(c Capability) (*HybridKeyPair, error) {
+	if !c.Has(CanEncrypt) {
+		return nil, fmt.Errorf("%w. %w", ErrNotHybrid, ErrCapability)
+	}
+	kp, err := h.KeyPair.Restrict(c)
+	if err != nil {
+		return nil, err
+	}
+	return &HybridKeyPair{KeyPair: kp, kemSeed: h.kemSeed}, nil
+}
+
+// Destroy wipes the KeyPair and the ML-KEM seed.
+func (h *HybridKeyPair) Destroy() {
+	h.KeyPair.Destroy()
+	Wipe(h.kemSeed[:])
+}
+
+// A HybridPublicKey is a PublicKey plus an ML-KEM-768 encapsulation key.
+type HybridPublicKey struct {
+	PublicKey PublicKey
+	KEM       []byte
+}
+
+func (h HybridPublicKey) Bytes() []byte {
+	return append(h.PublicKey.Bytes(), h.KEM...)
+}
+
+func HybridPublicKeyFromBytes(b []byte) (HybridPublicKey, error) {
+	if len(b) != subKeySize*2+EncapsKeySize {
+		return HybridPublicKey{}, fmt.Errorf("%w. hybrid key should be %d bytes, but it is %d", ErrWrongSize, subKeySize*2+EncapsKeySize, len(b))
+	}
+	k, err := KeyFromBytes(b[:subKeySize*2])
+	if err != nil {
+		return HybridPublicKey{}, err
+	}
+	if _, err := mlkem.NewEncapsulationKey768(b[subKeySize*2:]); err != nil {
+		return HybridPublicKey{}, fmt.Errorf("%w. %w", ErrNotHybrid, err)
+	}
+	return HybridPublicKey{PublicKey: PublicKey(k), KEM: bytes.Clone(b[subKeySize*2:])}, nil
+}
+
+func (h HybridPublicKey) MarshalPEM() ([]byte, error) {
+	block := &pem.Block{
+		Type:  PEMHybridPublicKey,
+		Bytes: h.Bytes(),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+func (h *HybridPublicKey) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type != PEMHybridPublicKey {
+		return fmt.Errorf("%w. wrong PEM type: %s", ErrNotHybrid, block.Type)
+	}
+	got, err := HybridPublicKeyFromBytes(block.Bytes)
+	if err != nil {
+		return err
+	}
+	*h = got
+	return nil
+}
+
+// combineSecrets mixes the X25519 shared secret and the ML-KEM shared key in one HKDF step.
+// Like X-Wing, the salt binds the transcript: the X25519 ephemeral key, the recipient's X25519 key,
+// the ML-KEM ciphertext and the recipient's ML-KEM encapsulation key, in that order.
+// So the secret is tied to the recipient, and can't be replayed against another key pair sharing either half.
+func combineSecrets(x25519Secret, kemSecret, eph, kemCipherText []byte, to HybridPublicKey) ([]byte, error) {
+	ikm := append(bytes.Clone(x25519Secret), kemSecret...)
+	defer Wipe(ikm)
+	salt := make([]byte, 0, len(eph)+subKeySize+len(kemCipherText)+len(to.KEM))
+	salt = append(salt, eph...)
+	salt = append(salt, to.PublicKey.Encryption().Bytes()...)
+	salt = append(salt, kemCipherText...)
+	salt = append(salt, to.KEM...)
+	h := hkdf.New(sha256.New, ikm, salt, []byte(hybridInfo))
+	secret := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// GenerateHybridSecret is like GenerateSharedSecret, but the secret also depends on an ML-KEM-768 encapsulation.
+// Breaking it means breaking both X25519 and ML-KEM.
+// The ML-KEM encapsulation always draws from crypto/rand, whatever randomness is.
+func (kp *KeyPair) GenerateHybridSecret(randomness io.Reader, pub HybridPublicKey) (sharedSecret, ephemeralPubKey, kemCipherText []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(pub.KEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w. %w", ErrNotHybrid, err)
+	}
+	x25519Secret, eph, err := kp.GenerateSharedSecret(randomness, pub.PublicKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer Wipe(x25519Secret)
+	kemSecret, kemCipherText := ek.Encapsulate()
+	defer Wipe(kemSecret)
+	sharedSecret, err = combineSecrets(x25519Secret, kemSecret, eph, kemCipherText, pub)
+	return sharedSecret, eph, kemCipherText, err
+}
+
+// ExtractHybridSecret is the recipient's side of GenerateHybridSecret.
+func (h *HybridKeyPair) ExtractHybridSecret(ephemeralPubKey, kemCipherText []byte) ([]byte, error) {
+	x25519Secret, err := h.KeyPair.ExtractSharedSecret(ephemeralPubKey)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(x25519Secret)
+	dk, err := h.DecapsulationKey()
+	if err != nil {
+		return nil, err
+	}
+	kemSecret, err := dk.Decapsulate(kemCipherText)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(kemSecret)
+	to := HybridPublicKey{PublicKey: h.KeyPair.PublicKey(), KEM: dk.EncapsulationKey().Bytes()}
+	return combineSecrets(x25519Secret, kemSecret, ephemeralPubKey, kemCipherText, to)
+}
+
+// DecryptHybrid is like Decrypt, for messages encrypted with a hybrid secret.
+func (h *HybridKeyPair) DecryptHybrid(msg, eph, kemCipherText, nonce, aad []byte) ([]byte, error) {
+	sharedSec, err := h.ExtractHybridSecret(eph, kemCipherText)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	defer Wipe(sharedSec)
+	suite, err := SuiteByID(DefaultSuite)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	plaintext, err := suite.Open(sharedSec, msg, nonce, aad)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	return plaintext, nil
+}
+
+//	A HybridKeyPair can also decrypt whatever its KeyPair can.
+
+func (h *HybridKeyPair) Decrypt(msg, eph, nonce, aad []byte) ([]byte, error) {
+	return h.KeyPair.Decrypt(msg, eph, nonce, aad)
+}
+
+func (h *HybridKeyPair) ExtractSharedSecret(ephemeralPubKey []byte) ([]byte, error) {
+	return h.KeyPair.ExtractSharedSecret(ephemeralPubKey)
+}
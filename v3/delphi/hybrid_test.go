@@ -0,0 +1,146 @@
+package delphi
+
+import (
+	"bytes"
+	"crypto/mlkem"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/hkdf"
+)
+
+func TestKeyPair_Hybrid(t *testing.T) {
+	alice := deterministicKeyPair(t, 1)
+	bobClassical := deterministicKeyPair(t, 2)
+	bob, err := NewHybridKeyPair(deterministicReader(t, 5), &bobClassical)
+	require.NoError(t, err)
+
+	bobPub, err := bob.HybridPublicKey()
+	require.NoError(t, err)
+	assert.Equal(t, bobClassical.PublicKey(), bobPub.PublicKey)
+	assert.Len(t, bobPub.KEM, EncapsKeySize)
+
+	t.Run("independent of the classical key", func(t *testing.T) {
+		//	the same KeyPair, with fresh randomness, gets a different ML-KEM key
+		again, err := NewHybridKeyPair(deterministicReader(t, 6), &bobClassical)
+		require.NoError(t, err)
+		againPub, err := again.HybridPublicKey()
+		require.NoError(t, err)
+		assert.Equal(t, bobPub.PublicKey, againPub.PublicKey)
+		assert.NotEqual(t, bobPub.KEM, againPub.KEM)
+
+		//	in particular, it is not the one that used to be derived from the X25519 key
+		h := hkdf.New(sha256.New, bobClassical.PrivateKey().Encryption().Bytes(), nil, []byte("oracle/mlkem768/seed"))
+		seed := make([]byte, mlkem.SeedSize)
+		_, err = io.ReadFull(h, seed)
+		require.NoError(t, err)
+		derived, err := mlkem.NewDecapsulationKey768(seed)
+		require.NoError(t, err)
+		assert.NotEqual(t, derived.EncapsulationKey().Bytes(), bobPub.KEM)
+	})
+
+	t.Run("shared secret", func(t *testing.T) {
+		sec, eph, kem, err := alice.GenerateHybridSecret(deterministicReader(t, 3), bobPub)
+		require.NoError(t, err)
+		assert.Len(t, kem, KEMCipherSize)
+		got, err := bob.ExtractHybridSecret(eph, kem)
+		require.NoError(t, err)
+		assert.Equal(t, sec, got)
+
+		//	the X25519 secret alone is not enough
+		x25519Only, err := bob.ExtractSharedSecret(eph)
+		require.NoError(t, err)
+		assert.NotEqual(t, sec, x25519Only)
+
+		//	nor is the right X25519 key with the wrong ML-KEM key
+		impostor, err := NewHybridKeyPair(deterministicReader(t, 4), &bobClassical)
+		require.NoError(t, err)
+		wrong, err := impostor.ExtractHybridSecret(eph, kem)
+		require.NoError(t, err)
+		assert.NotEqual(t, sec, wrong)
+	})
+
+	t.Run("PEM round trips", func(t *testing.T) {
+		data, err := bobPub.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), PEMHybridPublicKey)
+		gotPub := new(HybridPublicKey)
+		require.NoError(t, gotPub.UnmarshalPEM(data))
+		assert.Equal(t, bobPub, *gotPub)
+
+		data, err = bob.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), PEMHybridPrivateKey)
+		got := new(HybridKeyPair)
+		require.NoError(t, got.UnmarshalPEM(data))
+		assert.Equal(t, bob, got)
+		assert.Error(t, got.UnmarshalPEM(gotPubPEM(t, bobPub)))
+	})
+
+	t.Run("destroy", func(t *testing.T) {
+		kp := deterministicKeyPair(t, 8)
+		h, err := NewHybridKeyPair(deterministicReader(t, 7), &kp)
+		require.NoError(t, err)
+		h.Destroy()
+		assert.Equal(t, ZeroKeyPair, h.KeyPair)
+		_, err = h.DecapsulationKey()
+		assert.ErrorIs(t, err, ErrNotHybrid)
+	})
+
+	t.Run("bad keys", func(t *testing.T) {
+		_, err := HybridPublicKeyFromBytes(bobPub.Bytes()[:100])
+		assert.ErrorIs(t, err, ErrWrongSize)
+		_, _, _, err = alice.GenerateHybridSecret(deterministicReader(t, 3), HybridPublicKey{PublicKey: bobClassical.PublicKey()})
+		assert.ErrorIs(t, err, ErrNotHybrid)
+		_, err = (&HybridKeyPair{KeyPair: bobClassical}).DecapsulationKey()
+		assert.ErrorIs(t, err, ErrNotHybrid)
+		_, err = NewHybridKeyPair(deterministicReader(t, 3), new(KeyPair))
+		assert.ErrorIs(t, err, ErrZeroKey)
+		err = new(HybridKeyPair).UnmarshalBinary(make([]byte, 10))
+		assert.ErrorIs(t, err, ErrWrongSize)
+	})
+}
+
+func TestCombineSecrets(t *testing.T) {
+	fill := func(b byte, n int) []byte { return bytes.Repeat([]byte{b}, n) }
+	x25519Secret, kemSecret := fill(1, 32), fill(2, 32)
+	eph, kemCipherText := fill(3, subKeySize), fill(4, KEMCipherSize)
+	to := HybridPublicKey{
+		PublicKey: PublicKey{SubKey(fill(5, subKeySize)), SubKey(fill(6, subKeySize))},
+		KEM:       fill(7, EncapsKeySize),
+	}
+
+	combine := func(eph, kemCipherText []byte, to HybridPublicKey) []byte {
+		t.Helper()
+		got, err := combineSecrets(x25519Secret, kemSecret, eph, kemCipherText, to)
+		require.NoError(t, err)
+		return got
+	}
+
+	t.Run("known answer", func(t *testing.T) {
+		assert.Equal(t, "4c62095cf470eb422880cebf4567b670ede73d0e8da8c307cd56faa5b2515acb", hex.EncodeToString(combine(eph, kemCipherText, to)))
+	})
+
+	t.Run("binds the transcript", func(t *testing.T) {
+		want := combine(eph, kemCipherText, to)
+		otherX25519 := to
+		otherX25519.PublicKey = PublicKey{SubKey(fill(8, subKeySize)), to.PublicKey.Signing()}
+		otherKEM := to
+		otherKEM.KEM = fill(8, EncapsKeySize)
+		assert.NotEqual(t, want, combine(fill(8, subKeySize), kemCipherText, to), "ephemeral key")
+		assert.NotEqual(t, want, combine(eph, fill(8, KEMCipherSize), to), "ciphertext")
+		assert.NotEqual(t, want, combine(eph, kemCipherText, otherX25519), "recipient X25519 key")
+		assert.NotEqual(t, want, combine(eph, kemCipherText, otherKEM), "recipient ML-KEM key")
+	})
+}
+
+func gotPubPEM(t *testing.T, pub HybridPublicKey) []byte {
+	t.Helper()
+	data, err := pub.MarshalPEM()
+	require.NoError(t, err)
+	return data
+}
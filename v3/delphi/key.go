@@ -29,9 +29,17 @@ func (p PrivateKey) Equal(k crypto.PublicKey) bool {
 	return Key(p).Equal(k.(Key))
 }
 
-func (k Key) MustBeValid() {
+// Validate is like MustBeValid, but returns an error instead of panicking.
+func (k Key) Validate() error {
 	if k == ZeroKey {
-		panic("zero key")
+		return ErrZeroKey
+	}
+	return nil
+}
+
+func (k Key) MustBeValid() {
+	if err := k.Validate(); err != nil {
+		panic(err)
 	}
 }
 
@@ -59,17 +67,17 @@ func (k PublicKey) Bytes() []byte {
 	return Key(k).Bytes()
 }
 
+// toInt64 reads the first 8 bytes of a Key as a big-endian integer.
+// A sign-only Key has no encryption SubKey, so its signing SubKey is read instead.
 func (k Key) toInt64() int64 {
-	var num int64
-	buf := bytes.NewReader(k.Bytes())
-	err := binary.Read(buf, binary.BigEndian, &num)
-	if err != nil {
-		// Handle the error appropriately
+	if k[0].IsZero() {
+		return int64(binary.BigEndian.Uint64(k[1][:8]))
 	}
-	return num
+	return int64(binary.BigEndian.Uint64(k[0][:8]))
 }
 
-// A Nickname is a very memorable string for humans only. It has weak uniqueness that is good enough for some uses.
+// A Nickname is a very memorable string for humans only. It is a friendly label, not an identifier:
+// it is derived from only 64 bits of the key, so two keys can share one. Use a Fingerprint to identify a key.
 func (k PublicKey) Nickname() string {
 	seed := Key(k).toInt64()
 	nameGenerator := namegenerator.NewNameGenerator(seed)
@@ -91,7 +99,7 @@ func (k *Key) Write(p []byte) (int, error) {
 	//}
 	sizeShouldBe := 2 * subKeySize
 	if len(p) < sizeShouldBe {
-		return 0, fmt.Errorf("size should be %d, but we got %d", sizeShouldBe, len(p))
+		return 0, fmt.Errorf("%w. size should be %d, but we got %d", ErrWrongSize, sizeShouldBe, len(p))
 	}
 	copy(k[0][:], p[:subKeySize])
 	copy(k[1][:], p[subKeySize:subKeySize*2])
@@ -104,7 +112,7 @@ func (k *PublicKey) Write(p []byte) (int, error) {
 	//}
 	sizeShouldBe := 2 * subKeySize
 	if len(p) < sizeShouldBe {
-		return 0, fmt.Errorf("size should be %d, but we got %d", sizeShouldBe, len(p))
+		return 0, fmt.Errorf("%w. size should be %d, but we got %d", ErrWrongSize, sizeShouldBe, len(p))
 	}
 	copy(k[0][:], p[:subKeySize])
 	copy(k[1][:], p[subKeySize:subKeySize*2])
@@ -125,6 +133,14 @@ func (k Key) Read(p []byte) (int, error) {
 	return i + j, io.EOF
 }
 
+// MarshalBinary is like Read, but returns an error instead of panicking on a zero Key.
+func (k Key) MarshalBinary() ([]byte, error) {
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k.Bytes(), nil
+}
+
 func (k Key) Equal(j Key) bool {
 	return bytes.Equal(k.Bytes(), j.Bytes())
 }
@@ -147,7 +163,7 @@ func KeyFromString(s string) (Key, error) {
 
 func KeyFromBytes(b []byte) (Key, error) {
 	if len(b) != subKeySize*2 {
-		return ZeroKey, fmt.Errorf("invalid key size, expected %d, got %d", subKeySize*2, len(b))
+		return ZeroKey, fmt.Errorf("%w. invalid key size, expected %d, got %d", ErrWrongSize, subKeySize*2, len(b))
 	}
 	k := &Key{}
 	_, err := k.Write(b)
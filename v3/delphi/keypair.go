@@ -1,6 +1,7 @@
 package delphi
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdh"
 	"crypto/ed25519"
@@ -8,6 +9,7 @@ import (
 	"encoding"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 
@@ -16,13 +18,31 @@ import (
 	"golang.org/x/crypto/hkdf"
 )
 
+// A KeyPair is a PublicKey and its PrivateKey. Being an array, it is copied whenever it is passed by value,
+// so its methods take a *KeyPair, all but MarshalJSON. Hold one by pointer, and Destroy it when done.
 type KeyPair [2]Key
 
 var ZeroKeyPair KeyPair
 
+// MarshalJSON takes a KeyPair by value, unlike the other methods that read the private key,
+// because encoding/json only calls pointer methods on addressable values, and would otherwise write a KeyPair value
+// as a raw array of bytes. The copy is no more exposed than the output, which is the private key itself.
 func (kp KeyPair) MarshalJSON() ([]byte, error) {
-	str := kp.String()
-	return json.Marshal(str)
+	text, err := kp.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(text))
+}
+
+// MarshalText is like String, but returns an error instead of panicking on a zero Key.
+func (kp *KeyPair) MarshalText() ([]byte, error) {
+	for _, k := range kp {
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(hex.EncodeToString(kp.Bytes())), nil
 }
 
 func (kp *KeyPair) UnmarshalJSON(data []byte) error {
@@ -39,22 +59,71 @@ func (kp *KeyPair) UnmarshalJSON(data []byte) error {
 	return err
 }
 
-func (kp KeyPair) Bytes() []byte {
+func (kp *KeyPair) Bytes() []byte {
 	///kp.MustBeValid()
 	return append(kp[0].Bytes(), kp[1].Bytes()...)
 }
 
-func (kp KeyPair) MustBeValid() {
+func (kp *KeyPair) MustBeValid() {
 	kp[0].MustBeValid()
 	kp[1].MustBeValid()
 }
 
+var ErrKeyMismatch = errors.New("public key does not match private key")
+
+// Validate checks that neither Key is zero, and that each public SubKey is derived from its private SubKey.
+// A restricted KeyPair must lack the same capabilities in both its Keys.
+// Unlike MustBeValid, it returns an error rather than panicking.
+func (kp *KeyPair) Validate() error {
+	for _, k := range kp {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+	}
+	if capabilitiesOf(kp[0]) != capabilitiesOf(kp[1]) {
+		return fmt.Errorf("%w. capabilities", ErrKeyMismatch)
+	}
+	caps := kp.Capabilities()
+	pub, priv := kp.PublicKey(), kp.PrivateKey()
+	if caps.Has(CanEncrypt) {
+		encPriv, err := ecdh.X25519().NewPrivateKey(priv.Encryption().Bytes())
+		if err != nil {
+			return fmt.Errorf("%w. %w", ErrKeyMismatch, err)
+		}
+		if !bytes.Equal(encPriv.PublicKey().Bytes(), pub.Encryption().Bytes()) {
+			return fmt.Errorf("%w. encryption", ErrKeyMismatch)
+		}
+	}
+	if caps.Has(CanSign) {
+		signPub := ed25519.NewKeyFromSeed(priv.Signing().Bytes()).Public().(ed25519.PublicKey)
+		if !bytes.Equal(signPub, pub.Signing().Bytes()) {
+			return fmt.Errorf("%w. signing", ErrKeyMismatch)
+		}
+	}
+	return nil
+}
+
+// KeyPairFromBytes is like Write, but insists on exactly the right size, and on a valid KeyPair.
+func KeyPairFromBytes(b []byte) (KeyPair, error) {
+	kp := KeyPair{}
+	if len(b) != subKeySize*4 {
+		return kp, fmt.Errorf("%w. keypair should be %d bytes, but it is %d", ErrWrongSize, subKeySize*4, len(b))
+	}
+	if _, err := kp.Write(b); err != nil {
+		return ZeroKeyPair, err
+	}
+	if err := kp.Validate(); err != nil {
+		return ZeroKeyPair, err
+	}
+	return kp, nil
+}
+
 func (kp *KeyPair) Write(p []byte) (int, error) {
 
 	const keySize = subKeySize * 2
 
 	if len(p) < keySize*2 {
-		return 0, io.ErrShortWrite
+		return 0, fmt.Errorf("%w. %w", ErrWrongSize, io.ErrShortWrite)
 	}
 	_, err := kp[0].Write(p[:keySize])
 	if err != nil {
@@ -84,19 +153,31 @@ func NewKeyPair(randy io.Reader) KeyPair {
 	if randy == nil {
 		return ZeroKeyPair
 	}
+	kp, err := GenerateKeyPair(randy)
+	if err != nil {
+		panic(err)
+	}
+	return kp
+}
+
+// GenerateKeyPair is like NewKeyPair, but returns an error instead of panicking when randy fails.
+func GenerateKeyPair(randy io.Reader) (KeyPair, error) {
+	if randy == nil {
+		return ZeroKeyPair, errors.New("nil source of randomness")
+	}
 
 	//	encryption keys
 	ed := ecdh.X25519()
 	encryptionPriv, err := ed.GenerateKey(randy)
 	if err != nil {
-		panic(err)
+		return ZeroKeyPair, fmt.Errorf("could not generate encryption key. %w", err)
 	}
 	encryptionPub := encryptionPriv.PublicKey()
 
 	//	signing keys
 	signPub, signPriv, err := ed25519.GenerateKey(randy)
 	if err != nil {
-		panic(err)
+		return ZeroKeyPair, fmt.Errorf("could not generate signing key. %w", err)
 	}
 
 	priv := PrivateKey{
@@ -114,19 +195,19 @@ func NewKeyPair(randy io.Reader) KeyPair {
 		Key(priv),
 	}
 
-	return kp
+	return kp, nil
 }
 
-func (kp KeyPair) PublicKey() PublicKey {
+func (kp *KeyPair) PublicKey() PublicKey {
 	return PublicKey(kp[0])
 }
 
-func (kp KeyPair) PrivateKey() PrivateKey {
+func (kp *KeyPair) PrivateKey() PrivateKey {
 	return PrivateKey(kp[1])
 }
 
 // A PrivateSigningKey contains both private and public key material. That's just how ed25519 works
-func (kp KeyPair) PrivateSigningKey() Key {
+func (kp *KeyPair) PrivateSigningKey() Key {
 	bin1 := kp.PrivateKey().Signing().Bytes()
 	bin2 := kp.PublicKey().Signing().Bytes()
 	k := new(Key)
@@ -135,41 +216,61 @@ func (kp KeyPair) PrivateSigningKey() Key {
 }
 
 // String is a hex representation of the key.
-func (kp KeyPair) String() string {
+func (kp *KeyPair) String() string {
 	kp.MustBeValid()
 	return hex.EncodeToString(kp.Bytes())
 }
 
 // Sign signs a digest. This satisfies crypto.Signer.
-func (kp KeyPair) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
-	privKey := ed25519.PrivateKey(kp.PrivateSigningKey().Bytes())
+func (kp *KeyPair) Sign(_ io.Reader, digest []byte, _ crypto.SignerOpts) ([]byte, error) {
+	if !kp.Capabilities().Has(CanSign) {
+		return nil, fmt.Errorf("could not sign. %w", ErrCapability)
+	}
+	privKey := ed25519.NewKeyFromSeed(kp.PrivateKey().Signing().Bytes())
+	defer Wipe(privKey)
 	sig := ed25519.Sign(privKey, digest)
 	return sig, nil
 }
 
-func (kp KeyPair) Verify(pubKey crypto.PublicKey, digest []byte, signature []byte) bool {
+// ErrBadSignature is the one error every package returns for a signature that does not verify.
+var ErrBadSignature = errors.New("bad signature")
+
+// Verify checks an ed25519 signature. pubKey can be a signing SubKey, its bytes, or a whole PublicKey,
+// whose signing SubKey is used.
+func (kp *KeyPair) Verify(pubKey crypto.PublicKey, digest []byte, signature []byte) bool {
+	if pub, ok := pubKey.(PublicKey); ok {
+		pubKey = pub.Signing()
+	}
 	pubBytes, err := asBytes(pubKey)
-	if err != nil {
+	if err != nil || len(pubBytes) != ed25519.PublicKeySize {
+		return false
+	}
+	//	a missing signing key must not verify anything
+	if bytes.Equal(pubBytes, zeroSubKey[:]) {
 		return false
 	}
 	return ed25519.Verify(pubBytes, digest, signature)
 }
 
-var _ crypto.Signer = KeyPair{}
+var _ crypto.Signer = (*KeyPair)(nil)
 
-func (kp KeyPair) Public() crypto.PublicKey {
+func (kp *KeyPair) Public() crypto.PublicKey {
 	return kp.PublicKey()
 }
 
-// extractSharedSecret calculates a shared secret using a shared ephemeral public key, and the principal's own key material.
+// ExtractSharedSecret calculates a shared secret using a shared ephemeral public key, and the principal's own key material.
 // This is possible because the ephemeral key was generated using the recipient's public key.
-func (kp KeyPair) extractSharedSecret(ephemeralPubKey []byte) ([]byte, error) {
+func (kp *KeyPair) ExtractSharedSecret(ephemeralPubKey []byte) ([]byte, error) {
+	if !kp.Capabilities().Has(CanEncrypt) {
+		return nil, fmt.Errorf("could not decrypt. %w", ErrCapability)
+	}
 	recipientPrivKey := kp.PrivateKey().Encryption().Bytes()
 	recipientPubKey := kp.PublicKey().Encryption().Bytes()
 	sharedScalar, err := curve25519.X25519(recipientPrivKey, ephemeralPubKey)
 	if err != nil {
 		return nil, err
 	}
+	defer Wipe(sharedScalar)
 
 	salt := make([]byte, len(ephemeralPubKey)+len(recipientPubKey))
 	copy(salt[:len(ephemeralPubKey)], ephemeralPubKey)
@@ -183,28 +284,29 @@ func (kp KeyPair) extractSharedSecret(ephemeralPubKey []byte) ([]byte, error) {
 	return sharedSecret, nil
 }
 
-func (kp KeyPair) Decrypt(msg, eph, nonce, aad []byte) (plaintext []byte, err error) {
-	sharedSec, err := kp.extractSharedSecret(eph)
+func (kp *KeyPair) Decrypt(msg, eph, nonce, aad []byte) (plaintext []byte, err error) {
+	sharedSec, err := kp.ExtractSharedSecret(eph)
 	if err != nil {
 		return nil, fmt.Errorf("could not decrypt. %w", err)
 	}
-	cipher, err := chacha20poly1305.New(sharedSec)
+	defer Wipe(sharedSec)
+	suite, err := SuiteByID(DefaultSuite)
 	if err != nil {
 		return nil, fmt.Errorf("could not decrypt. %w", err)
 	}
-	plaintext, err = cipher.Open(nil, nonce, msg, aad)
+	plaintext, err = suite.Open(sharedSec, msg, nonce, aad)
 	if err != nil {
 		return nil, fmt.Errorf("could not decrypt. %w", err)
 	}
 	return plaintext, nil
 }
 
-func (kp KeyPair) Seal(sec []byte, plainText []byte, nonce []byte, aad []byte) ([]byte, error) {
-	sealer, err := chacha20poly1305.New(sec)
+func (kp *KeyPair) Seal(sec []byte, plainText []byte, nonce []byte, aad []byte) ([]byte, error) {
+	suite, err := SuiteByID(DefaultSuite)
 	if err != nil {
 		return nil, err
 	}
-	return sealer.Seal(nil, nonce, plainText, aad), nil
+	return suite.Seal(sec, plainText, nonce, aad)
 }
 
 // asBytes takes a thing and tries its best to return it as a byte-slice.
@@ -226,12 +328,12 @@ func asBytes(thing any) ([]byte, error) {
 	return marshaler.MarshalBinary()
 }
 
-func (kp KeyPair) GenerateSharedSecret(randomness io.Reader, pubKey PublicKey) (sharedSecret []byte, ephemeralPubKey []byte, err error) {
-
+func (kp *KeyPair) GenerateSharedSecret(randomness io.Reader, pubKey PublicKey) (sharedSecret []byte, ephemeralPubKey []byte, err error) {
 	counterPartyPubKey := pubKey.Encryption().Bytes()
 
 	//	generate an ephemeral private key
 	ephemeralPrivKey := make([]byte, curve25519.ScalarSize)
+	defer Wipe(ephemeralPrivKey)
 	if _, err := randomness.Read(ephemeralPrivKey); err != nil {
 		return nil, nil, err
 	}
@@ -247,6 +349,7 @@ func (kp KeyPair) GenerateSharedSecret(randomness io.Reader, pubKey PublicKey) (
 	if err != nil {
 		return nil, nil, err
 	}
+	defer Wipe(secretScalar)
 
 	//	our salt is the ephemeral public key plus the counterparty's public key
 	salt := make([]byte, len(ephemeralPubKey)+len(counterPartyPubKey))
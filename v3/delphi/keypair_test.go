@@ -1,6 +1,7 @@
 package delphi
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
@@ -83,6 +84,54 @@ func TestKeyPair_MustBeValid(t *testing.T) {
 	})
 }
 
+func TestKeyPair_Validate(t *testing.T) {
+	t.Run("valid keypair", func(t *testing.T) {
+		kp := deterministicKeyPair(t, 3)
+		assert.NoError(t, kp.Validate())
+	})
+
+	t.Run("zero keypair", func(t *testing.T) {
+		var zero KeyPair
+		assert.ErrorIs(t, zero.Validate(), ErrZeroKey)
+		_, err := zero.MarshalText()
+		assert.ErrorIs(t, err, ErrZeroKey)
+		_, err = json.Marshal(KeyPair{})
+		assert.ErrorIs(t, err, ErrZeroKey)
+	})
+
+	t.Run("mismatched keys", func(t *testing.T) {
+		kp := deterministicKeyPair(t, 3)
+		kp[0] = deterministicKeyPair(t, 4)[0]
+		assert.ErrorIs(t, kp.Validate(), ErrKeyMismatch)
+	})
+}
+
+func TestKeyPairFromBytes(t *testing.T) {
+	kp := deterministicKeyPair(t, 3)
+	got, err := KeyPairFromBytes(kp.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, kp, got)
+
+	_, err = KeyPairFromBytes(kp.Bytes()[:100])
+	assert.ErrorIs(t, err, ErrWrongSize)
+	_, err = KeyPairFromBytes(append(kp.Bytes(), 0))
+	assert.ErrorIs(t, err, ErrWrongSize)
+	_, err = KeyPairFromBytes(make([]byte, 128))
+	assert.ErrorIs(t, err, ErrZeroKey)
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	kp, err := GenerateKeyPair(deterministicReader(t, 3))
+	require.NoError(t, err)
+	assert.Equal(t, deterministicKeyPair(t, 3), kp)
+	assert.NoError(t, kp.Validate())
+
+	_, err = GenerateKeyPair(nil)
+	assert.Error(t, err)
+	_, err = GenerateKeyPair(bytes.NewReader([]byte("too short")))
+	assert.Error(t, err)
+}
+
 func TestKeyPair_Write(t *testing.T) {
 	t.Run("insufficient data", func(t *testing.T) {
 		kp := &KeyPair{}
@@ -200,6 +249,19 @@ func TestKeyPair_Verify(t *testing.T) {
 		assert.False(t, isValid)
 	})
 
+	t.Run("whole public key", func(t *testing.T) {
+		assert.True(t, kp.Verify(kp.PublicKey(), digest[:], signature))
+		other := deterministicKeyPair(t, 6)
+		assert.False(t, kp.Verify(other.PublicKey(), digest[:], signature))
+	})
+
+	t.Run("wrong size public key", func(t *testing.T) {
+		assert.NotPanics(t, func() {
+			assert.False(t, kp.Verify(kp.PublicKey().Bytes(), digest[:], signature))
+			assert.False(t, kp.Verify([]byte("short"), digest[:], signature))
+		})
+	})
+
 	t.Run("invalid public key", func(t *testing.T) {
 		invalidPubKey := "not a valid key"
 		isValid := kp.Verify(invalidPubKey, digest[:], signature)
@@ -0,0 +1,115 @@
+package delphi
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A mnemonic is a BIP39 phrase of 24 words, encoding 256 bits of entropy and an 8-bit checksum.
+// Keys are derived from the entropy, not from the BIP39 seed, like so:
+//
+//	encryption key = HKDF-SHA256(ikm: entropy, salt: "oracle/mnemonic/v1", info: "oracle/mnemonic/x25519")
+//	signing seed   = HKDF-SHA256(ikm: entropy, salt: "oracle/mnemonic/v1", info: "oracle/mnemonic/ed25519")
+//
+// each 32 bytes long. The signing seed is expanded into an ed25519 key as in RFC 8032.
+const (
+	MnemonicWords   = 24
+	mnemonicEntropy = 32
+	mnemonicSalt    = "oracle/mnemonic/v1"
+	mnemonicX25519  = "oracle/mnemonic/x25519"
+	mnemonicEd25519 = "oracle/mnemonic/ed25519"
+)
+
+var ErrBadMnemonic = errors.New("bad mnemonic")
+
+// wordlist is the BIP39 English wordlist.
+//
+//go:embed wordlist.txt
+var wordlist string
+
+var (
+	words     = strings.Fields(wordlist)
+	wordIndex = func() map[string]int {
+		m := make(map[string]int, len(words))
+		for i, w := range words {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+// NewMnemonic generates a new 24-word phrase. Recover the KeyPair with KeyPairFromMnemonic.
+func NewMnemonic(randy io.Reader) (string, error) {
+	if randy == nil {
+		return "", errors.New("nil source of randomness")
+	}
+	entropy := make([]byte, mnemonicEntropy)
+	defer Wipe(entropy)
+	if _, err := io.ReadFull(randy, entropy); err != nil {
+		return "", fmt.Errorf("could not generate mnemonic. %w", err)
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// KeyPairFromMnemonic deterministically derives a KeyPair from a 24-word phrase.
+// Case and whitespace are not significant.
+func KeyPairFromMnemonic(phrase string) (KeyPair, error) {
+	entropy, err := mnemonicToEntropy(phrase)
+	if err != nil {
+		return ZeroKeyPair, err
+	}
+	defer Wipe(entropy)
+	return keyPairFromEntropy(entropy)
+}
+
+// entropyToMnemonic appends a checksum, the first byte of the entropy's SHA-256,
+// and spells the result out 11 bits at a time.
+func entropyToMnemonic(entropy []byte) string {
+	sum := sha256.Sum256(entropy)
+	bits := append(append([]byte{}, entropy...), sum[0])
+	defer Wipe(bits)
+	phrase := make([]string, MnemonicWords)
+	for i := range phrase {
+		n := 0
+		for j := i * 11; j < (i+1)*11; j++ {
+			n = n<<1 | int(bits[j/8]>>(7-j%8)&1)
+		}
+		phrase[i] = words[n]
+	}
+	return strings.Join(phrase, " ")
+}
+
+func mnemonicToEntropy(phrase string) ([]byte, error) {
+	fields := strings.Fields(strings.ToLower(phrase))
+	if len(fields) != MnemonicWords {
+		return nil, fmt.Errorf("%w. want %d words, not %d", ErrBadMnemonic, MnemonicWords, len(fields))
+	}
+	bits := make([]byte, mnemonicEntropy+1)
+	defer Wipe(bits)
+	for i, w := range fields {
+		n, ok := wordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("%w. %q is not in the wordlist", ErrBadMnemonic, w)
+		}
+		for j := 0; j < 11; j++ {
+			if n>>(10-j)&1 == 1 {
+				k := i*11 + j
+				bits[k/8] |= 1 << (7 - k%8)
+			}
+		}
+	}
+	sum := sha256.Sum256(bits[:mnemonicEntropy])
+	if sum[0] != bits[mnemonicEntropy] {
+		return nil, fmt.Errorf("%w. bad checksum", ErrBadMnemonic)
+	}
+	//	bits is wiped on the way out. The caller wipes this copy
+	return append([]byte{}, bits[:mnemonicEntropy]...), nil
+}
+
+func keyPairFromEntropy(entropy []byte) (KeyPair, error) {
+	return keyPairFromSecret(entropy, mnemonicSalt, mnemonicX25519, mnemonicEd25519)
+}
@@ -0,0 +1,62 @@
+package delphi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMnemonic(t *testing.T) {
+	t.Run("BIP39 test vectors", func(t *testing.T) {
+		zero := strings.Repeat("abandon ", 23) + "art"
+		assert.Equal(t, zero, entropyToMnemonic(make([]byte, 32)))
+		ones := strings.Repeat("zoo ", 23) + "vote"
+		assert.Equal(t, ones, entropyToMnemonic(bytes.Repeat([]byte{0xff}, 32)))
+		got, err := mnemonicToEntropy(ones)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte{0xff}, 32), got)
+	})
+
+	phrase, err := NewMnemonic(deterministicReader(t, 1))
+	require.NoError(t, err)
+	assert.Len(t, strings.Fields(phrase), MnemonicWords)
+
+	kp, err := KeyPairFromMnemonic(phrase)
+	require.NoError(t, err)
+	require.NoError(t, kp.Validate())
+
+	t.Run("recovery is deterministic", func(t *testing.T) {
+		again, err := KeyPairFromMnemonic("  " + strings.ToUpper(phrase) + "\n")
+		require.NoError(t, err)
+		assert.Equal(t, kp, again)
+		assert.Equal(t, kp.PublicKey().Nickname(), again.PublicKey().Nickname())
+	})
+
+	t.Run("it can sign and encrypt", func(t *testing.T) {
+		sig, err := kp.Sign(nil, []byte("data"), nil)
+		require.NoError(t, err)
+		assert.True(t, kp.Verify(kp.PublicKey().Signing(), []byte("data"), sig))
+		sender := deterministicKeyPair(t, 2)
+		sec, eph, err := sender.GenerateSharedSecret(deterministicReader(t, 3), kp.PublicKey())
+		require.NoError(t, err)
+		got, err := kp.ExtractSharedSecret(eph)
+		require.NoError(t, err)
+		assert.Equal(t, sec, got)
+	})
+
+	t.Run("bad phrases", func(t *testing.T) {
+		fields := strings.Fields(phrase)
+		_, err := KeyPairFromMnemonic(strings.Join(fields[:12], " "))
+		assert.ErrorIs(t, err, ErrBadMnemonic)
+
+		fields[0] = "oracle"
+		_, err = KeyPairFromMnemonic(strings.Join(fields, " "))
+		assert.ErrorIs(t, err, ErrBadMnemonic)
+
+		_, err = KeyPairFromMnemonic(strings.Repeat("abandon ", 24))
+		assert.ErrorIs(t, err, ErrBadMnemonic)
+	})
+}
@@ -0,0 +1,84 @@
+package delphi
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// Private keys and shared secrets are plain byte arrays, so every copy is another place a secret can linger.
+// Wipe secrets as soon as they are no longer needed, and hold a KeyPair by pointer so that Destroy reaches it.
+
+var ErrNotLocked = errors.New("could not lock memory")
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	clear(b)
+	//	keep the compiler from deciding the writes are dead
+	runtime.KeepAlive(b)
+}
+
+// Destroy wipes the SubKey.
+func (s *SubKey) Destroy() {
+	Wipe(s[:])
+}
+
+// Destroy wipes both SubKeys of the PrivateKey.
+func (k *PrivateKey) Destroy() {
+	k[0].Destroy()
+	k[1].Destroy()
+}
+
+// Destroy wipes the KeyPair, public half included, leaving a ZeroKeyPair.
+// Copies made before Destroy was called are not wiped.
+func (kp *KeyPair) Destroy() {
+	for i := range kp {
+		kp[i][0].Destroy()
+		kp[i][1].Destroy()
+	}
+}
+
+// A LockedBuffer is memory outside of the Go heap that the kernel won't swap to disk, for holding secrets.
+// It is wiped and released by Destroy. Locking is only supported on Linux; elsewhere NewLockedBuffer fails with ErrNotLocked.
+type LockedBuffer struct {
+	b []byte
+}
+
+// NewLockedBuffer allocates size bytes of locked memory.
+func NewLockedBuffer(size int) (*LockedBuffer, error) {
+	b, err := lockedAlloc(size)
+	if err != nil {
+		return nil, err
+	}
+	return &LockedBuffer{b: b}, nil
+}
+
+// Bytes is the locked memory itself. It must not be used after Destroy.
+func (l *LockedBuffer) Bytes() []byte {
+	return l.b
+}
+
+// Destroy wipes, unlocks and releases the memory.
+func (l *LockedBuffer) Destroy() error {
+	if l.b == nil {
+		return nil
+	}
+	Wipe(l.b)
+	err := lockedFree(l.b)
+	l.b = nil
+	return err
+}
+
+// LockKeyPair moves kp into locked memory, wiping the original.
+// The returned KeyPair lives in the LockedBuffer, so it is gone once the buffer is destroyed.
+func LockKeyPair(kp *KeyPair) (*KeyPair, *LockedBuffer, error) {
+	l, err := NewLockedBuffer(int(unsafe.Sizeof(*kp)))
+	if err != nil {
+		return nil, nil, err
+	}
+	//	a KeyPair is nothing but bytes, so it can live in memory the garbage collector knows nothing about
+	locked := (*KeyPair)(unsafe.Pointer(&l.b[0]))
+	*locked = *kp
+	kp.Destroy()
+	return locked, l, nil
+}
@@ -0,0 +1,30 @@
+package delphi
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func lockedAlloc(size int) ([]byte, error) {
+	b, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrNotLocked, err)
+	}
+	if err := syscall.Mlock(b); err != nil {
+		_ = syscall.Munmap(b)
+		return nil, fmt.Errorf("%w. %w", ErrNotLocked, err)
+	}
+	//	keep secrets out of core dumps too, where the kernel allows it
+	_ = syscall.Madvise(b, madvDontDump)
+	return b, nil
+}
+
+func lockedFree(b []byte) error {
+	if err := syscall.Munlock(b); err != nil {
+		return err
+	}
+	return syscall.Munmap(b)
+}
+
+// madvDontDump is MADV_DONTDUMP, which the syscall package doesn't define.
+const madvDontDump = 0x10
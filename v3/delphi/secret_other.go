@@ -0,0 +1,13 @@
+//go:build !linux
+
+package delphi
+
+import "fmt"
+
+func lockedAlloc(int) ([]byte, error) {
+	return nil, fmt.Errorf("%w. not supported on this platform", ErrNotLocked)
+}
+
+func lockedFree([]byte) error {
+	return nil
+}
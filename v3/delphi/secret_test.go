@@ -0,0 +1,46 @@
+package delphi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWipe(t *testing.T) {
+	b := []byte("secret")
+	Wipe(b)
+	assert.Equal(t, make([]byte, 6), b)
+	Wipe(nil)
+}
+
+func TestKeyPair_Destroy(t *testing.T) {
+	kp := deterministicKeyPair(t, 1)
+	priv := kp.PrivateKey()
+	kp.Destroy()
+	assert.Equal(t, ZeroKeyPair, kp)
+
+	priv.Destroy()
+	assert.Equal(t, PrivateKey{}, priv)
+}
+
+func TestLockKeyPair(t *testing.T) {
+	kp := deterministicKeyPair(t, 1)
+	want := kp
+	locked, buf, err := LockKeyPair(&kp)
+	if errors.Is(err, ErrNotLocked) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	assert.Equal(t, ZeroKeyPair, kp)
+	assert.Equal(t, want, *locked)
+
+	sig, err := locked.Sign(nil, []byte("data"), nil)
+	require.NoError(t, err)
+	assert.True(t, want.Verify(want.PublicKey().Signing(), []byte("data"), sig))
+
+	require.NoError(t, buf.Destroy())
+	assert.Nil(t, buf.Bytes())
+	assert.NoError(t, buf.Destroy())
+}
@@ -0,0 +1,161 @@
+package delphi
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// A SuiteID identifies a cipher suite. A message records the suite it was encrypted with,
+// and a Principal can record the suite it prefers in its Props.
+type SuiteID uint8
+
+const (
+	//	SuiteChaCha20Poly1305 is what oracle has always used: X25519, HKDF-SHA256, ChaCha20-Poly1305 and ed25519.
+	SuiteChaCha20Poly1305 SuiteID = 1
+	//	SuiteXChaCha20Poly1305 has 24-byte nonces, which are safe to choose at random.
+	SuiteXChaCha20Poly1305 SuiteID = 2
+	//	SuiteAES256GCM is for when hardware AES is preferred, or required.
+	SuiteAES256GCM SuiteID = 3
+)
+
+// DefaultSuite is the suite of anything that doesn't say otherwise.
+const DefaultSuite = SuiteChaCha20Poly1305
+
+var ErrUnknownSuite = errors.New("unknown cipher suite")
+
+// A Suite is a set of algorithms that are used together.
+// Every suite agrees keys with X25519. They differ in how the agreed secret becomes a key,
+// and in the AEAD that key is used with. Signing is always ed25519, and doesn't depend on the suite.
+type Suite struct {
+	ID        SuiteID
+	Name      string
+	NonceSize int
+	//	NewAEAD makes an AEAD from a 32-byte key.
+	NewAEAD func(key []byte) (cipher.AEAD, error)
+	//	Info is the HKDF info used to derive the AEAD key from a shared secret.
+	//	If it is empty, the shared secret is used as it is.
+	Info string
+}
+
+func (s Suite) String() string {
+	return fmt.Sprintf("%s (%d)", s.Name, s.ID)
+}
+
+// DeriveKey turns a secret agreed with GenerateSharedSecret into a key for this suite.
+func (s Suite) DeriveKey(sharedSecret []byte) ([]byte, error) {
+	if s.Info == "" {
+		return sharedSecret, nil
+	}
+	h := hkdf.New(sha256.New, sharedSecret, nil, []byte(s.Info))
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// aead derives a key from sharedSecret, and checks the nonce.
+func (s Suite) aead(sharedSecret, nonce []byte) (cipher.AEAD, error) {
+	key, err := s.DeriveKey(sharedSecret)
+	if err != nil {
+		return nil, err
+	}
+	if s.Info != "" {
+		//	the AEAD keeps its own copy. sharedSecret is the caller's to wipe
+		defer Wipe(key)
+	}
+	aead, err := s.NewAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s needs a %d-byte nonce, not %d", s.Name, aead.NonceSize(), len(nonce))
+	}
+	return aead, nil
+}
+
+func (s Suite) Seal(sharedSecret, plainText, nonce, aad []byte) ([]byte, error) {
+	aead, err := s.aead(sharedSecret, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plainText, aad), nil
+}
+
+func (s Suite) Open(sharedSecret, cipherText, nonce, aad []byte) ([]byte, error) {
+	aead, err := s.aead(sharedSecret, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, cipherText, aad)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	suitesMu sync.RWMutex
+	suites   = map[SuiteID]Suite{
+		SuiteChaCha20Poly1305: {
+			ID:        SuiteChaCha20Poly1305,
+			Name:      "x25519-hkdf-sha256-chacha20poly1305-ed25519",
+			NonceSize: chacha20poly1305.NonceSize,
+			NewAEAD:   chacha20poly1305.New,
+		},
+		SuiteXChaCha20Poly1305: {
+			ID:        SuiteXChaCha20Poly1305,
+			Name:      "x25519-hkdf-sha256-xchacha20poly1305-ed25519",
+			NonceSize: chacha20poly1305.NonceSizeX,
+			NewAEAD:   chacha20poly1305.NewX,
+			Info:      "oracle/suite/xchacha20poly1305",
+		},
+		SuiteAES256GCM: {
+			ID:        SuiteAES256GCM,
+			Name:      "x25519-hkdf-sha256-aes256gcm-ed25519",
+			NonceSize: 12,
+			NewAEAD:   newAESGCM,
+			Info:      "oracle/suite/aes256gcm",
+		},
+	}
+)
+
+// RegisterSuite adds a suite. IDs can't be reused.
+func RegisterSuite(s Suite) error {
+	if s.ID == 0 || s.NewAEAD == nil || s.NonceSize <= 0 {
+		return fmt.Errorf("incomplete suite %q", s.Name)
+	}
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	if _, exists := suites[s.ID]; exists {
+		return fmt.Errorf("suite %d is already registered", s.ID)
+	}
+	suites[s.ID] = s
+	return nil
+}
+
+// SuiteByID looks up a suite. The zero ID is the DefaultSuite.
+func SuiteByID(id SuiteID) (Suite, error) {
+	if id == 0 {
+		id = DefaultSuite
+	}
+	suitesMu.RLock()
+	defer suitesMu.RUnlock()
+	s, ok := suites[id]
+	if !ok {
+		return Suite{}, fmt.Errorf("%w: %d", ErrUnknownSuite, id)
+	}
+	return s, nil
+}
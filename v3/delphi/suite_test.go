@@ -0,0 +1,65 @@
+package delphi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuite(t *testing.T) {
+	alice := deterministicKeyPair(t, 1)
+	bob := deterministicKeyPair(t, 2)
+	sec, eph, err := alice.GenerateSharedSecret(deterministicReader(t, 3), bob.PublicKey())
+	require.NoError(t, err)
+
+	t.Run("the default suite is what KeyPair.Seal does", func(t *testing.T) {
+		suite, err := SuiteByID(0)
+		require.NoError(t, err)
+		assert.Equal(t, DefaultSuite, suite.ID)
+		nonce := make([]byte, suite.NonceSize)
+		want, err := alice.Seal(sec, []byte("hi"), nonce, []byte("aad"))
+		require.NoError(t, err)
+		got, err := suite.Seal(sec, []byte("hi"), nonce, []byte("aad"))
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	for _, id := range []SuiteID{SuiteChaCha20Poly1305, SuiteXChaCha20Poly1305, SuiteAES256GCM} {
+		suite, err := SuiteByID(id)
+		require.NoError(t, err)
+		t.Run(suite.Name, func(t *testing.T) {
+			nonce := make([]byte, suite.NonceSize)
+			cipherText, err := suite.Seal(sec, []byte("hello"), nonce, []byte("aad"))
+			require.NoError(t, err)
+			bobSec, err := bob.ExtractSharedSecret(eph)
+			require.NoError(t, err)
+			plainText, err := suite.Open(bobSec, cipherText, nonce, []byte("aad"))
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(plainText))
+
+			_, err = suite.Open(bobSec, cipherText, nonce, []byte("other"))
+			assert.Error(t, err)
+			_, err = suite.Seal(sec, []byte("hello"), make([]byte, suite.NonceSize+1), nil)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("suites don't read each other", func(t *testing.T) {
+		chacha, _ := SuiteByID(SuiteChaCha20Poly1305)
+		aes, _ := SuiteByID(SuiteAES256GCM)
+		nonce := make([]byte, 12)
+		cipherText, err := aes.Seal(sec, []byte("hello"), nonce, nil)
+		require.NoError(t, err)
+		_, err = chacha.Open(sec, cipherText, nonce, nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown and duplicate suites", func(t *testing.T) {
+		_, err := SuiteByID(200)
+		assert.ErrorIs(t, err, ErrUnknownSuite)
+		chacha, _ := SuiteByID(SuiteChaCha20Poly1305)
+		assert.Error(t, RegisterSuite(chacha))
+		assert.Error(t, RegisterSuite(Suite{ID: 200}))
+	})
+}
@@ -0,0 +1,342 @@
+package oracle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+)
+
+const (
+	pemDerivation    = "ORACLE KEY DERIVATION"
+	derivationDomain = "oracle/derivation\x00"
+)
+
+// Props that mark a key as derived from a parent. Together with the parent's key, they hold the whole Derivation,
+// so it can be re-verified by anyone who knows the parent.
+const (
+	propParent     = "parent"
+	propLabel      = "label"
+	propDerived    = "derived"
+	propDerivedSig = "derived-sig"
+)
+
+var derivationProps = []string{propParent, propLabel, propDerived, propDerivedSig}
+
+var ErrBadDerivation = errors.New("bad key derivation")
+
+// A Derivation is a statement, signed by Parent, that Child was derived from it under Label.
+// Whoever trusts Parent can trust Child.
+type Derivation struct {
+	Parent    delphi.PublicKey `json:"parent"`
+	Child     delphi.PublicKey `json:"child"`
+	Label     string           `json:"label"`
+	Timestamp time.Time        `json:"timestamp"`
+	Signature hexBytes         `json:"sig"`
+}
+
+func (d *Derivation) signedData() []byte {
+	b := []byte(derivationDomain)
+	b = append(b, d.Parent.Bytes()...)
+	b = append(b, d.Child.Bytes()...)
+	b = append(b, byte(len(d.Label)))
+	b = append(b, d.Label...)
+	return binary.BigEndian.AppendUint64(b, uint64(d.Timestamp.Unix()))
+}
+
+// Verify checks that Parent really signed the Derivation.
+// It can't check that Child was derived from Parent, since that takes Parent's private key.
+func (d *Derivation) Verify() error {
+	if d.Parent == d.Child {
+		return fmt.Errorf("%w. parent and child are the same", ErrBadDerivation)
+	}
+	if err := delphi.ValidateLabel(d.Label); err != nil {
+		return fmt.Errorf("%w. %w", ErrBadDerivation, err)
+	}
+	if !new(delphi.KeyPair).Verify(d.Parent.Signing(), d.signedData(), d.Signature) {
+		return fmt.Errorf("%w. signature", ErrBadDerivation)
+	}
+	return nil
+}
+
+// Peer is the child as a Peer, with the Derivation in its Props, ready for ImportDerived.
+func (d *Derivation) Peer() Peer {
+	props := make(Props, len(derivationProps))
+	d.annotate(props)
+	return Peer{PublicKey: d.Child, Props: props}
+}
+
+func (d *Derivation) annotate(props Props) {
+	props[propParent] = d.Parent.Fingerprint().String()
+	props[propLabel] = d.Label
+	props[propDerived] = d.Timestamp.Format(time.RFC3339)
+	props[propDerivedSig] = hex.EncodeToString(d.Signature)
+}
+
+// derivationFromProps rebuilds the Derivation of child from its Props, given its parent. It does not verify it.
+func derivationFromProps(parent, child delphi.PublicKey, props Props) (*Derivation, error) {
+	if props[propParent] != parent.Fingerprint().String() {
+		return nil, fmt.Errorf("%w. not derived from %s", ErrBadDerivation, parent.Fingerprint().Short())
+	}
+	ts, err := time.Parse(time.RFC3339, props[propDerived])
+	if err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrBadDerivation, err)
+	}
+	sig, err := hex.DecodeString(props[propDerivedSig])
+	if err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrBadDerivation, err)
+	}
+	return &Derivation{
+		Parent:    parent,
+		Child:     child,
+		Label:     props[propLabel],
+		Timestamp: ts.UTC(),
+		Signature: sig,
+	}, nil
+}
+
+func parentFromProps(props Props) (delphi.Fingerprint, bool) {
+	fp, err := delphi.FingerprintFromString(props[propParent])
+	return fp, err == nil
+}
+
+// Parent is the Fingerprint of the key the Peer claims to be derived from, if any.
+func (p *Peer) Parent() (delphi.Fingerprint, bool) {
+	return parentFromProps(p.Props)
+}
+
+// Parent is the Fingerprint of the key the Principal was derived from, if any.
+func (pr *Principal) Parent() (delphi.Fingerprint, bool) {
+	return parentFromProps(pr.Props)
+}
+
+// Derive makes a child Principal from the Principal's KeyPair, along with a Derivation binding it to its parent.
+// The same label always gives the same child. The child has no peers of its own, and its Props refer to its parent.
+func (pr *Principal) Derive(label string, now time.Time) (*Principal, *Derivation, error) {
+	if err := pr.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("could not derive. %w", err)
+	}
+	kp, err := pr.KeyPair.Derive(label)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not derive. %w", err)
+	}
+	d := &Derivation{
+		Parent:    pr.KeyPair.PublicKey(),
+		Child:     kp.PublicKey(),
+		Label:     label,
+		Timestamp: now.UTC().Truncate(time.Second),
+	}
+	if d.Signature, err = pr.KeyPair.Sign(nil, d.signedData(), nil); err != nil {
+		return nil, nil, fmt.Errorf("could not derive. %w", err)
+	}
+	child := &Principal{KeyPair: kp}
+	child.initialize()
+	d.annotate(child.Props)
+	child.expound()
+	return child, d, nil
+}
+
+// lookupParent finds a parent by Fingerprint among the Principal itself and its peers.
+func (pr *Principal) lookupParent(fp delphi.Fingerprint) (delphi.PublicKey, bool) {
+	if pr.Fingerprint() == fp {
+		return pr.KeyPair.PublicKey(), true
+	}
+	peer, ok := pr.Peers.Get(fp)
+	return peer.PublicKey, ok
+}
+
+// Derivation returns the Derivation of a key, if it is a peer derived from the Principal or from another peer,
+// or if its Derivation was added with AddDerivation.
+func (pr *Principal) Derivation(pub delphi.PublicKey) (*Derivation, bool) {
+	if d, ok := pr.peerDerivation(pub); ok {
+		return d, true
+	}
+	d, ok := pr.Derivations[pub]
+	if !ok || d.Child != pub || d.Verify() != nil {
+		return nil, false
+	}
+	return &d, true
+}
+
+func (pr *Principal) peerDerivation(pub delphi.PublicKey) (*Derivation, bool) {
+	props := pr.Peers[pub]
+	fp, ok := parentFromProps(props)
+	if !ok {
+		return nil, false
+	}
+	parent, ok := pr.lookupParent(fp)
+	if !ok {
+		return nil, false
+	}
+	d, err := derivationFromProps(parent, pub, props)
+	if err != nil || d.Verify() != nil {
+		return nil, false
+	}
+	return d, true
+}
+
+// AddDerivation verifies a Derivation and keeps it, so that trust in its parent extends to its child.
+// Unlike ImportDerived, neither the parent nor the child need be a peer. A newer Derivation of the same child replaces an older one.
+func (pr *Principal) AddDerivation(d *Derivation) error {
+	if err := d.Verify(); err != nil {
+		return err
+	}
+	if pr.Derivations == nil {
+		pr.Derivations = make(DerivationStore)
+	}
+	if old, ok := pr.Derivations[d.Child]; !ok || d.Timestamp.After(old.Timestamp) {
+		pr.Derivations[d.Child] = *d
+	}
+	return nil
+}
+
+// A DerivationStore holds Derivations, keyed by their child.
+type DerivationStore map[delphi.PublicKey]Derivation
+
+func (ds DerivationStore) MarshalJSON() ([]byte, error) {
+	m := make(map[string]Derivation, len(ds))
+	for k, v := range ds {
+		m[k.String()] = v
+	}
+	return json.Marshal(m)
+}
+
+func (ds *DerivationStore) UnmarshalJSON(b []byte) error {
+	var m map[string]Derivation
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if *ds == nil {
+		*ds = make(DerivationStore, len(m))
+	}
+	for k, v := range m {
+		pub, err := delphi.KeyFromString(k)
+		if err != nil {
+			return err
+		}
+		(*ds)[delphi.PublicKey(pub)] = v
+	}
+	return nil
+}
+
+// trustDescendants extends trust from trusted keys to every key derived from them, and so on down,
+// stopping at any key whose lineage has been revoked.
+func (pr *Principal) trustDescendants(trusted map[delphi.PublicKey]bool) {
+	for grew := true; grew; {
+		grew = false
+		for child := range pr.Derivations {
+			if trusted[child] {
+				continue
+			}
+			d, ok := pr.Derivation(child)
+			if ok && trusted[d.Parent] && !pr.isRevokedLineage(child) {
+				trusted[child] = true
+				grew = true
+			}
+		}
+	}
+}
+
+// ImportDerived adds a peer derived from the Principal or one of its peers, such as one made by Derivation.Peer.
+// The parent must already be known, and neither it nor its own ancestors may be revoked.
+func (pr *Principal) ImportDerived(peer Peer) (*Derivation, error) {
+	fp, ok := peer.Parent()
+	if !ok {
+		return nil, fmt.Errorf("%w. %s has no parent", ErrBadDerivation, peer.Fingerprint().Short())
+	}
+	parent, ok := pr.lookupParent(fp)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchPeer, fp.Short())
+	}
+	if pr.isRevokedLineage(parent) {
+		return nil, fmt.Errorf("%w: %s", message.ErrRevoked, fp.Short())
+	}
+	d, err := derivationFromProps(parent, peer.PublicKey, peer.Props)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Verify(); err != nil {
+		return nil, err
+	}
+	pr.AddPeer(peer)
+	return d, nil
+}
+
+// revokedAncestor returns the nearest revoked ancestor of a key, following Derivations up through the peers and the DerivationStore.
+func (pr *Principal) revokedAncestor(pub delphi.PublicKey) (delphi.PublicKey, bool) {
+	seen := map[delphi.PublicKey]bool{pub: true}
+	for {
+		d, ok := pr.Derivation(pub)
+		if !ok || seen[d.Parent] {
+			return delphi.PublicKey{}, false
+		}
+		if pr.IsRevoked(d.Parent) {
+			return d.Parent, true
+		}
+		seen[d.Parent] = true
+		pub = d.Parent
+	}
+}
+
+// isRevokedLineage reports whether a key, or any key it was derived from, has been revoked.
+func (pr *Principal) isRevokedLineage(pub delphi.PublicKey) bool {
+	if pr.IsRevoked(pub) {
+		return true
+	}
+	_, revoked := pr.revokedAncestor(pub)
+	return revoked
+}
+
+// MarshalPEM encodes a Derivation as an "ORACLE KEY DERIVATION" PEM block, whose body is the child's public key.
+func (d *Derivation) MarshalPEM() ([]byte, error) {
+	block := &pem.Block{
+		Type: pemDerivation,
+		Headers: map[string]string{
+			"parent":    d.Parent.String(),
+			"label":     d.Label,
+			"timestamp": d.Timestamp.UTC().Format(time.RFC3339),
+			"sig":       hex.EncodeToString(d.Signature),
+		},
+		Bytes: d.Child.Bytes(),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEM decodes an "ORACLE KEY DERIVATION" PEM block. It does not verify it.
+func (d *Derivation) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type != pemDerivation {
+		return errors.New("wrong PEM type: " + block.Type)
+	}
+	child, err := delphi.KeyFromBytes(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not decode child key. %w", err)
+	}
+	parent, err := delphi.KeyFromString(block.Headers["parent"])
+	if err != nil {
+		return fmt.Errorf("could not decode parent key. %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339, block.Headers["timestamp"])
+	if err != nil {
+		return fmt.Errorf("could not decode timestamp. %w", err)
+	}
+	sig, err := hex.DecodeString(block.Headers["sig"])
+	if err != nil {
+		return fmt.Errorf("could not decode sig. %w", err)
+	}
+	d.Parent = delphi.PublicKey(parent)
+	d.Child = delphi.PublicKey(child)
+	d.Label = block.Headers["label"]
+	d.Timestamp = ts.UTC()
+	d.Signature = sig
+	return nil
+}
@@ -0,0 +1,203 @@
+package oracle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var derivedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestPrincipal_Derive(t *testing.T) {
+	root := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	bob.AddPeer(root.AsPeer())
+
+	billing, d, err := root.Derive("service/billing", derivedAt)
+	require.NoError(t, err)
+	require.NoError(t, billing.Validate())
+	require.NoError(t, d.Verify())
+	assert.Equal(t, billing.KeyPair.PublicKey(), d.Child)
+
+	t.Run("deterministic", func(t *testing.T) {
+		again, _, err := root.Derive("service/billing", derivedAt.Add(time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, billing.KeyPair, again.KeyPair)
+	})
+
+	t.Run("the child refers to its parent", func(t *testing.T) {
+		fp, ok := billing.Parent()
+		require.True(t, ok)
+		assert.Equal(t, root.Fingerprint(), fp)
+		_, ok = root.Parent()
+		assert.False(t, ok)
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, billing.SaveJSON(buf))
+		got, err := LoadJSON(buf)
+		require.NoError(t, err)
+		fp, ok = got.Parent()
+		require.True(t, ok)
+		assert.Equal(t, root.Fingerprint(), fp)
+	})
+
+	t.Run("restricted parents can't derive", func(t *testing.T) {
+		online, err := root.Restrict(delphi.CanEncrypt)
+		require.NoError(t, err)
+		_, _, err = online.Derive("service/billing", derivedAt)
+		assert.ErrorIs(t, err, delphi.ErrCapability)
+	})
+
+	t.Run("PEM round trip", func(t *testing.T) {
+		data, err := d.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "ORACLE KEY DERIVATION")
+		got := new(Derivation)
+		require.NoError(t, got.UnmarshalPEM(data))
+		assert.Equal(t, d, got)
+	})
+
+	t.Run("tampering", func(t *testing.T) {
+		bad := *d
+		bad.Label = "service/payroll"
+		assert.ErrorIs(t, bad.Verify(), ErrBadDerivation)
+		bad = *d
+		bad.Child = bob.KeyPair.PublicKey()
+		assert.ErrorIs(t, bad.Verify(), ErrBadDerivation)
+	})
+
+	t.Run("peers of the parent trust the child", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(5))
+		_, ok := carol.Trusts(d.Child, DefaultTrustPolicy)
+		assert.False(t, ok)
+		_, err := carol.ImportDerived(billing.AsPeer())
+		assert.ErrorIs(t, err, ErrNoSuchPeer)
+
+		got, err := bob.ImportDerived(billing.AsPeer())
+		require.NoError(t, err)
+		assert.Equal(t, d, got)
+		depth, ok := bob.Trusts(d.Child, DefaultTrustPolicy)
+		assert.True(t, ok)
+		assert.Equal(t, 0, depth)
+		got, ok = bob.Derivation(d.Child)
+		require.True(t, ok)
+		assert.Equal(t, d, got)
+	})
+
+	t.Run("and so do the parent's own children", func(t *testing.T) {
+		invoices, id, err := billing.Derive("invoices", derivedAt)
+		require.NoError(t, err)
+		_, err = bob.ImportDerived(id.Peer())
+		require.NoError(t, err)
+		_, ok := bob.Trusts(invoices.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+	})
+
+	t.Run("a forged child is refused", func(t *testing.T) {
+		mallory := NewPrincipal(fakeRand(7))
+		forged := d.Peer()
+		forged.PublicKey = mallory.KeyPair.PublicKey()
+		_, err := bob.ImportDerived(forged)
+		assert.ErrorIs(t, err, ErrBadDerivation)
+		_, err = bob.ImportDerived(mallory.AsPeer())
+		assert.ErrorIs(t, err, ErrBadDerivation)
+	})
+
+	t.Run("revoking the parent revokes its descendants", func(t *testing.T) {
+		r, err := root.Revoke(ReasonKeyCompromise, derivedAt)
+		require.NoError(t, err)
+		require.NoError(t, bob.ImportRevocation(r))
+		_, ok := bob.Trusts(d.Child, DefaultTrustPolicy)
+		assert.False(t, ok)
+		assert.ErrorIs(t, bob.CheckRevoked(d.Child), message.ErrRevoked)
+		assert.ErrorIs(t, bob.CheckRevoked(d.Child.Signing()), message.ErrRevoked)
+
+		search, sd, err := root.Derive("service/search", derivedAt)
+		require.NoError(t, err)
+		_, err = bob.ImportDerived(sd.Peer())
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		assert.False(t, bob.HasPeer(search.KeyPair.PublicKey()))
+	})
+}
+
+func TestPrincipal_AddDerivation(t *testing.T) {
+	root := NewPrincipal(fakeRand(1))
+	dave := NewPrincipal(fakeRand(3))
+	dave.AddPeer(root.AsPeer())
+	billing, d, err := root.Derive("service/billing", derivedAt)
+	require.NoError(t, err)
+	invoices, id, err := billing.Derive("invoices", derivedAt)
+	require.NoError(t, err)
+
+	t.Run("trust follows a Derivation", func(t *testing.T) {
+		_, ok := dave.Trusts(d.Child, DefaultTrustPolicy)
+		assert.False(t, ok)
+		require.NoError(t, dave.AddDerivation(id))
+		require.NoError(t, dave.AddDerivation(d))
+		assert.False(t, dave.HasPeer(d.Child))
+		for _, pub := range []delphi.PublicKey{d.Child, invoices.KeyPair.PublicKey()} {
+			depth, ok := dave.Trusts(pub, DefaultTrustPolicy)
+			assert.True(t, ok)
+			assert.Equal(t, 0, depth)
+		}
+		got, ok := dave.Derivation(d.Child)
+		require.True(t, ok)
+		assert.Equal(t, d, got)
+	})
+
+	t.Run("a forged Derivation is refused", func(t *testing.T) {
+		forged := *d
+		forged.Child = NewPrincipal(fakeRand(7)).KeyPair.PublicKey()
+		assert.ErrorIs(t, dave.AddDerivation(&forged), ErrBadDerivation)
+		_, ok := dave.Trusts(forged.Child, DefaultTrustPolicy)
+		assert.False(t, ok)
+	})
+
+	t.Run("the children of endorsed keys", func(t *testing.T) {
+		erin := NewPrincipal(fakeRand(9))
+		e, err := root.Endorse(erin.AsPeer(), nil, derivedAt)
+		require.NoError(t, err)
+		require.NoError(t, dave.AddEndorsement(e))
+		payroll, pd, err := erin.Derive("service/payroll", derivedAt)
+		require.NoError(t, err)
+		require.NoError(t, dave.AddDerivation(pd))
+		depth, ok := dave.Trusts(payroll.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+		assert.Equal(t, 1, depth)
+		_, ok = dave.Trusts(payroll.KeyPair.PublicKey(), TrustPolicy{MaxDepth: 0, Threshold: 1})
+		assert.False(t, ok)
+	})
+
+	t.Run("Derivations survive a round trip", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, dave.SaveJSON(buf))
+		got, err := LoadJSON(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, dave.Derivations, got.Derivations)
+		_, ok := got.Trusts(invoices.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+
+		//	Derivations are covered by the Verity
+		var tampered bytes.Buffer
+		tampered.Write(bytes.Replace(buf.Bytes(), []byte(`"label": "invoices"`), []byte(`"label": "invoicez"`), 1))
+		require.NotEqual(t, buf.String(), tampered.String())
+		_, err = LoadJSON(&tampered)
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("revoking an ancestor stops trust", func(t *testing.T) {
+		r, err := root.Revoke(ReasonKeyCompromise, derivedAt)
+		require.NoError(t, err)
+		require.NoError(t, dave.ImportRevocation(r))
+		for _, pub := range []delphi.PublicKey{d.Child, invoices.KeyPair.PublicKey()} {
+			_, ok := dave.Trusts(pub, DefaultTrustPolicy)
+			assert.False(t, ok)
+			assert.ErrorIs(t, dave.CheckRevoked(pub), message.ErrRevoked)
+		}
+	})
+}
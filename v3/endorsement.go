@@ -0,0 +1,246 @@
+package oracle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+const (
+	pemEndorsement    = "ORACLE ENDORSEMENT"
+	endorsementDomain = "oracle/endorsement\x00"
+)
+
+var ErrBadEndorsement = errors.New("bad endorsement")
+var ErrReservedProp = errors.New("reserved prop")
+
+// endorsementHeaders are PEM headers that carry the endorsement itself, so they can't be used as Props.
+var endorsementHeaders = []string{"endorser", "timestamp", "sig"}
+
+// An Endorsement is a signed statement by Endorser that Subject is who they claim to be,
+// along with whichever of Subject's Props the Endorser is willing to vouch for.
+type Endorsement struct {
+	Endorser  delphi.PublicKey `json:"endorser"`
+	Subject   delphi.PublicKey `json:"subject"`
+	Props     Props            `json:"Props"`
+	Timestamp time.Time        `json:"timestamp"`
+	Signature hexBytes         `json:"sig"`
+}
+
+func (e *Endorsement) signedData() []byte {
+	b := []byte(endorsementDomain)
+	b = append(b, e.Endorser.Bytes()...)
+	b = append(b, e.Subject.Bytes()...)
+	b = binary.BigEndian.AppendUint64(b, uint64(e.Timestamp.Unix()))
+	return canonicalProps(b, e.Props)
+}
+
+// Verify checks that Endorser really signed the Endorsement.
+func (e *Endorsement) Verify() error {
+	if e.Endorser == e.Subject {
+		return fmt.Errorf("%w. self-endorsement", ErrBadEndorsement)
+	}
+	if !new(delphi.KeyPair).Verify(e.Endorser.Signing(), e.signedData(), e.Signature) {
+		return fmt.Errorf("%w. signature", ErrBadEndorsement)
+	}
+	return nil
+}
+
+// Endorse vouches for a peer's public key, and for the Props named in keys.
+// The Endorsement is also kept, as if it had been passed to AddEndorsement.
+func (pr *Principal) Endorse(peer Peer, keys []string, now time.Time) (*Endorsement, error) {
+	props := make(Props, len(keys))
+	for _, k := range keys {
+		if slices.Contains(endorsementHeaders, k) || slices.Contains(derivedProps, k) {
+			return nil, fmt.Errorf("%w: %s", ErrReservedProp, k)
+		}
+		v, ok := peer.Props[k]
+		if !ok {
+			return nil, fmt.Errorf("could not endorse. %s has no prop %q", peer.NickName(), k)
+		}
+		props[k] = v
+	}
+	e := &Endorsement{
+		Endorser:  pr.KeyPair.PublicKey(),
+		Subject:   peer.PublicKey,
+		Props:     props,
+		Timestamp: now.UTC().Truncate(time.Second),
+	}
+	sig, err := pr.KeyPair.Sign(nil, e.signedData(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not endorse. %w", err)
+	}
+	e.Signature = sig
+	return e, pr.AddEndorsement(e)
+}
+
+// AddEndorsement verifies an Endorsement and stores it with its subject.
+// A newer Endorsement of the same subject by the same endorser replaces an older one.
+func (pr *Principal) AddEndorsement(e *Endorsement) error {
+	if err := e.Verify(); err != nil {
+		return err
+	}
+	if pr.Endorsements == nil {
+		pr.Endorsements = make(EndorsementStore)
+	}
+	list := pr.Endorsements[e.Subject]
+	i := slices.IndexFunc(list, func(old Endorsement) bool {
+		return old.Endorser == e.Endorser
+	})
+	switch {
+	case i < 0:
+		list = append(list, *e)
+	case e.Timestamp.After(list[i].Timestamp):
+		list[i] = *e
+	}
+	pr.Endorsements[e.Subject] = list
+	return nil
+}
+
+// An EndorsementStore holds Endorsements, keyed by their subject.
+type EndorsementStore map[delphi.PublicKey][]Endorsement
+
+func (es EndorsementStore) MarshalJSON() ([]byte, error) {
+	m := make(map[string][]Endorsement, len(es))
+	for k, v := range es {
+		m[k.String()] = v
+	}
+	return json.Marshal(m)
+}
+
+func (es *EndorsementStore) UnmarshalJSON(b []byte) error {
+	var m map[string][]Endorsement
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if *es == nil {
+		*es = make(EndorsementStore, len(m))
+	}
+	for k, v := range m {
+		pub, err := delphi.KeyFromString(k)
+		if err != nil {
+			return err
+		}
+		(*es)[delphi.PublicKey(pub)] = v
+	}
+	return nil
+}
+
+// A TrustPolicy decides how far, and how firmly, trust extends through Endorsements.
+type TrustPolicy struct {
+	//	MaxDepth is how many Endorsements away from us a key can be. 1 means endorsed directly by us or a peer.
+	MaxDepth int
+	//	Threshold is how many distinct trusted endorsers a key needs.
+	Threshold int
+}
+
+var DefaultTrustPolicy = TrustPolicy{MaxDepth: 2, Threshold: 1}
+
+// Trusts reports whether pub is trusted, and how far away it is.
+// We trust ourselves and our peers at depth 0, unless they, or the keys they were derived from, have been revoked.
+// Beyond that, a key is trusted at depth n if at least policy.Threshold keys trusted at depths less than n have endorsed it.
+// A key with a verified Derivation is trusted at the same depth as its parent, unless an ancestor has been revoked.
+func (pr *Principal) Trusts(pub delphi.PublicKey, policy TrustPolicy) (int, bool) {
+	if pr.isRevokedLineage(pub) {
+		return 0, false
+	}
+	trusted := map[delphi.PublicKey]bool{pr.KeyPair.PublicKey(): true}
+	for k := range pr.Peers {
+		//	a revoked peer is neither trusted nor trusted to endorse. Nor are keys derived from it
+		trusted[k] = !pr.isRevokedLineage(k)
+	}
+	pr.trustDescendants(trusted)
+	if trusted[pub] {
+		return 0, true
+	}
+	threshold := max(policy.Threshold, 1)
+	for depth := 1; depth <= policy.MaxDepth; depth++ {
+		//	everyone promoted at this depth is only trusted as an endorser at the next depth
+		promoted := []delphi.PublicKey{}
+		for subject, list := range pr.Endorsements {
+			if trusted[subject] || pr.isRevokedLineage(subject) {
+				continue
+			}
+			endorsers := map[delphi.PublicKey]bool{}
+			for _, e := range list {
+				if trusted[e.Endorser] && e.Subject == subject && e.Verify() == nil {
+					endorsers[e.Endorser] = true
+				}
+			}
+			if len(endorsers) >= threshold {
+				promoted = append(promoted, subject)
+			}
+		}
+		if len(promoted) == 0 {
+			break
+		}
+		for _, k := range promoted {
+			trusted[k] = true
+		}
+		pr.trustDescendants(trusted)
+		if trusted[pub] {
+			return depth, true
+		}
+	}
+	return 0, false
+}
+
+// MarshalPEM encodes an Endorsement as an "ORACLE ENDORSEMENT" PEM block, whose body is the subject's public key.
+func (e *Endorsement) MarshalPEM() ([]byte, error) {
+	headers := make(map[string]string, len(e.Props)+len(endorsementHeaders))
+	for k, v := range e.Props {
+		headers[k] = v
+	}
+	headers["endorser"] = e.Endorser.String()
+	headers["timestamp"] = e.Timestamp.UTC().Format(time.RFC3339)
+	headers["sig"] = hex.EncodeToString(e.Signature)
+	block := &pem.Block{
+		Type:    pemEndorsement,
+		Headers: headers,
+		Bytes:   e.Subject.Bytes(),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEM decodes an "ORACLE ENDORSEMENT" PEM block. It does not verify it.
+func (e *Endorsement) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type != pemEndorsement {
+		return errors.New("wrong PEM type: " + block.Type)
+	}
+	subject, err := delphi.KeyFromBytes(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not decode subject. %w", err)
+	}
+	endorser, err := delphi.KeyFromString(block.Headers["endorser"])
+	if err != nil {
+		return fmt.Errorf("could not decode endorser. %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339, block.Headers["timestamp"])
+	if err != nil {
+		return fmt.Errorf("could not decode timestamp. %w", err)
+	}
+	sig, err := hex.DecodeString(block.Headers["sig"])
+	if err != nil {
+		return fmt.Errorf("could not decode sig. %w", err)
+	}
+	for _, k := range endorsementHeaders {
+		delete(block.Headers, k)
+	}
+	e.Endorser = delphi.PublicKey(endorser)
+	e.Subject = delphi.PublicKey(subject)
+	e.Props = block.Headers
+	e.Timestamp = ts.UTC()
+	e.Signature = sig
+	return nil
+}
@@ -0,0 +1,117 @@
+package oracle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var endorsedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestPrincipal_Endorse(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	bob.Props["email"] = "bob@example.com"
+	bob.Props["mood"] = "grumpy"
+	alice.AddPeer(bob.AsPeer())
+
+	e, err := alice.Endorse(bob.AsPeer(), []string{"email"}, endorsedAt)
+	require.NoError(t, err)
+	assert.NoError(t, e.Verify())
+	assert.Equal(t, Props{"email": "bob@example.com"}, e.Props)
+	assert.Len(t, alice.Endorsements[bob.KeyPair.PublicKey()], 1)
+
+	t.Run("PEM round trip", func(t *testing.T) {
+		data, err := e.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "ORACLE ENDORSEMENT")
+		assert.Contains(t, string(data), "email: bob@example.com")
+		assert.NotContains(t, string(data), "grumpy")
+		got := new(Endorsement)
+		require.NoError(t, got.UnmarshalPEM(data))
+		assert.Equal(t, e, got)
+		assert.NoError(t, got.Verify())
+	})
+
+	t.Run("altered props", func(t *testing.T) {
+		forged := *e
+		forged.Props = Props{"email": "mallory@example.com"}
+		assert.ErrorIs(t, forged.Verify(), ErrBadEndorsement)
+		assert.ErrorIs(t, alice.AddEndorsement(&forged), ErrBadEndorsement)
+	})
+
+	t.Run("reserved props", func(t *testing.T) {
+		_, err := alice.Endorse(bob.AsPeer(), []string{"sig"}, endorsedAt)
+		assert.ErrorIs(t, err, ErrReservedProp)
+	})
+
+	t.Run("stored with the config", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(buf))
+		got, err := LoadJSON(buf)
+		require.NoError(t, err)
+		assert.Equal(t, alice.Endorsements, got.Endorsements)
+	})
+}
+
+func TestPrincipal_Trusts(t *testing.T) {
+	//	me -> bob -> carol -> dan, and bob, erin -> frank
+	me := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	carol := NewPrincipal(fakeRand(4))
+	dan := NewPrincipal(fakeRand(5))
+	erin := NewPrincipal(fakeRand(6))
+	frank := NewPrincipal(fakeRand(7))
+	me.AddPeer(bob.AsPeer())
+
+	endorse := func(by, of *Principal) {
+		t.Helper()
+		e, err := by.Endorse(of.AsPeer(), nil, endorsedAt)
+		require.NoError(t, err)
+		require.NoError(t, me.AddEndorsement(e))
+	}
+	endorse(bob, carol)
+	endorse(carol, dan)
+	endorse(bob, frank)
+	endorse(erin, frank)
+
+	t.Run("peers", func(t *testing.T) {
+		depth, ok := me.Trusts(bob.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+		assert.Equal(t, 0, depth)
+	})
+
+	t.Run("one hop", func(t *testing.T) {
+		depth, ok := me.Trusts(carol.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+		assert.Equal(t, 1, depth)
+	})
+
+	t.Run("two hops", func(t *testing.T) {
+		depth, ok := me.Trusts(dan.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.True(t, ok)
+		assert.Equal(t, 2, depth)
+		_, ok = me.Trusts(dan.KeyPair.PublicKey(), TrustPolicy{MaxDepth: 1, Threshold: 1})
+		assert.False(t, ok)
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		strict := TrustPolicy{MaxDepth: 2, Threshold: 2}
+		//	erin is a stranger, so her endorsement doesn't count
+		_, ok := me.Trusts(frank.KeyPair.PublicKey(), strict)
+		assert.False(t, ok)
+		me.AddPeer(erin.AsPeer())
+		defer delete(me.Peers, erin.KeyPair.PublicKey())
+		depth, ok := me.Trusts(frank.KeyPair.PublicKey(), strict)
+		assert.True(t, ok)
+		assert.Equal(t, 1, depth)
+	})
+
+	t.Run("strangers", func(t *testing.T) {
+		_, ok := me.Trusts(erin.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.False(t, ok)
+	})
+}
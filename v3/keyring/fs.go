@@ -0,0 +1,169 @@
+package keyring
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// An FS is the filesystem a Keyring lives on.
+// It extends fs.FS with the few write operations a Keyring needs.
+type FS interface {
+	fs.ReadDirFS
+	fs.ReadFileFS
+	// WriteFile must replace name atomically, so that readers never see a partial file.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Remove(name string) error
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// dirFS is an FS rooted at a directory on disk.
+type dirFS struct {
+	fs.FS
+	root string
+}
+
+// DirFS returns an FS rooted at dir on the operating system's filesystem.
+func DirFS(dir string) FS {
+	return &dirFS{FS: os.DirFS(dir), root: dir}
+}
+
+func (d *dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(d.FS, name)
+}
+
+func (d *dirFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(d.FS, name)
+}
+
+func (d *dirFS) path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(d.root, filepath.FromSlash(name)), nil
+}
+
+// WriteFile writes to a temporary file in the same directory, and then renames it into place.
+func (d *dirFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	//	if anything goes wrong, don't leave temp files lying around
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (d *dirFS) Remove(name string) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (d *dirFS) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+// memFS is an in-memory FS, useful for tests.
+type memFS struct {
+	mu    sync.RWMutex
+	files fstest.MapFS
+}
+
+// MemFS returns an empty in-memory FS.
+func MemFS() FS {
+	return &memFS{files: make(fstest.MapFS)}
+}
+
+func (m *memFS) Open(name string) (fs.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.Open(name)
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.ReadDir(name)
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.files.ReadFile(name)
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dir := path.Dir(name); dir != "." {
+		if f, ok := m.files[dir]; !ok || !f.Mode.IsDir() {
+			return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	m.files[name] = &fstest.MapFile{Data: buf, Mode: perm, ModTime: time.Now()}
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir := name; dir != "."; dir = path.Dir(dir) {
+		if f, ok := m.files[dir]; ok {
+			if !f.Mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+			}
+			continue
+		}
+		m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | perm, ModTime: time.Now()}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+// Package keyring stores Principals, and their peers, on disk.
+//
+// A keyring is a directory, by default ~/.oracle, laid out like so:
+//
+//	principals/<public key>.json	one JSON config per Principal
+//	default				the public key of the default Principal
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+const (
+	principalsDir = "principals"
+	defaultFile   = "default"
+	filePerm      = 0600
+	dirPerm       = 0700
+)
+
+var ErrNotFound = errors.New("not found")
+var ErrAmbiguous = errors.New("ambiguous")
+var ErrNoDefault = errors.New("no default principal")
+
+// A Keyring is a collection of Principals, one of which may be the default.
+type Keyring struct {
+	fsys FS
+	// Passphrase is called when an encrypted Principal is loaded, and when one is saved.
+	// If it is set, Principals are always saved encrypted.
+	Passphrase oracle.PassphraseFunc
+	// Rand is used to encrypt Principals. If it is nil, crypto/rand is used.
+	Rand io.Reader
+}
+
+// DefaultDir is where a keyring lives if you don't say otherwise.
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".oracle"), nil
+}
+
+// New returns a Keyring on fsys, creating its directory structure if need be.
+func New(fsys FS) (*Keyring, error) {
+	if err := fsys.MkdirAll(principalsDir, dirPerm); err != nil {
+		return nil, fmt.Errorf("could not create keyring. %w", err)
+	}
+	return &Keyring{fsys: fsys}, nil
+}
+
+// Open returns a Keyring rooted at dir on the operating system's filesystem.
+func Open(dir string) (*Keyring, error) {
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return nil, fmt.Errorf("could not create keyring. %w", err)
+	}
+	return New(DirFS(dir))
+}
+
+func fileName(pub delphi.PublicKey) string {
+	return path.Join(principalsDir, pub.String()+".json")
+}
+
+// keys lists the public keys of every Principal, without loading them.
+func (k *Keyring) keys() ([]delphi.PublicKey, error) {
+	entries, err := k.fsys.ReadDir(principalsDir)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]delphi.PublicKey, 0, len(entries))
+	for _, entry := range entries {
+		name, isJSON := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !isJSON {
+			continue
+		}
+		key, err := delphi.KeyFromString(name)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, delphi.PublicKey(key))
+	}
+	slices.SortFunc(keys, func(a, b delphi.PublicKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return keys, nil
+}
+
+// resolve finds the one public key matching a nickname, or a hex prefix of a fingerprint or public key.
+func (k *Keyring) resolve(query string) (delphi.PublicKey, error) {
+	var zero delphi.PublicKey
+	if query == "" {
+		return zero, fmt.Errorf("%w: empty query", ErrNotFound)
+	}
+	keys, err := k.keys()
+	if err != nil {
+		return zero, err
+	}
+	var matches []delphi.PublicKey
+	for _, pub := range keys {
+		if pub.Nickname() == query || pub.Fingerprint().HasPrefix(query) || strings.HasPrefix(pub.String(), strings.ToLower(query)) {
+			matches = append(matches, pub)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return zero, fmt.Errorf("%w: %s", ErrNotFound, query)
+	case 1:
+		return matches[0], nil
+	default:
+		return zero, fmt.Errorf("%w: %q matches %d principals", ErrAmbiguous, query, len(matches))
+	}
+}
+
+// load reads a Principal. A config with no Verity, as written before configs were signed, is signed and saved again.
+func (k *Keyring) load(pub delphi.PublicKey) (*oracle.Principal, error) {
+	data, err := k.fsys.ReadFile(fileName(pub))
+	if err != nil {
+		return nil, err
+	}
+	getPass := k.Passphrase
+	if getPass == nil {
+		getPass = func() ([]byte, error) {
+			return nil, oracle.ErrPassphraseRequired
+		}
+	}
+	pr, err := oracle.LoadUnsignedJSONWithPassphrase(bytes.NewReader(data), getPass)
+	if err != nil {
+		return nil, err
+	}
+	//	written before configs were signed. migrate it, by saving it again
+	if pr.Unsigned() {
+		if err := k.Save(pr); err != nil {
+			return nil, fmt.Errorf("could not migrate %s. %w", pub.Nickname(), err)
+		}
+	}
+	return pr, nil
+}
+
+// Save stores a Principal, with its peers, replacing any previous version.
+// It is encrypted if the Keyring has a Passphrase.
+// If the Principal has rotated its key, the files of its retired keys are removed, and the default follows it.
+func (k *Keyring) Save(pr *oracle.Principal) error {
+	buf := new(bytes.Buffer)
+	if k.Passphrase == nil {
+		if err := pr.SaveJSON(buf); err != nil {
+			return err
+		}
+	} else {
+		passphrase, err := k.Passphrase()
+		if err != nil {
+			return err
+		}
+		if err := pr.SaveEncryptedJSON(buf, k.rand(), passphrase); err != nil {
+			return err
+		}
+	}
+	pub := pr.KeyPair.PublicKey()
+	if err := k.fsys.WriteFile(fileName(pub), buf.Bytes(), filePerm); err != nil {
+		return err
+	}
+	return k.retire(pr)
+}
+
+func (k *Keyring) rand() io.Reader {
+	if k.Rand == nil {
+		return rand.Reader
+	}
+	return k.Rand
+}
+
+// retire removes the files left behind by a Principal's retired keys,
+// so that they can't be mistaken for Principals in their own right.
+func (k *Keyring) retire(pr *oracle.Principal) error {
+	pub := pr.KeyPair.PublicKey()
+	def, err := k.defaultKey()
+	hasDefault := err == nil
+	for i := range pr.Retired {
+		old := pr.Retired[i].KeyPair.PublicKey()
+		if hasDefault && def == old {
+			if err := k.fsys.WriteFile(defaultFile, []byte(pub.String()+"\n"), filePerm); err != nil {
+				return err
+			}
+		}
+		if err := k.fsys.Remove(fileName(old)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the Principal matching a nickname, or a fingerprint or public key prefix.
+func (k *Keyring) Get(query string) (*oracle.Principal, error) {
+	pub, err := k.resolve(query)
+	if err != nil {
+		return nil, err
+	}
+	return k.load(pub)
+}
+
+// List returns every Principal in the keyring, ordered by public key.
+func (k *Keyring) List() ([]*oracle.Principal, error) {
+	keys, err := k.keys()
+	if err != nil {
+		return nil, err
+	}
+	principals := make([]*oracle.Principal, 0, len(keys))
+	for _, pub := range keys {
+		pr, err := k.load(pub)
+		if err != nil {
+			return nil, fmt.Errorf("could not load %s. %w", pub.Nickname(), err)
+		}
+		principals = append(principals, pr)
+	}
+	return principals, nil
+}
+
+// Remove deletes a Principal. If it was the default, there is no longer a default.
+func (k *Keyring) Remove(query string) error {
+	pub, err := k.resolve(query)
+	if err != nil {
+		return err
+	}
+	if def, err := k.defaultKey(); err == nil && def == pub {
+		if err := k.fsys.Remove(defaultFile); err != nil {
+			return err
+		}
+	}
+	return k.fsys.Remove(fileName(pub))
+}
+
+// SetDefault makes the Principal matching query the default.
+func (k *Keyring) SetDefault(query string) error {
+	pub, err := k.resolve(query)
+	if err != nil {
+		return err
+	}
+	return k.fsys.WriteFile(defaultFile, []byte(pub.String()+"\n"), filePerm)
+}
+
+func (k *Keyring) defaultKey() (delphi.PublicKey, error) {
+	var zero delphi.PublicKey
+	data, err := k.fsys.ReadFile(defaultFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return zero, ErrNoDefault
+	}
+	if err != nil {
+		return zero, err
+	}
+	key, err := delphi.KeyFromString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return zero, fmt.Errorf("%w. %w", ErrNoDefault, err)
+	}
+	return delphi.PublicKey(key), nil
+}
+
+// Default returns the default Principal.
+func (k *Keyring) Default() (*oracle.Principal, error) {
+	pub, err := k.defaultKey()
+	if err != nil {
+		return nil, err
+	}
+	pr, err := k.load(pub)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w. %s is gone", ErrNoDefault, pub.Nickname())
+	}
+	return pr, err
+}
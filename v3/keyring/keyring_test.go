@@ -0,0 +1,273 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRand byte
+
+func (f fakeRand) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = byte(f)
+	}
+	return len(p), nil
+}
+
+func newTestKeyring(t testing.TB) *Keyring {
+	t.Helper()
+	kr, err := New(MemFS())
+	require.NoError(t, err)
+	return kr
+}
+
+func TestKeyring_SaveGet(t *testing.T) {
+	kr := newTestKeyring(t)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	bob := oracle.NewPrincipal(fakeRand(3))
+	alice.AddPeer(bob.AsPeer())
+	alice.Props["favourite colour"] = "blue"
+	require.NoError(t, kr.Save(alice))
+	require.NoError(t, kr.Save(bob))
+
+	t.Run("by nickname", func(t *testing.T) {
+		got, err := kr.Get(alice.NickName())
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair, got.KeyPair)
+		assert.Equal(t, "blue", got.Props["favourite colour"])
+		assert.True(t, got.HasPeer(bob.KeyPair.PublicKey()))
+	})
+
+	t.Run("by key prefix", func(t *testing.T) {
+		got, err := kr.Get(bob.KeyPair.PublicKey().String()[:8])
+		require.NoError(t, err)
+		assert.Equal(t, bob.KeyPair, got.KeyPair)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := kr.Get("nobody-at-all")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = kr.Get("")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		//	with 17 principals, at least two must share a first hex digit
+		extras := make([]*oracle.Principal, 0, 15)
+		seen := map[byte]bool{}
+		shared := ""
+		for i := 10; i < 25; i++ {
+			p := oracle.NewPrincipal(fakeRand(i))
+			require.NoError(t, kr.Save(p))
+			extras = append(extras, p)
+		}
+		for _, p := range append(extras, alice, bob) {
+			c := p.KeyPair.PublicKey().String()[0]
+			if seen[c] {
+				shared = string(c)
+			}
+			seen[c] = true
+		}
+		_, err := kr.Get(shared)
+		assert.ErrorIs(t, err, ErrAmbiguous)
+		for _, p := range extras {
+			require.NoError(t, kr.Remove(p.KeyPair.PublicKey().String()))
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		all, err := kr.List()
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+	})
+
+	t.Run("save replaces", func(t *testing.T) {
+		alice.Props["favourite colour"] = "green"
+		require.NoError(t, kr.Save(alice))
+		got, err := kr.Get(alice.NickName())
+		require.NoError(t, err)
+		assert.Equal(t, "green", got.Props["favourite colour"])
+		all, err := kr.List()
+		require.NoError(t, err)
+		assert.Len(t, all, 2)
+	})
+}
+
+func TestKeyring_Default(t *testing.T) {
+	kr := newTestKeyring(t)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	require.NoError(t, kr.Save(alice))
+
+	_, err := kr.Default()
+	assert.ErrorIs(t, err, ErrNoDefault)
+
+	require.NoError(t, kr.SetDefault(alice.NickName()))
+	got, err := kr.Default()
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+
+	assert.ErrorIs(t, kr.SetDefault("nobody-at-all"), ErrNotFound)
+
+	require.NoError(t, kr.Remove(alice.NickName()))
+	_, err = kr.Default()
+	assert.ErrorIs(t, err, ErrNoDefault)
+	_, err = kr.Get(alice.NickName())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestKeyring_Encrypted(t *testing.T) {
+	fsys := MemFS()
+	kr, err := New(fsys)
+	require.NoError(t, err)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	require.NoError(t, kr.Save(alice))
+
+	//	replace alice's config with an encrypted one
+	buf := new(bytes.Buffer)
+	require.NoError(t, alice.SaveEncryptedJSON(buf, fakeRand(2), []byte("hunter2")))
+	require.NoError(t, fsys.WriteFile(fileName(alice.KeyPair.PublicKey()), buf.Bytes(), filePerm))
+
+	_, err = kr.Get(alice.NickName())
+	assert.ErrorIs(t, err, oracle.ErrPassphraseRequired)
+
+	kr.Passphrase = func() ([]byte, error) { return []byte("hunter2"), nil }
+	got, err := kr.Get(alice.NickName())
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+}
+
+func TestKeyring_Unsigned(t *testing.T) {
+	fsys := MemFS()
+	kr, err := New(fsys)
+	require.NoError(t, err)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	alice.AddPeer(oracle.NewPrincipal(fakeRand(3)).AsPeer())
+
+	//	a config as it was written before configs were signed
+	unsigned, err := json.MarshalIndent(alice, "", "\t")
+	require.NoError(t, err)
+	require.NotContains(t, string(unsigned), "verity")
+	require.NoError(t, fsys.WriteFile(fileName(alice.KeyPair.PublicKey()), unsigned, filePerm))
+
+	got, err := kr.Get(alice.NickName())
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+	assert.Len(t, got.Peers, 1)
+	assert.False(t, got.Unsigned())
+
+	//	it was signed and saved again
+	data, err := fsys.ReadFile(fileName(alice.KeyPair.PublicKey()))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "verity")
+	_, err = oracle.LoadJSON(bytes.NewReader(data))
+	require.NoError(t, err)
+}
+
+func TestKeyring_SaveEncrypted(t *testing.T) {
+	fsys := MemFS()
+	kr, err := New(fsys)
+	require.NoError(t, err)
+	kr.Passphrase = func() ([]byte, error) { return []byte("hunter2"), nil }
+	kr.Rand = fakeRand(2)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	require.NoError(t, kr.Save(alice))
+
+	data, err := fsys.ReadFile(fileName(alice.KeyPair.PublicKey()))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "sealed_keypair")
+	assert.NotContains(t, string(data), alice.KeyPair.String())
+
+	got, err := kr.Get(alice.NickName())
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+
+	locked, err := New(fsys)
+	require.NoError(t, err)
+	_, err = locked.Get(alice.NickName())
+	assert.ErrorIs(t, err, oracle.ErrPassphraseRequired)
+}
+
+func TestKeyring_SaveRotated(t *testing.T) {
+	kr := newTestKeyring(t)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	bob := oracle.NewPrincipal(fakeRand(3))
+	require.NoError(t, kr.Save(alice))
+	require.NoError(t, kr.Save(bob))
+	require.NoError(t, kr.SetDefault(alice.NickName()))
+	old := alice.KeyPair.PublicKey()
+
+	_, err := alice.Rotate(fakeRand(4), time.Now(), time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, kr.Save(alice))
+
+	all, err := kr.List()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+	_, err = kr.Get(old.Nickname())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	got, err := kr.Default()
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+	require.Len(t, got.Retired, 1)
+	assert.Equal(t, old, got.Retired[0].KeyPair.PublicKey())
+
+	//	rotating a principal that isn't the default leaves the default alone
+	_, err = bob.Rotate(fakeRand(5), time.Now(), time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, kr.Save(bob))
+	got, err = kr.Default()
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+}
+
+func TestOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "oracle")
+	kr, err := Open(dir)
+	require.NoError(t, err)
+	alice := oracle.NewPrincipal(fakeRand(1))
+	require.NoError(t, kr.Save(alice))
+	require.NoError(t, kr.SetDefault(alice.NickName()))
+
+	info, err := os.Stat(filepath.Join(dir, principalsDir))
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(dirPerm), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Join(dir, filepath.FromSlash(fileName(alice.KeyPair.PublicKey()))))
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(filePerm), info.Mode().Perm())
+
+	//	atomic writes should leave no temporary files behind
+	entries, err := os.ReadDir(filepath.Join(dir, principalsDir))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	again, err := Open(dir)
+	require.NoError(t, err)
+	got, err := again.Default()
+	require.NoError(t, err)
+	assert.Equal(t, alice.KeyPair, got.KeyPair)
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := MemFS()
+	assert.Error(t, fsys.WriteFile("no/such/dir", []byte("x"), filePerm))
+	assert.Error(t, fsys.WriteFile("../escape", []byte("x"), filePerm))
+	require.NoError(t, fsys.MkdirAll("a/b", dirPerm))
+	require.NoError(t, fsys.WriteFile("a/b/c", []byte("hello"), filePerm))
+	data, err := fsys.ReadFile("a/b/c")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Error(t, fsys.MkdirAll("a/b/c/d", dirPerm))
+	require.NoError(t, fsys.Remove("a/b/c"))
+	assert.ErrorIs(t, fsys.Remove("a/b/c"), fs.ErrNotExist)
+}
@@ -0,0 +1,46 @@
+package message
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// A capable key knows what it can be used for, like a delphi.KeyPair.
+type capable interface {
+	Capabilities() delphi.Capability
+}
+
+// canSign returns an error if signer is known to be unable to sign.
+func canSign(signer crypto.Signer) error {
+	if c, ok := signer.(capable); ok && !c.Capabilities().Has(delphi.CanSign) {
+		return fmt.Errorf("could not sign. %w", delphi.ErrCapability)
+	}
+	return nil
+}
+
+// canEncryptTo returns an error if recipient is a restricted key that can't be encrypted to.
+// A zero key isn't restricted, it's missing, and is left to the sealer to refuse.
+func canEncryptTo(recipient delphi.PublicKey) error {
+	if recipient == (delphi.PublicKey{}) {
+		return nil
+	}
+	return recipient.Can(delphi.CanEncrypt)
+}
+
+// canVerify returns an error if pub is a key, or a SubKey, that can't have signed anything.
+func canVerify(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case delphi.PublicKey:
+		if k == (delphi.PublicKey{}) {
+			return nil
+		}
+		return k.Can(delphi.CanSign)
+	case delphi.SubKey:
+		if k.IsZero() {
+			return fmt.Errorf("%w. no signing key", delphi.ErrCapability)
+		}
+	}
+	return nil
+}
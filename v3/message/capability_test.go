@@ -0,0 +1,39 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Capabilities(t *testing.T) {
+	signer, err := alice(t).Restrict(delphi.CanSign)
+	require.NoError(t, err)
+	encrypter, err := bob(t).Restrict(delphi.CanEncrypt)
+	require.NoError(t, err)
+
+	t.Run("encrypt only to keys that can decrypt", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		err := msg.Encrypt(dRand(t, 2), signer.PublicKey(), bob(t))
+		assert.ErrorIs(t, err, delphi.ErrCapability)
+		err = msg.EncryptToMany(dRand(t, 2), []delphi.PublicKey{bob(t).PublicKey(), signer.PublicKey()}, bob(t))
+		assert.ErrorIs(t, err, delphi.ErrCapability)
+
+		require.NoError(t, msg.Encrypt(dRand(t, 2), encrypter.PublicKey(), alice(t)))
+		require.NoError(t, msg.Decrypt(&encrypter))
+		assert.Equal(t, "hello", string(msg.PlainText))
+	})
+
+	t.Run("sign only with keys that can sign", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		assert.ErrorIs(t, msg.Sign(&encrypter), delphi.ErrCapability)
+		require.NoError(t, msg.Sign(&signer))
+		assert.NoError(t, msg.CheckSignature(alice(t).PublicKey().Signing(), bob(t)))
+		assert.ErrorIs(t, msg.CheckSignature(encrypter.PublicKey(), bob(t)), delphi.ErrCapability)
+		assert.ErrorIs(t, msg.CheckSignature(encrypter.PublicKey().Signing(), bob(t)), delphi.ErrCapability)
+	})
+}
@@ -0,0 +1,388 @@
+package message
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	smap "github.com/sean9999/go-stable-map"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/**
+ * Headers are carried in a Message's AAD, as a lexically ordered map of strings.
+ * That is the same encoding AAD has always used for custom PEM headers, so older messages still verify.
+ *
+ * Values are typed. A string is written as it is, unless it starts with "!".
+ * Anything else is written as "!<type> <value>":
+ *
+ *	!int 42
+ *	!bool true
+ *	!bytes 0a0b0c
+ *	!time 2024-05-06T07:08:09Z
+ *	!string !looks like a type, but isn't
+ *
+ * The same textual form is used for PEM headers, JSON and msgpack.
+ **/
+
+const typePrefix = "!"
+
+// Header types.
+const (
+	HeaderString = "string"
+	HeaderInt    = "int"
+	HeaderBool   = "bool"
+	HeaderBytes  = "bytes"
+	HeaderTime   = "time"
+)
+
+// pemTypeHeader carries a custom PEM type. It is reserved, and set with SetPEMType.
+const pemTypeHeader = "pemType"
+
+// reservedNamespace is kept for oracle's own future use.
+const reservedNamespace = "oracle."
+
+// reservedHeaders are the PEM headers that oracle writes itself, plus "aad", which holds opaque AAD.
+var reservedHeaders = []string{
+	"nonce", "eph", "sig", "encrypted", "stream", "recipients", "sender",
+	"id", "created", "not-after", "kem", "suite", "digest", "aad", pemTypeHeader,
+}
+
+var validHeaderName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var (
+	ErrReservedHeader = errors.New("reserved header")
+	ErrBadHeader      = errors.New("bad header")
+	ErrNoSuchHeader   = errors.New("no such header")
+	ErrHeaderType     = errors.New("wrong header type")
+	ErrOpaqueAAD      = errors.New("AAD is opaque bytes, not headers")
+)
+
+// IsReservedHeader reports whether a header name belongs to oracle, rather than to whoever is writing the message.
+func IsReservedHeader(name string) bool {
+	return slices.Contains(reservedHeaders, name) || strings.HasPrefix(name, reservedNamespace)
+}
+
+func checkHeaderName(name string) error {
+	if !validHeaderName.MatchString(name) {
+		return fmt.Errorf("%w. invalid name %q", ErrBadHeader, name)
+	}
+	if IsReservedHeader(name) {
+		return fmt.Errorf("%w: %q", ErrReservedHeader, name)
+	}
+	return nil
+}
+
+// Headers are custom, typed name/value pairs. The zero value is empty and ready to use.
+type Headers struct {
+	m map[string]string
+}
+
+// HeadersFromMap reads headers in their textual form, as found in PEM headers. Reserved names are refused.
+func HeadersFromMap(m map[string]string) (Headers, error) {
+	h := Headers{m: make(map[string]string, len(m))}
+	for name, value := range m {
+		if err := checkHeaderName(name); err != nil {
+			return Headers{}, err
+		}
+		if _, _, err := parseHeaderValue(value); err != nil {
+			return Headers{}, fmt.Errorf("%w. %s: %w", ErrBadHeader, name, err)
+		}
+		h.m[name] = value
+	}
+	return h, nil
+}
+
+// AsMap returns the textual form of the headers.
+func (h Headers) AsMap() map[string]string {
+	m := maps.Clone(h.m)
+	if m == nil {
+		m = map[string]string{}
+	}
+	return m
+}
+
+// Names returns the header names in canonical order.
+func (h Headers) Names() []string {
+	return slices.Sorted(maps.Keys(h.m))
+}
+
+func (h Headers) Len() int {
+	return len(h.m)
+}
+
+func (h *Headers) Delete(name string) {
+	delete(h.m, name)
+}
+
+// Type returns the type of a header.
+func (h Headers) Type(name string) (string, error) {
+	v, ok := h.m[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrNoSuchHeader, name)
+	}
+	typ, _, err := parseHeaderValue(v)
+	return typ, err
+}
+
+// parseHeaderValue splits a textual value into its type and the value proper.
+func parseHeaderValue(v string) (typ string, value string, err error) {
+	if !strings.HasPrefix(v, typePrefix) {
+		return HeaderString, v, nil
+	}
+	typ, value, _ = strings.Cut(v[len(typePrefix):], " ")
+	switch typ {
+	case HeaderString:
+	case HeaderInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case HeaderBool:
+		_, err = strconv.ParseBool(value)
+	case HeaderBytes:
+		_, err = hex.DecodeString(value)
+	case HeaderTime:
+		_, err = time.Parse(time.RFC3339, value)
+	default:
+		err = fmt.Errorf("unknown type %q", typ)
+	}
+	return typ, value, err
+}
+
+func (h *Headers) set(name, typ, value string) error {
+	if err := checkHeaderName(name); err != nil {
+		return err
+	}
+	if h.m == nil {
+		h.m = map[string]string{}
+	}
+	if typ == HeaderString && !strings.HasPrefix(value, typePrefix) {
+		h.m[name] = value
+	} else {
+		h.m[name] = typePrefix + typ + " " + value
+	}
+	return nil
+}
+
+func (h Headers) get(name, typ string) (string, error) {
+	v, ok := h.m[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrNoSuchHeader, name)
+	}
+	got, value, err := parseHeaderValue(v)
+	if err != nil {
+		return "", fmt.Errorf("%w. %s: %w", ErrBadHeader, name, err)
+	}
+	if got != typ {
+		return "", fmt.Errorf("%w. %s is %s, not %s", ErrHeaderType, name, got, typ)
+	}
+	return value, nil
+}
+
+func (h *Headers) SetString(name, v string) error {
+	return h.set(name, HeaderString, v)
+}
+
+func (h *Headers) SetInt(name string, v int64) error {
+	return h.set(name, HeaderInt, strconv.FormatInt(v, 10))
+}
+
+func (h *Headers) SetBool(name string, v bool) error {
+	return h.set(name, HeaderBool, strconv.FormatBool(v))
+}
+
+func (h *Headers) SetBytes(name string, v []byte) error {
+	return h.set(name, HeaderBytes, hex.EncodeToString(v))
+}
+
+// SetTime stores a time to the second, in UTC.
+func (h *Headers) SetTime(name string, v time.Time) error {
+	return h.set(name, HeaderTime, v.UTC().Format(time.RFC3339))
+}
+
+func (h Headers) GetString(name string) (string, error) {
+	return h.get(name, HeaderString)
+}
+
+func (h Headers) GetInt(name string) (int64, error) {
+	v, err := h.get(name, HeaderInt)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func (h Headers) GetBool(name string) (bool, error) {
+	v, err := h.get(name, HeaderBool)
+	if err != nil {
+		return false, err
+	}
+	return strconv.ParseBool(v)
+}
+
+func (h Headers) GetBytes(name string) ([]byte, error) {
+	v, err := h.get(name, HeaderBytes)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(v)
+}
+
+func (h Headers) GetTime(name string) (time.Time, error) {
+	v, err := h.get(name, HeaderTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// MarshalBinary is the canonical encoding: a lexically ordered map.
+func (h Headers) MarshalBinary() ([]byte, error) {
+	return smap.LexicalFrom(h.AsMap()).MarshalBinary()
+}
+
+func (h *Headers) UnmarshalBinary(data []byte) error {
+	sm := smap.From(map[string]string{})
+	if err := sm.UnmarshalBinary(data); err != nil {
+		return fmt.Errorf("%w. %w", ErrOpaqueAAD, err)
+	}
+	got, err := HeadersFromMap(sm.AsMap())
+	if err != nil {
+		return err
+	}
+	*h = got
+	return nil
+}
+
+func (h Headers) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.AsMap())
+}
+
+func (h *Headers) UnmarshalJSON(data []byte) error {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	got, err := HeadersFromMap(m)
+	if err != nil {
+		return err
+	}
+	*h = got
+	return nil
+}
+
+func (h Headers) EncodeMsgpack(enc *msgpack.Encoder) error {
+	enc.SetSortMapKeys(true)
+	return enc.Encode(h.AsMap())
+}
+
+func (h *Headers) DecodeMsgpack(dec *msgpack.Decoder) error {
+	var m map[string]string
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+	got, err := HeadersFromMap(m)
+	if err != nil {
+		return err
+	}
+	*h = got
+	return nil
+}
+
+// aadMap decodes AAD as a map, without any checks. Empty AAD is an empty map.
+func (msg *Message) aadMap() (map[string]string, error) {
+	if len(msg.AAD) == 0 {
+		return map[string]string{}, nil
+	}
+	sm := smap.From(map[string]string{})
+	if err := sm.UnmarshalBinary(msg.AAD); err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrOpaqueAAD, err)
+	}
+	return sm.AsMap(), nil
+}
+
+// Headers decodes the custom headers carried in the AAD.
+// It is an error if the AAD is opaque bytes, or if it holds a reserved header other than the PEM type.
+func (msg *Message) Headers() (Headers, error) {
+	m, err := msg.aadMap()
+	if err != nil {
+		return Headers{}, err
+	}
+	delete(m, pemTypeHeader)
+	return HeadersFromMap(m)
+}
+
+// SetHeaders replaces the AAD with h, keeping any custom PEM type.
+// Like the sender, headers are authenticated, so they must be set before encrypting or signing.
+func (msg *Message) SetHeaders(h Headers) error {
+	pemType := msg.PEMType()
+	m := h.AsMap()
+	for name := range m {
+		if err := checkHeaderName(name); err != nil {
+			return err
+		}
+	}
+	if pemType != "" {
+		m[pemTypeHeader] = pemType
+	}
+	return msg.setAADMap(m)
+}
+
+func (msg *Message) setAADMap(m map[string]string) error {
+	if len(m) == 0 {
+		msg.AAD = nil
+		return nil
+	}
+	aad, err := smap.LexicalFrom(m).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	msg.AAD = aad
+	return nil
+}
+
+// PEMType is the custom PEM type, if there is one.
+func (msg *Message) PEMType() string {
+	m, err := msg.aadMap()
+	if err != nil {
+		return ""
+	}
+	return m[pemTypeHeader]
+}
+
+// SetPEMType sets a custom PEM type. An empty string restores the default.
+func (msg *Message) SetPEMType(pemType string) error {
+	if strings.ContainsAny(pemType, "-\n\r") {
+		return fmt.Errorf("%w. invalid PEM type %q", ErrBadHeader, pemType)
+	}
+	m, err := msg.aadMap()
+	if err != nil {
+		return err
+	}
+	if pemType == "" {
+		delete(m, pemTypeHeader)
+	} else {
+		m[pemTypeHeader] = pemType
+	}
+	return msg.setAADMap(m)
+}
+
+// checkHeaders makes sure AAD headers won't collide with the headers oracle writes itself.
+func (msg *Message) checkHeaders() error {
+	m, err := msg.aadMap()
+	if err != nil {
+		//	opaque AAD is written as a single "aad" header, so it can't collide
+		return nil
+	}
+	for name := range m {
+		if name != pemTypeHeader && IsReservedHeader(name) {
+			return fmt.Errorf("%w: %q", ErrReservedHeader, name)
+		}
+	}
+	return nil
+}
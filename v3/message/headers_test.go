@@ -0,0 +1,176 @@
+package message
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+
+	smap "github.com/sean9999/go-stable-map"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func typedHeaders(t *testing.T) Headers {
+	t.Helper()
+	var h Headers
+	require.NoError(t, h.SetString("to", "bob"))
+	require.NoError(t, h.SetString("bang", "!important"))
+	require.NoError(t, h.SetInt("count", -42))
+	require.NoError(t, h.SetBool("urgent", true))
+	require.NoError(t, h.SetBytes("blob", []byte{0xca, 0xfe}))
+	require.NoError(t, h.SetTime("due", stampedAt))
+	return h
+}
+
+func TestHeaders(t *testing.T) {
+	h := typedHeaders(t)
+
+	t.Run("typed getters", func(t *testing.T) {
+		s, err := h.GetString("to")
+		require.NoError(t, err)
+		assert.Equal(t, "bob", s)
+		s, err = h.GetString("bang")
+		require.NoError(t, err)
+		assert.Equal(t, "!important", s)
+		i, err := h.GetInt("count")
+		require.NoError(t, err)
+		assert.Equal(t, int64(-42), i)
+		b, err := h.GetBool("urgent")
+		require.NoError(t, err)
+		assert.True(t, b)
+		blob, err := h.GetBytes("blob")
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0xca, 0xfe}, blob)
+		due, err := h.GetTime("due")
+		require.NoError(t, err)
+		assert.Equal(t, stampedAt, due)
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		_, err := h.GetInt("to")
+		assert.ErrorIs(t, err, ErrHeaderType)
+		_, err = h.GetString("count")
+		assert.ErrorIs(t, err, ErrHeaderType)
+		_, err = h.GetString("nope")
+		assert.ErrorIs(t, err, ErrNoSuchHeader)
+	})
+
+	t.Run("canonical order", func(t *testing.T) {
+		assert.Equal(t, []string{"bang", "blob", "count", "due", "to", "urgent"}, h.Names())
+		a, err := h.MarshalBinary()
+		require.NoError(t, err)
+		b, err := typedHeaders(t).MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("reserved names", func(t *testing.T) {
+		var h Headers
+		for _, name := range []string{"nonce", "eph", "sig", "pemType", "aad", "oracle.anything"} {
+			assert.ErrorIs(t, h.SetString(name, "x"), ErrReservedHeader, name)
+		}
+		assert.ErrorIs(t, h.SetString("has: colon", "x"), ErrBadHeader)
+		_, err := HeadersFromMap(map[string]string{"sig": "x"})
+		assert.ErrorIs(t, err, ErrReservedHeader)
+		_, err = HeadersFromMap(map[string]string{"n": "!float 1.5"})
+		assert.ErrorIs(t, err, ErrBadHeader)
+	})
+
+	t.Run("JSON and msgpack map the same way as PEM", func(t *testing.T) {
+		want := h.AsMap()
+		assert.Equal(t, "!int -42", want["count"])
+		assert.Equal(t, "!string !important", want["bang"])
+
+		j, err := json.Marshal(h)
+		require.NoError(t, err)
+		var fromJSON map[string]string
+		require.NoError(t, json.Unmarshal(j, &fromJSON))
+		assert.Equal(t, want, fromJSON)
+		var h2 Headers
+		require.NoError(t, json.Unmarshal(j, &h2))
+		assert.Equal(t, h, h2)
+
+		mp, err := msgpack.Marshal(h)
+		require.NoError(t, err)
+		var fromMsgpack map[string]string
+		require.NoError(t, msgpack.Unmarshal(mp, &fromMsgpack))
+		assert.Equal(t, want, fromMsgpack)
+		var h3 Headers
+		require.NoError(t, msgpack.Unmarshal(mp, &h3))
+		assert.Equal(t, h, h3)
+	})
+}
+
+func TestMessage_Headers(t *testing.T) {
+	msg := NewMessage(dRand(t, 1))
+	msg.PlainText = []byte("hello")
+	require.NoError(t, msg.SetHeaders(typedHeaders(t)))
+	require.NoError(t, msg.SetPEMType("CUSTOM MESSAGE"))
+
+	t.Run("PEM round trip", func(t *testing.T) {
+		require.NoError(t, msg.Sign(alice(t)))
+		data, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		block, _ := pem.Decode(data)
+		require.NotNil(t, block)
+		assert.Equal(t, "CUSTOM MESSAGE", block.Type)
+		assert.Equal(t, "!bool true", block.Headers["urgent"])
+
+		got, err := DecodeMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg.AAD, got.AAD)
+		assert.Equal(t, "CUSTOM MESSAGE", got.PEMType())
+		h, err := got.Headers()
+		require.NoError(t, err)
+		assert.Equal(t, typedHeaders(t), h)
+		assert.True(t, got.Verify(alice(t).PublicKey().Signing(), alice(t)))
+	})
+
+	t.Run("reserved names in raw AAD are refused", func(t *testing.T) {
+		evil := NewMessage(dRand(t, 1))
+		evil.PlainText = []byte("hello")
+		evil.AAD = mustMarshal(map[string]string{"sig": "not really"})
+		_, err := evil.MarshalPEM()
+		assert.ErrorIs(t, err, ErrReservedHeader)
+		_, err = evil.Headers()
+		assert.ErrorIs(t, err, ErrReservedHeader)
+	})
+
+	t.Run("opaque AAD", func(t *testing.T) {
+		opaque := &Message{PlainText: []byte("hi"), AAD: []byte("\xc1 not a map")}
+		_, err := opaque.Headers()
+		assert.ErrorIs(t, err, ErrOpaqueAAD)
+	})
+
+	t.Run("opaque aad next to custom headers", func(t *testing.T) {
+		block := &pem.Block{
+			Type:    "ORACLE MESSAGE",
+			Bytes:   []byte("hi"),
+			Headers: map[string]string{"aad": "aGk=", "foo": "bar"},
+		}
+		err := new(Message).reconstituteFromPEM(block)
+		assert.ErrorIs(t, err, ErrBadMessage)
+		assert.ErrorContains(t, err, "not both")
+	})
+
+	t.Run("legacy AAD still reads", func(t *testing.T) {
+		legacy, err := smap.LexicalFrom(map[string]string{"pemType": "CUSTOM", "foo": "bar"}).MarshalBinary()
+		require.NoError(t, err)
+		old := &Message{PlainText: []byte("hi"), AAD: legacy}
+		h, err := old.Headers()
+		require.NoError(t, err)
+		s, err := h.GetString("foo")
+		require.NoError(t, err)
+		assert.Equal(t, "bar", s)
+		assert.Equal(t, "CUSTOM", old.PEMType())
+	})
+
+	t.Run("clearing", func(t *testing.T) {
+		m := &Message{PlainText: []byte("hi")}
+		require.NoError(t, m.SetPEMType("X"))
+		require.NoError(t, m.SetPEMType(""))
+		assert.Nil(t, m.AAD)
+		assert.ErrorIs(t, m.SetPEMType("BAD\nTYPE"), ErrBadHeader)
+	})
+}
@@ -0,0 +1,107 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// PEMHybridMessage is the PEM type of a message encrypted with a hybrid X25519 + ML-KEM-768 secret.
+const PEMHybridMessage = "ORACLE HYBRID ENCRYPTED MESSAGE"
+
+type hybridSealer interface {
+	Seal([]byte, []byte, []byte, []byte) ([]byte, error)
+	GenerateHybridSecret(io.Reader, delphi.HybridPublicKey) ([]byte, []byte, []byte, error)
+}
+
+// A HybridDecrypter can decrypt messages encrypted with EncryptHybrid.
+type HybridDecrypter interface {
+	DecryptHybrid(cipherText, eph, kemCipherText, nonce, aad []byte) ([]byte, error)
+}
+
+// A HybridSecretExtractor can recover a hybrid secret, for messages in suites other than the default.
+type HybridSecretExtractor interface {
+	ExtractHybridSecret(eph, kemCipherText []byte) ([]byte, error)
+}
+
+// IsHybrid reports whether a message was encrypted with a hybrid secret.
+func (msg *Message) IsHybrid() bool {
+	return len(msg.KEMCipher) > 0
+}
+
+// EncryptHybrid is like Encrypt, but the key is agreed with both X25519 and ML-KEM-768,
+// so that a message recorded today can't be decrypted by a quantum computer tomorrow.
+func (msg *Message) EncryptHybrid(randy io.Reader, recipient delphi.HybridPublicKey, e hybridSealer) error {
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := msg.makeNonce(randy, suite); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if msg.PlainText == nil {
+		return errors.New("no plain text to encrypt")
+	}
+	if err := canEncryptTo(recipient.PublicKey); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := checkRevoked(e, recipient.PublicKey); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	sec, eph, kem, err := e.GenerateHybridSecret(randy, recipient)
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	defer delphi.Wipe(sec)
+	cipherText, err := msg.seal(e, suite, sec, msg.PlainText, msg.additionalData())
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	msg.EphemeralKey = eph
+	msg.KEMCipher = kem
+	msg.CipherText = cipherText
+	msg.PlainText = nil
+	return nil
+}
+
+func (msg *Message) decryptHybrid(recipient Decrypter) error {
+	plainText, err := msg.openHybrid(recipient)
+	if err != nil {
+		return err
+	}
+	msg.PlainText = plainText
+	msg.CipherText = nil
+	msg.KEMCipher = nil
+	return nil
+}
+
+// openHybrid is like open, for hybrid messages.
+func (msg *Message) openHybrid(recipient Decrypter) ([]byte, error) {
+	if msg.isDefaultSuite() {
+		hd, ok := recipient.(HybridDecrypter)
+		if !ok {
+			return nil, errors.New("could not decrypt. recipient can not decrypt hybrid messages")
+		}
+		return hd.DecryptHybrid(msg.CipherText, msg.EphemeralKey, msg.KEMCipher, msg.Nonce, msg.additionalData())
+	}
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	extractor, ok := recipient.(HybridSecretExtractor)
+	if !ok {
+		return nil, fmt.Errorf("could not decrypt. recipient can not decrypt hybrid messages in %s", suite.Name)
+	}
+	sec, err := extractor.ExtractHybridSecret(msg.EphemeralKey, msg.KEMCipher)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	defer delphi.Wipe(sec)
+	plainText, err := suite.Open(sec, msg.CipherText, msg.Nonce, msg.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	return plainText, nil
+}
@@ -0,0 +1,80 @@
+package message
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_EncryptHybrid(t *testing.T) {
+	bobHybrid, err := delphi.NewHybridKeyPair(dRand(t, 3), bob(t))
+	require.NoError(t, err)
+	bobPub, err := bobHybrid.HybridPublicKey()
+	require.NoError(t, err)
+
+	msg := NewMessage(dRand(t, 1))
+	msg.PlainText = []byte("a long-lived secret")
+	require.NoError(t, msg.EncryptHybrid(dRand(t, 2), bobPub, alice(t)))
+	assert.True(t, msg.IsHybrid())
+	assert.Nil(t, msg.PlainText)
+
+	t.Run("PEM round trip", func(t *testing.T) {
+		data, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		block, _ := pem.Decode(data)
+		require.NotNil(t, block)
+		assert.Equal(t, PEMHybridMessage, block.Type)
+		got, err := DecodeMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+		assert.Equal(t, "", got.PEMType())
+	})
+
+	t.Run("wire and JSON round trips", func(t *testing.T) {
+		got, err := DecodeMessage(msg.Serialize())
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+		j, err := msg.MarshalJSON()
+		require.NoError(t, err)
+		got = new(Message)
+		require.NoError(t, got.UnmarshalJSON(j))
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("the wrong key can't decrypt", func(t *testing.T) {
+		clone := *msg
+		assert.Error(t, clone.Decrypt(alice(t)))
+	})
+
+	t.Run("the classical key alone can't decrypt", func(t *testing.T) {
+		clone := *msg
+		assert.Error(t, clone.Decrypt(bob(t)))
+		//	nor can the classical key with some other ML-KEM key
+		impostor, err := delphi.NewHybridKeyPair(dRand(t, 4), bob(t))
+		require.NoError(t, err)
+		assert.Error(t, clone.Decrypt(impostor))
+	})
+
+	t.Run("recipient decrypts", func(t *testing.T) {
+		clone := *msg
+		require.NoError(t, clone.Decrypt(bobHybrid))
+		assert.Equal(t, "a long-lived secret", string(clone.PlainText))
+		assert.False(t, clone.IsHybrid())
+	})
+
+	t.Run("hybrid keys decrypt ordinary messages too", func(t *testing.T) {
+		plain := NewMessage(dRand(t, 5))
+		plain.PlainText = []byte("hi bob")
+		require.NoError(t, plain.Encrypt(dRand(t, 6), bob(t).PublicKey(), alice(t)))
+		require.NoError(t, plain.Decrypt(bobHybrid))
+		assert.Equal(t, "hi bob", string(plain.PlainText))
+	})
+
+	t.Run("a plain message can't be hybrid", func(t *testing.T) {
+		bad := &Message{PlainText: []byte("hi"), KEMCipher: []byte("kem")}
+		assert.ErrorIs(t, bad.Validate(), ErrBadMessage)
+	})
+}
@@ -0,0 +1,247 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// The type discriminator of a Message in JSON.
+const (
+	JSONTypePlain     = "oracle/message"
+	JSONTypeEncrypted = "oracle/encrypted-message"
+)
+
+// A BinaryEncoding says how byte fields are written in JSON.
+type BinaryEncoding string
+
+const (
+	Base64URL BinaryEncoding = "base64url"
+	Hex       BinaryEncoding = "hex"
+)
+
+func (e BinaryEncoding) encode(b []byte) string {
+	if e == Hex {
+		return hex.EncodeToString(b)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (e BinaryEncoding) decode(s string) ([]byte, error) {
+	switch e {
+	case Hex:
+		return hex.DecodeString(s)
+	case Base64URL:
+		return base64.RawURLEncoding.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", e)
+	}
+}
+
+type jsonStanza struct {
+	EphemeralKey string `json:"eph"`
+	WrappedKey   string `json:"key"`
+}
+
+// jsonMessage is the JSON form of a Message. Fields are written in this order, and empty ones are left out,
+// so the output is canonical. The body is always present, since an empty body is not the same as no body.
+type jsonMessage struct {
+	Type       string         `json:"type"`
+	Encoding   BinaryEncoding `json:"encoding"`
+	Suite      delphi.SuiteID `json:"suite,omitempty"`
+	Digest     DigestVersion  `json:"digest,omitempty"`
+	PEMType    string         `json:"pemType,omitempty"`
+	ID         string         `json:"id,omitempty"`
+	Created    string         `json:"created,omitempty"`
+	NotAfter   string         `json:"notAfter,omitempty"`
+	Sender     string         `json:"sender,omitempty"`
+	Nonce      string         `json:"nonce,omitempty"`
+	Eph        string         `json:"eph,omitempty"`
+	KEM        string         `json:"kem,omitempty"`
+	Recipients []jsonStanza   `json:"recipients,omitempty"`
+	Stream     bool           `json:"stream,omitempty"`
+	Headers    *Headers       `json:"headers,omitempty"`
+	AAD        string         `json:"aad,omitempty"`
+	Body       *string        `json:"body"`
+	Signature  string         `json:"sig,omitempty"`
+}
+
+// canonicalHeaders returns the AAD as headers and a PEM type, but only if they encode back to exactly the same AAD.
+// Otherwise the AAD has to travel as opaque bytes, or the digest would change.
+func (msg *Message) canonicalHeaders() (*Headers, string, bool) {
+	if len(msg.AAD) == 0 {
+		return nil, "", true
+	}
+	m, err := msg.aadMap()
+	if err != nil {
+		return nil, "", false
+	}
+	pemType := m[pemTypeHeader]
+	delete(m, pemTypeHeader)
+	h, err := HeadersFromMap(m)
+	if err != nil {
+		return nil, "", false
+	}
+	again := &Message{}
+	if err := again.SetHeaders(h); err != nil {
+		return nil, "", false
+	}
+	if pemType != "" {
+		if err := again.SetPEMType(pemType); err != nil {
+			return nil, "", false
+		}
+	}
+	if !bytes.Equal(again.AAD, msg.AAD) {
+		return nil, "", false
+	}
+	if h.Len() == 0 {
+		return nil, pemType, true
+	}
+	return &h, pemType, true
+}
+
+// EncodeJSON is like MarshalJSON, but with a choice of encoding for byte fields.
+func (msg *Message) EncodeJSON(enc BinaryEncoding) ([]byte, error) {
+	if err := msg.Validate(); err != nil {
+		return nil, fmt.Errorf("could not marshal. validation failed. %w", err)
+	}
+	if _, err := enc.decode(""); err != nil {
+		return nil, err
+	}
+	jm := jsonMessage{
+		Type:      JSONTypePlain,
+		Encoding:  enc,
+		Suite:     msg.Suite,
+		Digest:    msg.DigestVersion,
+		ID:        enc.encode(msg.ID),
+		Sender:    enc.encode(msg.Sender),
+		Nonce:     enc.encode(msg.Nonce),
+		Eph:       enc.encode(msg.EphemeralKey),
+		KEM:       enc.encode(msg.KEMCipher),
+		Stream:    msg.Streamed,
+		Signature: enc.encode(msg.Signature),
+	}
+	if msg.IsEncrypted() {
+		jm.Type = JSONTypeEncrypted
+	}
+	body := enc.encode(msg.Body())
+	jm.Body = &body
+	if !msg.Created.IsZero() {
+		jm.Created = msg.Created.UTC().Format(time.RFC3339)
+	}
+	if !msg.NotAfter.IsZero() {
+		jm.NotAfter = msg.NotAfter.UTC().Format(time.RFC3339)
+	}
+	for _, s := range msg.Recipients {
+		jm.Recipients = append(jm.Recipients, jsonStanza{enc.encode(s.EphemeralKey), enc.encode(s.WrappedKey)})
+	}
+	if h, pemType, ok := msg.canonicalHeaders(); ok {
+		jm.Headers = h
+		jm.PEMType = pemType
+	} else {
+		jm.AAD = enc.encode(msg.AAD)
+	}
+	return json.Marshal(jm)
+}
+
+// MarshalJSON writes a Message as canonical JSON, with byte fields in base64url.
+// Decoding the output gives a Message with the same Digest.
+func (msg *Message) MarshalJSON() ([]byte, error) {
+	return msg.EncodeJSON(Base64URL)
+}
+
+// UnmarshalJSON reads a Message written by MarshalJSON or EncodeJSON. Unknown fields are an error.
+func (msg *Message) UnmarshalJSON(data []byte) error {
+	var jm jsonMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&jm); err != nil {
+		return fmt.Errorf("%w. %w", ErrBadMessage, err)
+	}
+	enc := jm.Encoding
+	var m Message
+	var err error
+	//	bytesOf decodes a field, keeping nil for a missing one. The first error sticks.
+	bytesOf := func(name, s string) []byte {
+		if err != nil || s == "" {
+			return nil
+		}
+		var b []byte
+		b, err = enc.decode(s)
+		if err != nil {
+			err = fmt.Errorf("%w. could not decode %s. %w", ErrBadMessage, name, err)
+		}
+		return b
+	}
+	if jm.Body == nil {
+		return fmt.Errorf("%w. no body", ErrBadMessage)
+	}
+	body := bytesOf("body", *jm.Body)
+	if body == nil {
+		body = []byte{}
+	}
+	switch jm.Type {
+	case JSONTypePlain:
+		m.PlainText = body
+	case JSONTypeEncrypted:
+		m.CipherText = body
+	default:
+		return fmt.Errorf("%w. unknown type %q", ErrBadMessage, jm.Type)
+	}
+	m.ID = bytesOf("id", jm.ID)
+	m.Sender = bytesOf("sender", jm.Sender)
+	m.Nonce = bytesOf("nonce", jm.Nonce)
+	m.EphemeralKey = bytesOf("eph", jm.Eph)
+	m.KEMCipher = bytesOf("kem", jm.KEM)
+	m.Signature = bytesOf("sig", jm.Signature)
+	m.AAD = bytesOf("aad", jm.AAD)
+	m.Streamed = jm.Stream
+	m.Suite = jm.Suite
+	m.DigestVersion = jm.Digest
+	for _, s := range jm.Recipients {
+		m.Recipients = append(m.Recipients, Stanza{bytesOf("recipient", s.EphemeralKey), bytesOf("recipient", s.WrappedKey)})
+	}
+	if err != nil {
+		return err
+	}
+	if m.Created, err = jsonTime("created", jm.Created); err != nil {
+		return err
+	}
+	if m.NotAfter, err = jsonTime("notAfter", jm.NotAfter); err != nil {
+		return err
+	}
+	if jm.Headers != nil || jm.PEMType != "" {
+		if m.AAD != nil {
+			return fmt.Errorf("%w. a message can carry opaque aad or headers, but not both", ErrBadMessage)
+		}
+		if jm.Headers != nil {
+			if err := m.SetHeaders(*jm.Headers); err != nil {
+				return err
+			}
+		}
+		if err := m.SetPEMType(jm.PEMType); err != nil {
+			return err
+		}
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	*msg = m
+	return nil
+}
+
+func jsonTime(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("%w. could not decode %s. %w", ErrBadMessage, name, err)
+	}
+	return t.UTC(), nil
+}
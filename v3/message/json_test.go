@@ -0,0 +1,99 @@
+package message
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_JSON(t *testing.T) {
+
+	roundTrip := func(t *testing.T, msg *Message, enc BinaryEncoding) *Message {
+		t.Helper()
+		data, err := msg.EncodeJSON(enc)
+		require.NoError(t, err)
+		got := new(Message)
+		require.NoError(t, json.Unmarshal(data, got))
+		want, err := msg.Digest()
+		require.NoError(t, err)
+		dig, err := got.Digest()
+		require.NoError(t, err)
+		assert.Equal(t, want, dig)
+		again, err := got.EncodeJSON(enc)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), string(again), "canonical")
+		return got
+	}
+
+	t.Run("signed plain message with headers", func(t *testing.T) {
+		msg := stamped(t, time.Hour)
+		require.NoError(t, msg.SetHeaders(typedHeaders(t)))
+		require.NoError(t, msg.SetPEMType("CUSTOM MESSAGE"))
+		msg.SetSender(alice(t).PublicKey())
+		require.NoError(t, msg.Sign(alice(t)))
+
+		data, err := json.Marshal(msg)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), `{"type":"oracle/message","encoding":"base64url","digest":2,"pemType":"CUSTOM MESSAGE"`))
+		assert.Contains(t, string(data), `"headers":{"bang":"!string !important","blob":"!bytes cafe"`)
+		assert.Contains(t, string(data), `"body":"aGVsbG8"`)
+
+		for _, enc := range []BinaryEncoding{Base64URL, Hex} {
+			got := roundTrip(t, msg, enc)
+			assert.Equal(t, msg, got)
+			assert.True(t, got.VerifySender(alice(t)))
+		}
+	})
+
+	t.Run("encrypted message to many", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello team")
+		msg.AAD = []byte("opaque aad")
+		require.NoError(t, msg.EncryptToMany(dRand(t, 2), []delphi.PublicKey{bob(t).PublicKey(), alice(t).PublicKey()}, alice(t)))
+		data, err := msg.EncodeJSON(Hex)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"type":"oracle/encrypted-message"`)
+		assert.Contains(t, string(data), `"aad":"`)
+		got := roundTrip(t, msg, Hex)
+		require.NoError(t, got.Decrypt(bob(t)))
+		assert.Equal(t, "hello team", string(got.PlainText))
+	})
+
+	t.Run("non-canonical AAD stays opaque", func(t *testing.T) {
+		msg := &Message{PlainText: []byte("hi"), AAD: mustMarshal(map[string]string{"sig": "reserved"})}
+		data, err := json.Marshal(msg)
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "headers")
+		roundTrip(t, msg, Base64URL)
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		msg := &Message{PlainText: []byte{}}
+		got := roundTrip(t, msg, Base64URL)
+		assert.Equal(t, []byte{}, got.PlainText)
+	})
+
+	bad := map[string]string{
+		"no body":          `{"type":"oracle/message","encoding":"hex"}`,
+		"unknown type":     `{"type":"oracle/nope","encoding":"hex","body":""}`,
+		"unknown encoding": `{"type":"oracle/message","encoding":"rot13","body":"abc"}`,
+		"bad hex":          `{"type":"oracle/message","encoding":"hex","body":"zz"}`,
+		"unknown field":    `{"type":"oracle/message","encoding":"hex","body":"","extra":1}`,
+		"aad and headers":  `{"type":"oracle/message","encoding":"hex","body":"","aad":"00","headers":{"a":"b"}}`,
+		"reserved header":  `{"type":"oracle/message","encoding":"hex","body":"","headers":{"nonce":"b"}}`,
+		"bad time":         `{"type":"oracle/message","encoding":"hex","body":"","created":"yesterday"}`,
+		"invalid":          `{"type":"oracle/encrypted-message","encoding":"hex","body":"00"}`,
+	}
+	for name, data := range bad {
+		t.Run(name, func(t *testing.T) {
+			msg := &Message{PlainText: []byte("untouched")}
+			assert.Error(t, json.Unmarshal([]byte(data), msg))
+			assert.Equal(t, "untouched", string(msg.PlainText))
+		})
+	}
+}
@@ -13,7 +13,10 @@ import (
 	"fmt"
 	"github.com/sean9999/go-oracle/v3/delphi"
 	"io"
-	"runtime"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
 
 	smap "github.com/sean9999/go-stable-map"
 	"github.com/vmihailenco/msgpack/v5"
@@ -23,12 +26,22 @@ import (
 const NonceSize = chacha20poly1305.NonceSize
 
 type Message struct {
-	PlainText    []byte `json:"plain,omitempty" msgpack:"plain,omitempty"`
-	CipherText   []byte `json:"ciph,omitempty" msgpack:"ciph,omitempty"`
-	AAD          []byte `json:"aad,omitempty" msgpack:"aad,omitempty"`
-	Nonce        []byte `json:"nonce,omitempty" msgpack:"nonce,omitempty"`
-	EphemeralKey []byte `json:"eph,omitempty" msgpack:"eph,omitempty"`
-	Signature    []byte `json:"sig,omitempty" msgpack:"sig,omitempty"`
+	PlainText    []byte         `json:"plain,omitempty" msgpack:"plain,omitempty"`
+	CipherText   []byte         `json:"ciph,omitempty" msgpack:"ciph,omitempty"`
+	AAD          []byte         `json:"aad,omitempty" msgpack:"aad,omitempty"`
+	Nonce        []byte         `json:"nonce,omitempty" msgpack:"nonce,omitempty"`
+	EphemeralKey []byte         `json:"eph,omitempty" msgpack:"eph,omitempty"`
+	Signature    []byte         `json:"sig,omitempty" msgpack:"sig,omitempty"`
+	Streamed     bool           `json:"stream,omitempty" msgpack:"stream,omitempty"`
+	Recipients   []Stanza       `json:"recipients,omitempty" msgpack:"recipients,omitempty"`
+	Sender       []byte         `json:"sender,omitempty" msgpack:"sender,omitempty"`
+	ID           []byte         `json:"id,omitempty" msgpack:"id,omitempty"`
+	Created      time.Time      `json:"created,omitzero" msgpack:"created,omitempty"`
+	NotAfter     time.Time      `json:"notAfter,omitzero" msgpack:"notAfter,omitempty"`
+	KEMCipher    []byte         `json:"kem,omitempty" msgpack:"kem,omitempty"`
+	Suite        delphi.SuiteID `json:"suite,omitempty" msgpack:"suite,omitempty"`
+	//	DigestVersion is set by Sign. It is zero for messages signed before digests were versioned
+	DigestVersion DigestVersion `json:"digest,omitempty" msgpack:"digest,omitempty"`
 }
 
 func NewMessage(randy io.Reader) *Message {
@@ -54,9 +67,18 @@ func (msg *Message) Validate() error {
 	if msg.IsEncrypted() && msg.Nonce == nil {
 		return fmt.Errorf("%w. encrypted data, but no nonce", ErrBadMessage)
 	}
+	if _, err := delphi.SuiteByID(msg.Suite); err != nil {
+		return fmt.Errorf("%w. %w", ErrBadMessage, err)
+	}
+	if msg.IsHybrid() && !msg.IsEncrypted() {
+		return fmt.Errorf("%w. hybrid, but not encrypted", ErrBadMessage)
+	}
 	if msg.Signature != nil && msg.Nonce == nil {
 		return fmt.Errorf("%w. signature, but no nonce", ErrBadMessage)
 	}
+	if v := msg.digestVersion(); v != DigestV1 && v != DigestV2 {
+		return fmt.Errorf("%w. unknown digest version %d", ErrBadMessage, v)
+	}
 	return nil
 }
 
@@ -67,37 +89,157 @@ func (msg *Message) MustValidate() {
 	}
 }
 
+// A DigestVersion names the layout a Digest hashes. A message that doesn't carry one was signed with DigestV1.
+type DigestVersion uint8
+
+const (
+	// DigestV1 is the original layout: fields run together with no lengths between them.
+	// It is only kept so that messages signed with it still verify.
+	DigestV1 DigestVersion = 1
+	// DigestV2 length-prefixes every field, and also covers the flags, ephemeral key and KEM ciphertext.
+	DigestV2 DigestVersion = 2
+)
+
+// CurrentDigestVersion is what Sign uses.
+const CurrentDigestVersion = DigestV2
+
+// digestDomain prefixes everything a DigestV2 covers.
+const digestDomain = "oracle/digest/v2\x00"
+
+// digestVersion is the layout the message was signed with.
+func (msg *Message) digestVersion() DigestVersion {
+	if msg.DigestVersion == 0 {
+		return DigestV1
+	}
+	return msg.DigestVersion
+}
+
+// Digest is the SHA-256 of everything a signature covers, laid out as the message's DigestVersion says.
 func (msg *Message) Digest() ([]byte, error) {
 	err := msg.Validate()
 	if err != nil {
 		return nil, err
 	}
+	switch msg.digestVersion() {
+	case DigestV1:
+		return msg.digestV1(), nil
+	case DigestV2:
+		return msg.digestV2(), nil
+	default:
+		return nil, fmt.Errorf("%w. unknown digest version %d", ErrBadMessage, msg.DigestVersion)
+	}
+}
+
+// digestV1 runs fields together, so bytes can move from one to the next without changing it.
+// Despite the name, it is not a hash: it is the fields themselves, followed by the SHA-256 of nothing.
+func (msg *Message) digestV1() []byte {
 	hash := sha256.New()
 	sum := make([]byte, 0)
 	sum = append(sum, msg.Nonce...)
+	sum = append(sum, msg.senderBinding()...)
+	sum = append(sum, msg.metadataBinding()...)
+	sum = append(sum, msg.suiteBinding()...)
 	if msg.IsEncrypted() {
 		sum = append(sum, msg.CipherText...)
 	} else {
 		sum = append(sum, msg.PlainText...)
 	}
 	sum = append(sum, msg.AAD...)
-	return hash.Sum(sum), nil
+	for _, stanza := range msg.Recipients {
+		sum = append(sum, stanza.EphemeralKey...)
+		sum = append(sum, stanza.WrappedKey...)
+	}
+	return hash.Sum(sum)
 }
 
+// digestV2 length-prefixes every field, so bytes can't move from one field to another without changing it.
+func (msg *Message) digestV2() []byte {
+	hash := sha256.New()
+	hash.Write([]byte(digestDomain))
+	field := func(b []byte) {
+		hash.Write(binary.AppendUvarint(nil, uint64(len(b))))
+		hash.Write(b)
+	}
+	var flags byte
+	if msg.IsEncrypted() {
+		flags |= 1
+	}
+	if msg.Streamed {
+		flags |= 2
+	}
+	hash.Write([]byte{flags})
+	field(msg.Nonce)
+	field(msg.senderBinding())
+	field(msg.metadataBinding())
+	field(msg.suiteBinding())
+	if msg.IsEncrypted() {
+		field(msg.CipherText)
+	} else {
+		field(msg.PlainText)
+	}
+	field(msg.AAD)
+	field(msg.EphemeralKey)
+	field(msg.KEMCipher)
+	hash.Write(binary.AppendUvarint(nil, uint64(len(msg.Recipients))))
+	for _, stanza := range msg.Recipients {
+		field(stanza.EphemeralKey)
+		field(stanza.WrappedKey)
+	}
+	return hash.Sum(nil)
+}
+
+// Serialize encodes a Message in the binary wire format.
 func (msg *Message) Serialize() []byte {
-	//	since we know what a message is made of, we know that msgpack.Marshal will always pass
-	//	There is a fuzzing test to test this assumption
-	b, _ := msgpack.Marshal(msg)
+	//	MarshalBinary can't fail. There is a fuzzing test to test this assumption
+	b, _ := msg.MarshalBinary()
 	return b
 }
 
+// Deserialize reads the binary wire format or, failing that, legacy msgpack.
+// It panics on bad input. Use DecodeMessage if you'd rather have an error.
 func (msg *Message) Deserialize(b []byte) {
-	err := msgpack.Unmarshal(b, msg)
+	err := msg.decode(b)
 	if err != nil {
 		panic(err)
 	}
 }
 
+// DecodeMessage reads a Message from the binary wire format, a PEM block, or legacy msgpack,
+// and checks that it is valid. Unlike Deserialize, it never panics on bad input.
+func DecodeMessage(data []byte) (*Message, error) {
+	msg := new(Message)
+	var err error
+	switch {
+	case IsWireFormat(data):
+		err = msg.UnmarshalBinary(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN ")):
+		err = msg.UnmarshalPEM(data)
+	default:
+		err = msgpack.Unmarshal(data, (*legacyMessage)(msg))
+	}
+	if err != nil {
+		if !errors.Is(err, ErrBadMessage) {
+			err = fmt.Errorf("%w. %w", ErrBadMessage, err)
+		}
+		return nil, err
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// legacyMessage is a Message without its methods, so that msgpack treats it as a plain struct
+// rather than calling MarshalBinary and UnmarshalBinary.
+type legacyMessage Message
+
+func (msg *Message) decode(b []byte) error {
+	if IsWireFormat(b) {
+		return msg.UnmarshalBinary(b)
+	}
+	return msgpack.Unmarshal(b, (*legacyMessage)(msg))
+}
+
 // asBytes takes a thing and returns it as a byte-slice, or error.
 func asBytes(thing any) ([]byte, error) {
 	data, ok := thing.([]byte)
@@ -125,7 +267,20 @@ type Decrypter interface {
 }
 
 func (msg *Message) Decrypt(recipient Decrypter) error {
-	plainText, err := recipient.Decrypt(msg.CipherText, msg.EphemeralKey, msg.Nonce, msg.AAD)
+	if msg.Streamed {
+		return msg.decryptStream(recipient)
+	}
+	if len(msg.Recipients) > 0 {
+		return msg.decryptMulti(recipient)
+	}
+	if msg.IsHybrid() {
+		return msg.decryptHybrid(recipient)
+	}
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return fmt.Errorf("could not decrypt. %w", err)
+	}
+	plainText, err := msg.open(recipient, suite, msg.CipherText, msg.EphemeralKey, msg.additionalData())
 	if err != nil {
 		return err
 	}
@@ -134,6 +289,26 @@ func (msg *Message) Decrypt(recipient Decrypter) error {
 	return nil
 }
 
+// decryptStream decrypts a streamed body that is held entirely in CipherText.
+func (msg *Message) decryptStream(recipient Decrypter) error {
+	extractor, ok := recipient.(SecretExtractor)
+	if !ok {
+		return errors.New("could not decrypt. recipient can not decrypt streams")
+	}
+	r, err := msg.DecryptReader(bytes.NewReader(msg.CipherText), extractor)
+	if err != nil {
+		return err
+	}
+	plainText, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	msg.PlainText = plainText
+	msg.CipherText = nil
+	msg.Streamed = false
+	return nil
+}
+
 type SymmetricEncrypter interface {
 	Seal([]byte, []byte, []byte, []byte) ([]byte, error)
 	GenerateSharedSecret(io.Reader, crypto.PublicKey) ([]byte, []byte, error)
@@ -144,35 +319,46 @@ type secretSealer interface {
 	GenerateSharedSecret(io.Reader, delphi.PublicKey) ([]byte, []byte, error)
 }
 
+// Encrypt encrypts a message for one recipient, with the message's cipher suite.
 func (msg *Message) Encrypt(randy io.Reader, recipient delphi.PublicKey, e secretSealer) error {
 
-	if len(msg.Nonce) == 0 {
-		msg.Nonce = make([]byte, NonceSize)
-		randy.Read(msg.Nonce)
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := msg.makeNonce(randy, suite); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
 	}
 	if msg.PlainText == nil {
 		return errors.New("no plain text to encrypt")
 	}
+	if err := canEncryptTo(recipient); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := checkRevoked(e, recipient); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
 
 	sec, eph, err := e.GenerateSharedSecret(randy, recipient)
 	if err != nil {
 		return fmt.Errorf("could not encrypt. %w", err)
 	}
-	cipherText, err := e.Seal(sec, msg.PlainText, msg.Nonce, msg.AAD)
+	defer delphi.Wipe(sec)
+	cipherText, err := msg.seal(e, suite, sec, msg.PlainText, msg.additionalData())
 	if err != nil {
 		return fmt.Errorf("could not encrypt. %w", err)
 	}
 	msg.EphemeralKey = eph
 	msg.CipherText = cipherText
 	msg.PlainText = nil
-
-	//	run garbage collection because we don't want to leak the plain text
-	runtime.GC()
-
 	return nil
 }
 
 func (msg *Message) Sign(signer crypto.Signer) error {
+	if err := canSign(signer); err != nil {
+		return err
+	}
+	msg.DigestVersion = CurrentDigestVersion
 	dig, err := msg.Digest()
 	if err != nil {
 		return err
@@ -190,12 +376,26 @@ type Verifier interface {
 }
 
 func (msg *Message) Verify(pubKey crypto.PublicKey, v Verifier) bool {
-	digest, err := msg.Digest()
+	return msg.CheckSignature(pubKey, v) == nil
+}
 
+// CheckSignature is like Verify, but says why verification failed.
+// If v is a RevocationChecker, a signature from a revoked key is an error.
+func (msg *Message) CheckSignature(pubKey crypto.PublicKey, v Verifier) error {
+	digest, err := msg.Digest()
 	if err != nil {
-		return false
+		return err
+	}
+	if err := canVerify(pubKey); err != nil {
+		return err
 	}
-	return v.Verify(pubKey, digest, msg.Signature)
+	if err := checkRevoked(v, pubKey); err != nil {
+		return err
+	}
+	if !v.Verify(pubKey, digest, msg.Signature) {
+		return ErrBadSignature
+	}
+	return nil
 }
 
 //
@@ -232,6 +432,9 @@ func (msg *Message) Body() []byte {
 
 func aadToHeaders(aad []byte) (headers map[string]string) {
 	headers = make(map[string]string)
+	if len(aad) == 0 {
+		return headers
+	}
 	sm := smap.From(headers)
 	err := sm.UnmarshalBinary(aad)
 	if err == nil {
@@ -255,16 +458,46 @@ func (msg *Message) ToPEM() pem.Block {
 	if msg.Signature != nil {
 		headers["sig"] = fmt.Sprintf("%x", msg.Signature)
 	}
-	pemType := headers["pemType"]
+	if msg.Streamed {
+		headers["stream"] = "true"
+	}
+	if len(msg.Recipients) > 0 {
+		headers["recipients"] = stanzasToHeader(msg.Recipients)
+	}
+	if msg.Sender != nil {
+		headers["sender"] = fmt.Sprintf("%x", msg.Sender)
+	}
+	if len(msg.ID) > 0 {
+		headers["id"] = fmt.Sprintf("%x", msg.ID)
+	}
+	if !msg.Created.IsZero() {
+		headers["created"] = msg.Created.UTC().Format(time.RFC3339)
+	}
+	if msg.KEMCipher != nil {
+		headers["kem"] = fmt.Sprintf("%x", msg.KEMCipher)
+	}
+	if msg.Suite != 0 {
+		headers["suite"] = strconv.Itoa(int(msg.Suite))
+	}
+	if msg.DigestVersion != 0 {
+		headers["digest"] = strconv.Itoa(int(msg.DigestVersion))
+	}
+	if !msg.NotAfter.IsZero() {
+		headers["not-after"] = msg.NotAfter.UTC().Format(time.RFC3339)
+	}
+	pemType := headers[pemTypeHeader]
 	if pemType == "" {
-		if msg.IsEncrypted() {
+		if msg.IsHybrid() {
+			headers["encrypted"] = "true"
+			pemType = PEMHybridMessage
+		} else if msg.IsEncrypted() {
 			headers["encrypted"] = "true"
 			pemType = "ORACLE ENCRYPTED MESSAGE"
 		} else {
 			pemType = "ORACLE MESSAGE"
 		}
 	}
-	delete(headers, "pemType")
+	delete(headers, pemTypeHeader)
 	block := pem.Block{
 		Type:    pemType,
 		Bytes:   msg.Body(),
@@ -305,10 +538,14 @@ func extractFields(ptr *map[string]string) (encrypted bool, nonce, sig, eph, aad
 	}
 	delete(headers, "eph")
 
+	//	if there are no custom headers at all, there is no AAD, which is fine.
+	if len(headers) == 0 {
+		return encrypted, nonce, sig, eph, aad, err
+	}
+
 	//	if there is exactly one remaining key, and it's called "aad", we're good
 	if len(headers) == 1 && headers["aad"] != "" {
-		dst := make([]byte, 0, base64.StdEncoding.DecodedLen(len(headers["aad"])))
-		_, err = base64.StdEncoding.Decode(dst, []byte(headers["aad"]))
+		aad, err = base64.StdEncoding.DecodeString(headers["aad"])
 		if err != nil {
 			return encrypted, nonce, sig, eph, aad, fmt.Errorf("could not decode aad. %w", err)
 		}
@@ -323,20 +560,61 @@ func extractFields(ptr *map[string]string) (encrypted bool, nonce, sig, eph, aad
 		return encrypted, nonce, sig, eph, aad, err
 	}
 
-	//	if there are no custom headers at all, there is no AAD, which is fine.
-	if len(headers) == 0 {
-		return encrypted, nonce, sig, eph, aad, err
-	}
-
 	//	It is an error to have an aad header and any other custom header(s).
-	err = fmt.Errorf("there was an aad header in addition to %v", headers)
+	delete(headers, "aad")
+	err = fmt.Errorf("%w. a PEM can carry opaque aad or custom headers, but not both. found aad alongside %v", ErrBadMessage, slices.Sorted(maps.Keys(headers)))
 	return encrypted, nonce, sig, eph, aad, err
 
 }
 
 func (msg *Message) reconstituteFromPEM(block *pem.Block) error {
 	headers := block.Headers
-	headers["pemType"] = block.Type
+	//	a custom PEM type is carried in AAD. The default types are derived, so they are not.
+	if block.Type != "ORACLE MESSAGE" && block.Type != "ORACLE ENCRYPTED MESSAGE" && block.Type != PEMHybridMessage {
+		headers[pemTypeHeader] = block.Type
+	}
+	streamed := headers["stream"] == "true"
+	delete(headers, "stream")
+	recipients, err := stanzasFromHeader(headers["recipients"])
+	if err != nil {
+		return err
+	}
+	delete(headers, "recipients")
+	var sender []byte
+	if headers["sender"] != "" {
+		sender, err = hex.DecodeString(headers["sender"])
+		if err != nil {
+			return fmt.Errorf("could not decode sender. %w", err)
+		}
+	}
+	delete(headers, "sender")
+	var kem []byte
+	if headers["kem"] != "" {
+		kem, err = hex.DecodeString(headers["kem"])
+		if err != nil {
+			return fmt.Errorf("could not decode kem. %w", err)
+		}
+	}
+	delete(headers, "kem")
+	var suite uint64
+	if headers["suite"] != "" {
+		suite, err = strconv.ParseUint(headers["suite"], 10, 8)
+		if err != nil {
+			return fmt.Errorf("could not decode suite. %w", err)
+		}
+	}
+	delete(headers, "suite")
+	var digest uint64
+	if headers["digest"] != "" {
+		digest, err = strconv.ParseUint(headers["digest"], 10, 8)
+		if err != nil {
+			return fmt.Errorf("could not decode digest version. %w", err)
+		}
+	}
+	delete(headers, "digest")
+	if err := msg.metadataFromHeaders(headers); err != nil {
+		return err
+	}
 	encrypted, nonce, sig, eph, aad, err := extractFields(&headers)
 	if encrypted {
 		msg.CipherText = block.Bytes
@@ -347,6 +625,12 @@ func (msg *Message) reconstituteFromPEM(block *pem.Block) error {
 	msg.EphemeralKey = eph
 	msg.Signature = sig
 	msg.AAD = aad
+	msg.Streamed = streamed
+	msg.Recipients = recipients
+	msg.Sender = sender
+	msg.KEMCipher = kem
+	msg.Suite = delphi.SuiteID(suite)
+	msg.DigestVersion = DigestVersion(digest)
 	return err
 }
 
@@ -355,6 +639,9 @@ func (msg *Message) MarshalPEM() ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not marshal. validation failed. %w", err)
 	}
+	if err := msg.checkHeaders(); err != nil {
+		return nil, fmt.Errorf("could not marshal. %w", err)
+	}
 	block := msg.ToPEM()
 	buf := new(bytes.Buffer)
 	err = pem.Encode(buf, &block)
@@ -17,6 +17,7 @@ import (
 	smap "github.com/sean9999/go-stable-map"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
 	"golang.org/x/crypto/chacha20poly1305"
 )
 
@@ -211,6 +212,12 @@ func TestMessage_Digest(t *testing.T) {
 		dig, err := msg.Digest()
 		assert.NoError(t, err)
 		assert.Equal(t, "07070707070707070707070768656c6c6f20776f726c64e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(dig))
+
+		v2 := *msg
+		v2.DigestVersion = DigestV2
+		dig, err = v2.Digest()
+		assert.NoError(t, err)
+		assert.Equal(t, "4245a4fdad95b39b60e7ca8824dfe5b27ef634a9e90cc6b01b08685220acfa12", hex.EncodeToString(dig))
 	})
 
 	t.Run("encrypted message", func(t *testing.T) {
@@ -221,6 +228,12 @@ func TestMessage_Digest(t *testing.T) {
 		dig, err := msg.Digest()
 		assert.NoError(t, err)
 		assert.Equal(t, "0707070707070707070707074d8c17f1ec5b98a761706549bdff277d00944c28bf4f5bca132922e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(dig))
+
+		v2 := *msg
+		v2.DigestVersion = DigestV2
+		dig, err = v2.Digest()
+		assert.NoError(t, err)
+		assert.Equal(t, "cdd94b0683dbc205118b3fd9cf7a60891e0794797d5b49e15893c72203aacb84", hex.EncodeToString(dig))
 	})
 
 	t.Run("encrypt a message with no initial nonce", func(t *testing.T) {
@@ -233,6 +246,75 @@ func TestMessage_Digest(t *testing.T) {
 		dig, err := msg.Digest()
 		assert.NoError(t, err)
 		assert.Equal(t, "0707070707070707070707074d8c17f1ec5b98a761706549bdff277d00944c28bf4f5bca132922e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", hex.EncodeToString(dig))
+
+		msg.DigestVersion = DigestV2
+		dig, err = msg.Digest()
+		assert.NoError(t, err)
+		assert.Equal(t, "cdd94b0683dbc205118b3fd9cf7a60891e0794797d5b49e15893c72203aacb84", hex.EncodeToString(dig))
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		msg := &Message{PlainText: []byte("hi"), DigestVersion: 3}
+		_, err := msg.Digest()
+		assert.ErrorIs(t, err, ErrBadMessage)
+	})
+
+	t.Run("unversioned signatures still verify", func(t *testing.T) {
+		//	a message signed before digests were versioned carries no version, and was signed over the V1 layout
+		legacy := &Message{PlainText: []byte("hello"), Nonce: []byte("nonce")}
+		sig, err := alice(t).Sign(nil, legacy.digestV1(), nil)
+		require.NoError(t, err)
+		legacy.Signature = sig
+		got := new(Message)
+		require.NoError(t, got.UnmarshalBinary(legacy.Serialize()))
+		assert.Zero(t, got.DigestVersion)
+		assert.NoError(t, got.CheckSignature(alice(t).PublicKey().Signing(), alice(t)))
+
+		//	new signatures are V2, and can't be passed off as V1
+		fresh := &Message{PlainText: []byte("hello"), Nonce: []byte("nonce")}
+		require.NoError(t, fresh.Sign(alice(t)))
+		assert.Equal(t, DigestV2, fresh.DigestVersion)
+		fromWire := new(Message)
+		require.NoError(t, fromWire.UnmarshalBinary(fresh.Serialize()))
+		bin, err := fresh.MarshalPEM()
+		require.NoError(t, err)
+		fromPEM := new(Message)
+		require.NoError(t, fromPEM.UnmarshalPEM(bin))
+		for _, again := range []*Message{fromWire, fromPEM} {
+			assert.Equal(t, DigestV2, again.DigestVersion)
+			assert.NoError(t, again.CheckSignature(alice(t).PublicKey().Signing(), alice(t)))
+		}
+		fresh.DigestVersion = 0
+		assert.ErrorIs(t, fresh.CheckSignature(alice(t).PublicKey().Signing(), alice(t)), ErrBadSignature)
+	})
+
+	t.Run("bytes can't move between fields", func(t *testing.T) {
+		digest := func(msg *Message) string {
+			t.Helper()
+			msg.DigestVersion = DigestV2
+			dig, err := msg.Digest()
+			require.NoError(t, err)
+			return hex.EncodeToString(dig)
+		}
+		base := &Message{CipherText: []byte("ciph"), Nonce: []byte("nonce"), AAD: []byte("aad"),
+			Recipients: []Stanza{{EphemeralKey: []byte("eph"), WrappedKey: []byte("key")}}}
+		seen := map[string]bool{digest(base): true}
+		for _, msg := range []*Message{
+			{CipherText: []byte("ciph"), Nonce: []byte("nonce"), AAD: []byte("aade"),
+				Recipients: []Stanza{{EphemeralKey: []byte("ph"), WrappedKey: []byte("key")}}},
+			{CipherText: []byte("ciph"), Nonce: []byte("nonce"), AAD: []byte("aad"),
+				Recipients: []Stanza{{EphemeralKey: []byte("ephk"), WrappedKey: []byte("ey")}}},
+			{CipherText: []byte("ciph"), Nonce: []byte("nonceaad"),
+				Recipients: []Stanza{{EphemeralKey: []byte("eph"), WrappedKey: []byte("key")}}},
+			{CipherText: []byte("ciph"), Nonce: []byte("nonce"), AAD: []byte("aad"),
+				Recipients: []Stanza{{EphemeralKey: []byte("eph")}, {WrappedKey: []byte("key")}}},
+			{PlainText: []byte("ciph"), Nonce: []byte("nonce"), AAD: []byte("aad"),
+				Recipients: []Stanza{{EphemeralKey: []byte("eph"), WrappedKey: []byte("key")}}},
+		} {
+			dig := digest(msg)
+			assert.False(t, seen[dig], "collision for %+v", msg)
+			seen[dig] = true
+		}
 	})
 
 	t.Run("you can't encrypt a message with no plain text", func(t *testing.T) {
@@ -370,6 +452,16 @@ func TestMessage_SignVerify(t *testing.T) {
 		isValid := invalidMsg.Verify(pubKey, verifier)
 		assert.False(t, isValid)
 	})
+
+	t.Run("verify with a whole public key", func(t *testing.T) {
+		kp := alice(t)
+		msg := &Message{PlainText: []byte("sign me"), Nonce: []byte("nonce")}
+		require.NoError(t, msg.Sign(kp))
+		assert.NotPanics(t, func() {
+			assert.True(t, msg.Verify(kp.PublicKey(), kp))
+			assert.False(t, msg.Verify(bob(t).PublicKey(), kp))
+		})
+	})
 }
 
 func TestMessage_Sign(t *testing.T) {
@@ -505,6 +597,22 @@ func TestMessage_PEM(t *testing.T) {
 		assert.Equal(t, encMsg.Nonce, newMsg.Nonce)
 	})
 
+	t.Run("round-trip with no AAD", func(t *testing.T) {
+		plainMsg := &Message{
+			PlainText: []byte("no aad"),
+			Nonce:     []byte("123456789012"),
+		}
+		pemBytes, err := plainMsg.MarshalPEM()
+		require.NoError(t, err)
+		assert.NotContains(t, string(pemBytes), "aad")
+
+		newMsg := &Message{}
+		err = newMsg.UnmarshalPEM(pemBytes)
+		require.NoError(t, err)
+		assert.Nil(t, newMsg.AAD)
+		assert.Equal(t, plainMsg.PlainText, newMsg.PlainText)
+	})
+
 	t.Run("marshalpem fails on invalid message", func(t *testing.T) {
 		invalidMsg := &Message{}
 		_, err := invalidMsg.MarshalPEM()
@@ -686,17 +794,66 @@ func dRand(t testing.TB, i byte) io.Reader {
 	return deterministicReader(i)
 }
 
-func alice(t *testing.T) delphi.KeyPair {
+func alice(t *testing.T) *delphi.KeyPair {
 	t.Helper()
 	randy := dRand(t, 3)
-	return delphi.NewKeyPair(randy)
+	kp := delphi.NewKeyPair(randy)
+	return &kp
 }
 
-func bob(t *testing.T) delphi.KeyPair {
+func bob(t *testing.T) *delphi.KeyPair {
 	t.Helper()
 	randy := dRand(t, 4)
-	return delphi.NewKeyPair(randy)
+	kp := delphi.NewKeyPair(randy)
+	return &kp
+}
+func TestDecodeMessage(t *testing.T) {
+	msg := NewMessage(dRand(t, 1))
+	msg.PlainText = []byte("hello")
+	require.NoError(t, msg.Sign(alice(t)))
+
+	t.Run("wire format", func(t *testing.T) {
+		got, err := DecodeMessage(msg.Serialize())
+		require.NoError(t, err)
+		assert.Equal(t, msg.PlainText, got.PlainText)
+		assert.Equal(t, msg.Signature, got.Signature)
+	})
+
+	t.Run("PEM", func(t *testing.T) {
+		data, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		got, err := DecodeMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg.PlainText, got.PlainText)
+	})
+
+	t.Run("legacy msgpack", func(t *testing.T) {
+		data, err := msgpack.Marshal((*legacyMessage)(msg))
+		require.NoError(t, err)
+		got, err := DecodeMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg.PlainText, got.PlainText)
+	})
+
+	bad := map[string][]byte{
+		"garbage":   []byte("this is not msgpack"),
+		"empty":     nil,
+		"truncated": msg.Serialize()[:10],
+		"bad PEM":   []byte("-----BEGIN ORACLE MESSAGE-----\nnope"),
+		"invalid":   []byte("OMSG\x01"),
+		"no nonce":  appendField(appendField([]byte("OMSG\x01"), tagCiph, []byte("x")), tagSig, []byte("y")),
+	}
+	for name, data := range bad {
+		t.Run(name, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				got, err := DecodeMessage(data)
+				assert.ErrorIs(t, err, ErrBadMessage)
+				assert.Nil(t, got)
+			})
+		})
+	}
 }
+
 func TestMessage_Encrypt(t *testing.T) {
 	randy := dRand(t, 7)
 	alice := alice(t)
@@ -0,0 +1,260 @@
+package message
+
+import (
+	"container/heap"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// metadataDomain prefixes the metadata wherever it is bound into AEAD associated data or a digest.
+const metadataDomain = "oracle/metadata\x00"
+
+// IDSize is the size of a message ID produced by Stamp.
+const IDSize = 16
+
+var (
+	ErrExpired     = errors.New("message has expired")
+	ErrNotYetValid = errors.New("message is from the future")
+	ErrReplay      = errors.New("message has been seen before")
+	ErrNoID        = errors.New("message has no ID")
+)
+
+// Stamp gives a Message a random ID and a creation time, and, if ttl is positive, an expiry.
+// Like the sender, metadata is bound into the AEAD associated data and the digest,
+// so it must be stamped before encrypting or signing.
+func (msg *Message) Stamp(randy io.Reader, now time.Time, ttl time.Duration) error {
+	id := make([]byte, IDSize)
+	if _, err := io.ReadFull(randy, id); err != nil {
+		return fmt.Errorf("could not generate message ID. %w", err)
+	}
+	msg.ID = id
+	msg.Created = now.UTC().Truncate(time.Second)
+	msg.NotAfter = time.Time{}
+	if ttl > 0 {
+		msg.NotAfter = msg.Created.Add(ttl)
+	}
+	return nil
+}
+
+func (msg *Message) hasMetadata() bool {
+	return len(msg.ID) > 0 || !msg.Created.IsZero() || !msg.NotAfter.IsZero()
+}
+
+// unixTime is seconds since the epoch, with the zero Time as 0.
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// fromUnixTime is the inverse of unixTime.
+func fromUnixTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
+
+// metadataBinding is the domain-separated ID, creation time and expiry, or nothing.
+func (msg *Message) metadataBinding() []byte {
+	if !msg.hasMetadata() {
+		return nil
+	}
+	b := []byte(metadataDomain)
+	b = binary.AppendUvarint(b, uint64(len(msg.ID)))
+	b = append(b, msg.ID...)
+	b = binary.BigEndian.AppendUint64(b, uint64(unixTime(msg.Created)))
+	return binary.BigEndian.AppendUint64(b, uint64(unixTime(msg.NotAfter)))
+}
+
+// A ReplayCache remembers message IDs.
+// Seen records id and reports whether it was already there. It need only remember id until the given time.
+// A zero time means forever.
+type ReplayCache interface {
+	Seen(id []byte, until time.Time) bool
+}
+
+// VerifyOptions adds checks on top of the signature.
+type VerifyOptions struct {
+	//	Now is the time to check expiry against. The zero value means time.Now().
+	Now time.Time
+	//	Skew is how far in the future a message's creation time may be.
+	Skew time.Duration
+	//	MaxAge, if positive, rejects messages created longer ago than this, or with no creation time.
+	MaxAge time.Duration
+	//	Replay, if not nil, rejects messages whose ID it has seen. Messages without an ID are rejected.
+	Replay ReplayCache
+}
+
+// CheckSignatureWith is like CheckSignature, but also applies opts.
+// The signature is checked first, so that a forged message can never land in the replay cache.
+func (msg *Message) CheckSignatureWith(pubKey crypto.PublicKey, v Verifier, opts VerifyOptions) error {
+	if err := msg.CheckSignature(pubKey, v); err != nil {
+		return err
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if !msg.NotAfter.IsZero() && now.After(msg.NotAfter) {
+		return fmt.Errorf("%w. expired at %s", ErrExpired, msg.NotAfter.Format(time.RFC3339))
+	}
+	if !msg.Created.IsZero() && msg.Created.After(now.Add(opts.Skew)) {
+		return fmt.Errorf("%w. created at %s", ErrNotYetValid, msg.Created.Format(time.RFC3339))
+	}
+	until := msg.NotAfter
+	if opts.MaxAge > 0 {
+		if msg.Created.IsZero() {
+			return fmt.Errorf("%w. no creation time", ErrExpired)
+		}
+		oldest := msg.Created.Add(opts.MaxAge)
+		if now.After(oldest) {
+			return fmt.Errorf("%w. created at %s", ErrExpired, msg.Created.Format(time.RFC3339))
+		}
+		if until.IsZero() || oldest.Before(until) {
+			until = oldest
+		}
+	}
+	if opts.Replay != nil {
+		if len(msg.ID) == 0 {
+			return ErrNoID
+		}
+		if opts.Replay.Seen(msg.ID, until) {
+			return fmt.Errorf("%w. id %x", ErrReplay, msg.ID)
+		}
+	}
+	return nil
+}
+
+// DefaultReplayCacheSize is how many IDs a MemoryReplayCache holds, unless told otherwise.
+const DefaultReplayCacheSize = 1 << 16
+
+// MemoryReplayCache is a ReplayCache that forgets IDs once they no longer matter.
+// It holds at most Max IDs, or DefaultReplayCacheSize if Max is not positive. When it is full,
+// it forgets the ID that would have expired soonest, or, if every ID is kept forever, the oldest one.
+// A forgotten ID can be replayed, so messages that never expire are best checked with a MaxAge.
+// The zero value is ready to use.
+type MemoryReplayCache struct {
+	Max  int
+	mu   sync.Mutex
+	now  func() time.Time
+	seen map[string]*replayEntry
+	//	queue orders IDs by when they ought to be forgotten, soonest first
+	queue replayQueue
+	seq   uint64
+}
+
+type replayEntry struct {
+	id    string
+	until time.Time
+	seq   uint64
+}
+
+// before reports whether e should be forgotten before f.
+func (e *replayEntry) before(f *replayEntry) bool {
+	switch {
+	case e.until.IsZero() != f.until.IsZero():
+		return f.until.IsZero()
+	case !e.until.Equal(f.until):
+		return e.until.Before(f.until)
+	default:
+		return e.seq < f.seq
+	}
+}
+
+// replayQueue is a container/heap of replayEntries.
+type replayQueue []*replayEntry
+
+func (q replayQueue) Len() int           { return len(q) }
+func (q replayQueue) Less(i, j int) bool { return q[i].before(q[j]) }
+func (q replayQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *replayQueue) Push(x any)        { *q = append(*q, x.(*replayEntry)) }
+
+func (q *replayQueue) Pop() any {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return e
+}
+
+func NewMemoryReplayCache() *MemoryReplayCache {
+	return &MemoryReplayCache{Max: DefaultReplayCacheSize, now: time.Now, seen: map[string]*replayEntry{}}
+}
+
+func (c *MemoryReplayCache) Seen(id []byte, until time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen == nil {
+		c.seen = map[string]*replayEntry{}
+	}
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	//	IDs that are kept forever sort last, so this stops at the first one
+	for len(c.queue) > 0 {
+		e := c.queue[0]
+		if e.until.IsZero() || !now.After(e.until) {
+			break
+		}
+		c.evict()
+	}
+	key := string(id)
+	if _, ok := c.seen[key]; ok {
+		return true
+	}
+	limit := c.Max
+	if limit <= 0 {
+		limit = DefaultReplayCacheSize
+	}
+	for len(c.seen) >= limit {
+		c.evict()
+	}
+	c.seq++
+	e := &replayEntry{id: key, until: until, seq: c.seq}
+	c.seen[key] = e
+	heap.Push(&c.queue, e)
+	return false
+}
+
+// evict forgets one ID, the first that ought to go.
+func (c *MemoryReplayCache) evict() {
+	e := heap.Pop(&c.queue).(*replayEntry)
+	delete(c.seen, e.id)
+}
+
+var _ ReplayCache = (*MemoryReplayCache)(nil)
+
+// metadataFromHeaders reads and removes the PEM headers written by ToPEM.
+func (msg *Message) metadataFromHeaders(headers map[string]string) error {
+	var err error
+	msg.ID = nil
+	if headers["id"] != "" {
+		msg.ID, err = hex.DecodeString(headers["id"])
+		if err != nil {
+			return fmt.Errorf("could not decode id. %w", err)
+		}
+	}
+	delete(headers, "id")
+	for name, t := range map[string]*time.Time{"created": &msg.Created, "not-after": &msg.NotAfter} {
+		*t = time.Time{}
+		if headers[name] != "" {
+			*t, err = time.Parse(time.RFC3339, headers[name])
+			if err != nil {
+				return fmt.Errorf("could not decode %s. %w", name, err)
+			}
+			*t = t.UTC()
+		}
+		delete(headers, name)
+	}
+	return nil
+}
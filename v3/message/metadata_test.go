@@ -0,0 +1,189 @@
+package message
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var stampedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func stamped(t *testing.T, ttl time.Duration) *Message {
+	t.Helper()
+	msg := NewMessage(dRand(t, 1))
+	msg.PlainText = []byte("hello")
+	require.NoError(t, msg.Stamp(dRand(t, 2), stampedAt, ttl))
+	require.NoError(t, msg.Sign(alice(t)))
+	return msg
+}
+
+func TestMessage_Stamp(t *testing.T) {
+	msg := stamped(t, time.Hour)
+	assert.Len(t, msg.ID, IDSize)
+	assert.Equal(t, stampedAt, msg.Created)
+	assert.Equal(t, stampedAt.Add(time.Hour), msg.NotAfter)
+
+	t.Run("covered by the digest", func(t *testing.T) {
+		pub := alice(t).PublicKey().Signing()
+		assert.True(t, msg.Verify(pub, alice(t)))
+		for name, tamper := range map[string]func(m *Message){
+			"id":        func(m *Message) { m.ID = []byte("another id") },
+			"created":   func(m *Message) { m.Created = m.Created.Add(time.Second) },
+			"not after": func(m *Message) { m.NotAfter = time.Time{} },
+		} {
+			forged := *msg
+			tamper(&forged)
+			assert.False(t, forged.Verify(pub, alice(t)), name)
+		}
+	})
+
+	t.Run("wire round trip", func(t *testing.T) {
+		got, err := DecodeMessage(msg.Serialize())
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("PEM round trip", func(t *testing.T) {
+		data, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "not-after: 2024-05-06T08:08:09Z")
+		got, err := DecodeMessage(data)
+		require.NoError(t, err)
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("bound into encryption", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello bob")
+		require.NoError(t, msg.Stamp(dRand(t, 2), stampedAt, 0))
+		require.NoError(t, msg.Encrypt(dRand(t, 3), bob(t).PublicKey(), alice(t)))
+		forged := *msg
+		forged.Created = stampedAt.Add(time.Hour)
+		assert.Error(t, forged.Decrypt(bob(t)))
+		require.NoError(t, msg.Decrypt(bob(t)))
+		assert.Equal(t, "hello bob", string(msg.PlainText))
+	})
+
+	t.Run("unstamped messages are unchanged", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		assert.Nil(t, msg.metadataBinding())
+		assert.Equal(t, msg.AAD, msg.additionalData())
+	})
+}
+
+func TestMessage_CheckSignatureWith(t *testing.T) {
+	pub := alice(t).PublicKey().Signing()
+
+	t.Run("expiry", func(t *testing.T) {
+		msg := stamped(t, time.Hour)
+		assert.NoError(t, msg.CheckSignatureWith(pub, alice(t), VerifyOptions{Now: stampedAt.Add(time.Minute)}))
+		err := msg.CheckSignatureWith(pub, alice(t), VerifyOptions{Now: stampedAt.Add(2 * time.Hour)})
+		assert.ErrorIs(t, err, ErrExpired)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		msg := stamped(t, 0)
+		opts := VerifyOptions{Now: stampedAt.Add(time.Hour), MaxAge: time.Minute}
+		assert.ErrorIs(t, msg.CheckSignatureWith(pub, alice(t), opts), ErrExpired)
+		opts.MaxAge = 2 * time.Hour
+		assert.NoError(t, msg.CheckSignatureWith(pub, alice(t), opts))
+	})
+
+	t.Run("from the future", func(t *testing.T) {
+		msg := stamped(t, 0)
+		opts := VerifyOptions{Now: stampedAt.Add(-time.Minute)}
+		assert.ErrorIs(t, msg.CheckSignatureWith(pub, alice(t), opts), ErrNotYetValid)
+		opts.Skew = 2 * time.Minute
+		assert.NoError(t, msg.CheckSignatureWith(pub, alice(t), opts))
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		msg := stamped(t, time.Hour)
+		cache := NewMemoryReplayCache()
+		cache.now = func() time.Time { return stampedAt }
+		opts := VerifyOptions{Now: stampedAt, Replay: cache}
+		assert.NoError(t, msg.CheckSignatureWith(pub, alice(t), opts))
+		assert.ErrorIs(t, msg.CheckSignatureWith(pub, alice(t), opts), ErrReplay)
+
+		unstamped := NewMessage(dRand(t, 1))
+		unstamped.PlainText = []byte("hello")
+		require.NoError(t, unstamped.Sign(alice(t)))
+		assert.ErrorIs(t, unstamped.CheckSignatureWith(pub, alice(t), opts), ErrNoID)
+	})
+
+	t.Run("forgeries don't reach the cache", func(t *testing.T) {
+		cache := NewMemoryReplayCache()
+		cache.now = func() time.Time { return stampedAt }
+		msg := stamped(t, time.Hour)
+		forged := *msg
+		forged.PlainText = []byte("goodbye")
+		opts := VerifyOptions{Now: stampedAt, Replay: cache}
+		assert.ErrorIs(t, forged.CheckSignatureWith(pub, alice(t), opts), ErrBadSignature)
+		assert.NoError(t, msg.CheckSignatureWith(pub, alice(t), opts))
+	})
+}
+
+func TestMemoryReplayCache(t *testing.T) {
+	cache := NewMemoryReplayCache()
+	now := stampedAt
+	cache.now = func() time.Time { return now }
+	assert.False(t, cache.Seen([]byte("a"), now.Add(time.Minute)))
+	assert.False(t, cache.Seen([]byte("forever"), time.Time{}))
+	assert.True(t, cache.Seen([]byte("a"), now.Add(time.Minute)))
+	now = now.Add(time.Hour)
+	assert.False(t, cache.Seen([]byte("a"), now.Add(time.Minute)))
+	assert.True(t, cache.Seen([]byte("forever"), time.Time{}))
+
+	t.Run("bounded", func(t *testing.T) {
+		cache := NewMemoryReplayCache()
+		cache.now = func() time.Time { return now }
+		cache.Max = 3
+		assert.False(t, cache.Seen([]byte("forever 1"), time.Time{}))
+		assert.False(t, cache.Seen([]byte("soon"), now.Add(time.Minute)))
+		assert.False(t, cache.Seen([]byte("forever 2"), time.Time{}))
+
+		//	the ID that expires soonest goes first
+		assert.False(t, cache.Seen([]byte("forever 3"), time.Time{}))
+		assert.Len(t, cache.seen, 3)
+		assert.NotContains(t, cache.seen, "soon")
+		assert.True(t, cache.Seen([]byte("forever 1"), time.Time{}))
+
+		//	with nothing due to expire, the oldest goes
+		assert.False(t, cache.Seen([]byte("soon"), now.Add(time.Minute)))
+		assert.Len(t, cache.seen, 3)
+		assert.NotContains(t, cache.seen, "forever 1")
+		assert.True(t, cache.Seen([]byte("forever 2"), time.Time{}))
+
+		for i := range 1000 {
+			cache.Seen([]byte{byte(i), byte(i >> 8)}, time.Time{})
+		}
+		assert.Len(t, cache.seen, 3)
+		assert.Len(t, cache.queue, 3)
+	})
+
+	t.Run("expired IDs are swept", func(t *testing.T) {
+		cache := NewMemoryReplayCache()
+		cache.now = func() time.Time { return now }
+		for i := range 100 {
+			cache.Seen([]byte{byte(i)}, now.Add(time.Duration(i+1)*time.Minute))
+		}
+		cache.Seen([]byte("forever"), time.Time{})
+		now = now.Add(50*time.Minute + time.Second)
+		assert.False(t, cache.Seen([]byte("new"), now.Add(time.Hour)))
+		assert.Len(t, cache.seen, 52)
+		assert.Len(t, cache.queue, 52)
+		assert.True(t, cache.Seen([]byte{50}, now.Add(time.Hour)))
+		assert.False(t, cache.Seen([]byte{49}, now.Add(time.Hour)))
+	})
+
+	t.Run("zero value", func(t *testing.T) {
+		var cache MemoryReplayCache
+		assert.NotPanics(t, func() {
+			assert.False(t, cache.Seen([]byte("a"), time.Time{}))
+			assert.True(t, cache.Seen([]byte("a"), time.Time{}))
+		})
+	})
+}
@@ -0,0 +1,136 @@
+package message
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// A Stanza wraps a message's file key for one recipient.
+// The file key is sealed with a secret shared between an ephemeral key and the recipient's public key.
+type Stanza struct {
+	EphemeralKey []byte `json:"eph" msgpack:"eph"`
+	WrappedKey   []byte `json:"key" msgpack:"key"`
+}
+
+var ErrNoMatchingRecipient = errors.New("no matching recipient")
+
+// EncryptToMany encrypts a message once, with a random file key, and wraps that key for every recipient.
+func (msg *Message) EncryptToMany(randy io.Reader, recipients []delphi.PublicKey, e secretSealer) error {
+	if len(recipients) == 0 {
+		return errors.New("no recipients")
+	}
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := msg.makeNonce(randy, suite); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	if msg.PlainText == nil {
+		return errors.New("no plain text to encrypt")
+	}
+	for _, recipient := range recipients {
+		if err := canEncryptTo(recipient); err != nil {
+			return fmt.Errorf("could not encrypt. %w", err)
+		}
+		if err := checkRevoked(e, recipient); err != nil {
+			return fmt.Errorf("could not encrypt. %w", err)
+		}
+	}
+
+	fileKey := make([]byte, chacha20poly1305.KeySize)
+	defer delphi.Wipe(fileKey)
+	if _, err := io.ReadFull(randy, fileKey); err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+
+	//	every stanza has its own ephemeral key, and therefore its own shared secret,
+	//	so it is safe to seal them all with the message nonce.
+	stanzas := make([]Stanza, 0, len(recipients))
+	for _, recipient := range recipients {
+		sec, eph, err := e.GenerateSharedSecret(randy, recipient)
+		if err != nil {
+			return fmt.Errorf("could not encrypt. %w", err)
+		}
+		wrapped, err := msg.seal(e, suite, sec, fileKey, nil)
+		delphi.Wipe(sec)
+		if err != nil {
+			return fmt.Errorf("could not encrypt. %w", err)
+		}
+		stanzas = append(stanzas, Stanza{EphemeralKey: eph, WrappedKey: wrapped})
+	}
+
+	cipherText, err := msg.seal(e, suite, fileKey, msg.PlainText, msg.additionalData())
+	if err != nil {
+		return fmt.Errorf("could not encrypt. %w", err)
+	}
+	msg.Recipients = stanzas
+	msg.EphemeralKey = nil
+	msg.CipherText = cipherText
+	msg.PlainText = nil
+	return nil
+}
+
+// decryptMulti tries every stanza until one unwraps the file key.
+func (msg *Message) decryptMulti(recipient Decrypter) error {
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return fmt.Errorf("could not decrypt. %w", err)
+	}
+	for _, stanza := range msg.Recipients {
+		fileKey, err := msg.open(recipient, suite, stanza.WrappedKey, stanza.EphemeralKey, nil)
+		if err != nil {
+			continue
+		}
+		//	the file key is random, so every suite can use it as it is, or derive from it
+		plainText, err := suite.Open(fileKey, msg.CipherText, msg.Nonce, msg.additionalData())
+		delphi.Wipe(fileKey)
+		if err != nil {
+			return fmt.Errorf("could not decrypt. %w", err)
+		}
+		msg.PlainText = plainText
+		msg.CipherText = nil
+		msg.Recipients = nil
+		return nil
+	}
+	return fmt.Errorf("could not decrypt. %w", ErrNoMatchingRecipient)
+}
+
+// stanzasToHeader encodes stanzas as a comma-separated list of "eph:key" hex pairs.
+func stanzasToHeader(stanzas []Stanza) string {
+	parts := make([]string, 0, len(stanzas))
+	for _, s := range stanzas {
+		parts = append(parts, fmt.Sprintf("%x:%x", s.EphemeralKey, s.WrappedKey))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func stanzasFromHeader(header string) ([]Stanza, error) {
+	if header == "" {
+		return nil, nil
+	}
+	parts := strings.Split(header, ",")
+	stanzas := make([]Stanza, 0, len(parts))
+	for _, part := range parts {
+		eph, key, found := strings.Cut(strings.TrimSpace(part), ":")
+		if !found {
+			return nil, fmt.Errorf("could not decode recipient. %w. missing separator", ErrBadMessage)
+		}
+		ephBytes, err := hex.DecodeString(eph)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode recipient. %w", err)
+		}
+		keyBytes, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode recipient. %w", err)
+		}
+		stanzas = append(stanzas, Stanza{EphemeralKey: ephBytes, WrappedKey: keyBytes})
+	}
+	return stanzas, nil
+}
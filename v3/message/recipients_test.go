@@ -0,0 +1,110 @@
+package message
+
+import (
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_EncryptToMany(t *testing.T) {
+	randy := dRand(t, 7)
+	carol := delphi.NewKeyPair(dRand(t, 5))
+	dave := delphi.NewKeyPair(dRand(t, 6))
+	recipients := []delphi.PublicKey{bob(t).PublicKey(), carol.PublicKey(), dave.PublicKey()}
+
+	encrypted := func(t *testing.T) *Message {
+		t.Helper()
+		msg := NewMessage(randy)
+		msg.PlainText = []byte("for the whole team")
+		msg.AAD = []byte("team aad")
+		err := msg.EncryptToMany(randy, recipients, alice(t))
+		require.NoError(t, err)
+		return msg
+	}
+
+	t.Run("every recipient can decrypt", func(t *testing.T) {
+		for _, kp := range []*delphi.KeyPair{bob(t), &carol, &dave} {
+			msg := encrypted(t)
+			assert.Len(t, msg.Recipients, 3)
+			assert.Nil(t, msg.PlainText)
+			err := msg.Decrypt(kp)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("for the whole team"), msg.PlainText)
+		}
+	})
+
+	t.Run("non-recipient can not decrypt", func(t *testing.T) {
+		msg := encrypted(t)
+		err := msg.Decrypt(alice(t))
+		assert.ErrorIs(t, err, ErrNoMatchingRecipient)
+	})
+
+	t.Run("no recipients", func(t *testing.T) {
+		msg := NewMessage(randy)
+		msg.PlainText = []byte("for nobody")
+		err := msg.EncryptToMany(randy, nil, alice(t))
+		assert.Error(t, err)
+	})
+
+	t.Run("failing randomness", func(t *testing.T) {
+		msg := &Message{PlainText: []byte("for the whole team")}
+		err := msg.EncryptToMany(iotest.ErrReader(io.ErrUnexpectedEOF), recipients, alice(t))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("PEM round-trip", func(t *testing.T) {
+		msg := encrypted(t)
+		bin, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(bin), "recipients:")
+
+		got := new(Message)
+		require.NoError(t, got.UnmarshalPEM(bin))
+		assert.Equal(t, msg.Recipients, got.Recipients)
+		require.NoError(t, got.Decrypt(&dave))
+		assert.Equal(t, []byte("for the whole team"), got.PlainText)
+	})
+
+	t.Run("msgpack round-trip", func(t *testing.T) {
+		msg := encrypted(t)
+		got := new(Message)
+		got.Deserialize(msg.Serialize())
+		assert.Equal(t, msg.Recipients, got.Recipients)
+		require.NoError(t, got.Decrypt(&carol))
+		assert.Equal(t, []byte("for the whole team"), got.PlainText)
+	})
+
+	t.Run("signature covers stanzas", func(t *testing.T) {
+		msg := encrypted(t)
+		require.NoError(t, msg.Sign(alice(t)))
+		assert.True(t, msg.Verify(alice(t).PublicKey().Signing(), bob(t)))
+		msg.Recipients = msg.Recipients[1:]
+		assert.False(t, msg.Verify(alice(t).PublicKey().Signing(), bob(t)))
+	})
+}
+
+func TestStanzasFromHeader(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		stanzas, err := stanzasFromHeader("")
+		assert.NoError(t, err)
+		assert.Nil(t, stanzas)
+	})
+	t.Run("missing separator", func(t *testing.T) {
+		_, err := stanzasFromHeader("abcd")
+		assert.ErrorIs(t, err, ErrBadMessage)
+	})
+	t.Run("bad hex", func(t *testing.T) {
+		_, err := stanzasFromHeader("abcd:not hex")
+		assert.Error(t, err)
+	})
+	t.Run("round-trip", func(t *testing.T) {
+		in := []Stanza{{EphemeralKey: []byte{1, 2}, WrappedKey: []byte{3, 4}}, {EphemeralKey: []byte{5}, WrappedKey: []byte{6}}}
+		out, err := stanzasFromHeader(stanzasToHeader(in))
+		assert.NoError(t, err)
+		assert.Equal(t, in, out)
+	})
+}
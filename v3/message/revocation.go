@@ -0,0 +1,24 @@
+package message
+
+import (
+	"crypto"
+	"errors"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+var ErrRevoked = errors.New("key has been revoked")
+var ErrBadSignature = delphi.ErrBadSignature
+
+// A RevocationChecker knows which keys have been revoked.
+// A sealer or Verifier that is also a RevocationChecker will refuse to encrypt to, or verify signatures from, a revoked key.
+type RevocationChecker interface {
+	CheckRevoked(crypto.PublicKey) error
+}
+
+func checkRevoked(thing any, pub crypto.PublicKey) error {
+	if rc, ok := thing.(RevocationChecker); ok {
+		return rc.CheckRevoked(pub)
+	}
+	return nil
+}
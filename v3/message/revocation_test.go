@@ -0,0 +1,54 @@
+package message
+
+import (
+	"crypto"
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// revoker is a KeyPair that considers one key revoked.
+type revoker struct {
+	*delphi.KeyPair
+	revoked delphi.PublicKey
+}
+
+func (r revoker) CheckRevoked(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case delphi.PublicKey:
+		if k == r.revoked {
+			return ErrRevoked
+		}
+	case delphi.SubKey:
+		if k == r.revoked.Signing() {
+			return ErrRevoked
+		}
+	}
+	return nil
+}
+
+func TestMessage_Revocation(t *testing.T) {
+	b := bob(t)
+	r := revoker{KeyPair: alice(t), revoked: b.PublicKey()}
+
+	t.Run("encrypt", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		assert.ErrorIs(t, msg.Encrypt(dRand(t, 2), b.PublicKey(), r), ErrRevoked)
+		assert.ErrorIs(t, msg.EncryptToMany(dRand(t, 2), []delphi.PublicKey{alice(t).PublicKey(), b.PublicKey()}, r), ErrRevoked)
+		_, err := msg.EncryptWriter(nil, dRand(t, 2), b.PublicKey(), r)
+		assert.ErrorIs(t, err, ErrRevoked)
+		assert.NoError(t, msg.Encrypt(dRand(t, 2), alice(t).PublicKey(), r))
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		require.NoError(t, msg.Sign(b))
+		assert.ErrorIs(t, msg.CheckSignature(b.PublicKey().Signing(), r), ErrRevoked)
+		assert.NoError(t, msg.CheckSignature(b.PublicKey().Signing(), b))
+		assert.ErrorIs(t, msg.CheckSignature(alice(t).PublicKey().Signing(), b), ErrBadSignature)
+	})
+}
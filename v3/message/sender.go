@@ -0,0 +1,62 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// senderDomain prefixes the sender wherever it is bound into AEAD associated data or a digest,
+// so that bytes can not be shuffled between AAD and the sender field.
+const senderDomain = "oracle/sender\x00"
+
+var ErrNoSender = errors.New("no sender")
+
+// SetSender declares who a message is from. The sender is bound into the AEAD associated data and the digest,
+// so it must be set before encrypting or signing.
+func (msg *Message) SetSender(pub delphi.PublicKey) {
+	msg.Sender = pub.Bytes()
+}
+
+// SenderKey returns the declared sender. A declared sender is only a claim until the signature is verified.
+func (msg *Message) SenderKey() (delphi.PublicKey, error) {
+	var pub delphi.PublicKey
+	if len(msg.Sender) == 0 {
+		return pub, ErrNoSender
+	}
+	k, err := delphi.KeyFromBytes(msg.Sender)
+	if err != nil {
+		return pub, fmt.Errorf("%w. bad sender. %w", ErrBadMessage, err)
+	}
+	return delphi.PublicKey(k), nil
+}
+
+// senderBinding is the domain-separated sender, or nothing.
+func (msg *Message) senderBinding() []byte {
+	if len(msg.Sender) == 0 {
+		return nil
+	}
+	b := make([]byte, 0, len(senderDomain)+len(msg.Sender))
+	b = append(b, senderDomain...)
+	return append(b, msg.Sender...)
+}
+
+// additionalData is what the AEAD authenticates: the sender, the metadata and the suite, if there are any, followed by the AAD.
+func (msg *Message) additionalData() []byte {
+	if len(msg.Sender) == 0 && !msg.hasMetadata() && msg.isDefaultSuite() {
+		return msg.AAD
+	}
+	b := append(msg.senderBinding(), msg.metadataBinding()...)
+	b = append(b, msg.suiteBinding()...)
+	return append(b, msg.AAD...)
+}
+
+// VerifySender verifies the signature against the message's declared sender.
+func (msg *Message) VerifySender(v Verifier) bool {
+	pub, err := msg.SenderKey()
+	if err != nil {
+		return false
+	}
+	return msg.Verify(pub.Signing(), v)
+}
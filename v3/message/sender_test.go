@@ -0,0 +1,77 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Sender(t *testing.T) {
+	randy := dRand(t, 7)
+
+	t.Run("no sender", func(t *testing.T) {
+		msg := NewMessage(randy)
+		_, err := msg.SenderKey()
+		assert.ErrorIs(t, err, ErrNoSender)
+		assert.False(t, msg.VerifySender(bob(t)))
+	})
+
+	t.Run("bad sender", func(t *testing.T) {
+		msg := NewMessage(randy)
+		msg.Sender = []byte("not a key")
+		_, err := msg.SenderKey()
+		assert.ErrorIs(t, err, ErrBadMessage)
+	})
+
+	t.Run("sender is bound into the ciphertext", func(t *testing.T) {
+		msg := NewMessage(randy)
+		msg.PlainText = []byte("from alice")
+		msg.SetSender(alice(t).PublicKey())
+		require.NoError(t, msg.Encrypt(randy, bob(t).PublicKey(), alice(t)))
+
+		forged := *msg
+		mallory := delphi.NewKeyPair(dRand(t, 9))
+		forged.SetSender(mallory.PublicKey())
+		assert.Error(t, forged.Decrypt(bob(t)))
+
+		require.NoError(t, msg.Decrypt(bob(t)))
+		assert.Equal(t, []byte("from alice"), msg.PlainText)
+	})
+
+	t.Run("sender is bound into the digest", func(t *testing.T) {
+		msg := NewMessage(randy)
+		msg.PlainText = []byte("signed by alice")
+		withoutSender, err := msg.Digest()
+		require.NoError(t, err)
+		msg.SetSender(alice(t).PublicKey())
+		withSender, err := msg.Digest()
+		require.NoError(t, err)
+		assert.NotEqual(t, withoutSender, withSender)
+
+		require.NoError(t, msg.Sign(alice(t)))
+		assert.True(t, msg.VerifySender(bob(t)))
+
+		msg.SetSender(bob(t).PublicKey())
+		assert.False(t, msg.VerifySender(bob(t)))
+	})
+
+	t.Run("PEM round-trip", func(t *testing.T) {
+		msg := NewMessage(randy)
+		msg.PlainText = []byte("pem from alice")
+		msg.SetSender(alice(t).PublicKey())
+		require.NoError(t, msg.Encrypt(randy, bob(t).PublicKey(), alice(t)))
+		require.NoError(t, msg.Sign(alice(t)))
+
+		bin, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(bin), "sender: ")
+
+		got := new(Message)
+		require.NoError(t, got.UnmarshalPEM(bin))
+		assert.True(t, got.VerifySender(bob(t)))
+		require.NoError(t, got.Decrypt(bob(t)))
+		assert.Equal(t, []byte("pem from alice"), got.PlainText)
+	})
+}
@@ -0,0 +1,249 @@
+package message
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+/**
+ * A streamed payload is a sequence of chunks, in the style of age's STREAM construction.
+ *	- every chunk but the last holds exactly StreamChunkSize bytes of plain text
+ *	- every chunk is sealed with the AEAD of the message's suite, ChaCha20-Poly1305 by default, so it carries its own tag
+ *	- the nonce of a chunk is a big-endian counter filling all but its last byte, which is a final-chunk flag
+ *	- the payload key is derived from the shared secret and the message nonce using HKDF-SHA256, bound to the suite
+ * Because the final chunk is flagged, truncating a stream at a chunk boundary is detected.
+ **/
+
+// StreamChunkSize is the size of a plain text chunk in a streamed payload.
+const StreamChunkSize = 64 * 1024
+
+const streamInfo = "oracle/stream"
+
+var ErrStreamTruncated = errors.New("stream truncated")
+var ErrStreamClosed = errors.New("stream closed")
+
+// A SecretExtractor can recover a shared secret from an ephemeral public key.
+type SecretExtractor interface {
+	ExtractSharedSecret([]byte) ([]byte, error)
+}
+
+// streamKey derives the payload AEAD. The default suite's info is just streamInfo, as it always was.
+func (msg *Message) streamKey(suite delphi.Suite, sharedSecret []byte) (cipher.AEAD, error) {
+	info := append([]byte(streamInfo), msg.suiteBinding()...)
+	h := hkdf.New(sha256.New, sharedSecret, msg.Nonce, info)
+	key := make([]byte, chacha20poly1305.KeySize)
+	defer delphi.Wipe(key)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, err
+	}
+	return suite.NewAEAD(key)
+}
+
+// chunkNonce is a counter with a final-chunk flag in the last byte. It is as long as the AEAD's nonce.
+type chunkNonce []byte
+
+func (n chunkNonce) increment() error {
+	for i := len(n) - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("stream chunk counter overflow")
+}
+
+func (n chunkNonce) setLast(last bool) {
+	if last {
+		n[len(n)-1] = 1
+	} else {
+		n[len(n)-1] = 0
+	}
+}
+
+type streamWriter struct {
+	dst    io.Writer
+	aead   cipher.AEAD
+	nonce  chunkNonce
+	aad    []byte
+	buf    []byte
+	closed bool
+}
+
+// EncryptWriter returns a WriteCloser that encrypts everything written to it, chunk by chunk, into dst.
+// msg receives the nonce and ephemeral key and is marked as streamed, so it can act as the envelope.
+// Close must be called to write the final chunk.
+func (msg *Message) EncryptWriter(dst io.Writer, randy io.Reader, recipient delphi.PublicKey, e secretSealer) (io.WriteCloser, error) {
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := canEncryptTo(recipient); err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := checkRevoked(e, recipient); err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	if err := msg.makeNonce(randy, suite); err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	sec, eph, err := e.GenerateSharedSecret(randy, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	defer delphi.Wipe(sec)
+	aead, err := msg.streamKey(suite, sec)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt. %w", err)
+	}
+	msg.EphemeralKey = eph
+	msg.Streamed = true
+	msg.PlainText = nil
+	sw := &streamWriter{
+		dst:   dst,
+		aead:  aead,
+		nonce: make(chunkNonce, aead.NonceSize()),
+		aad:   msg.additionalData(),
+		buf:   make([]byte, 0, StreamChunkSize),
+	}
+	return sw, nil
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrStreamClosed
+	}
+	n := 0
+	for len(p) > 0 {
+		//	a full buffer is only flushed once we know more data follows, since the last chunk must be flagged
+		if len(sw.buf) == StreamChunkSize {
+			if err := sw.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):StreamChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (sw *streamWriter) flush(last bool) error {
+	sw.nonce.setLast(last)
+	out := sw.aead.Seal(nil, sw.nonce, sw.buf, sw.aad)
+	if _, err := sw.dst.Write(out); err != nil {
+		return err
+	}
+	sw.buf = sw.buf[:0]
+	return sw.nonce.increment()
+}
+
+// Close writes the final chunk. It does not close the underlying writer.
+func (sw *streamWriter) Close() error {
+	if sw.closed {
+		return ErrStreamClosed
+	}
+	sw.closed = true
+	return sw.flush(true)
+}
+
+type streamReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	nonce chunkNonce
+	aad   []byte
+	chunk []byte
+	buf   []byte
+	done  bool
+	err   error
+}
+
+// DecryptReader returns a Reader that decrypts a streamed payload read from src.
+// msg is the envelope, holding the nonce, ephemeral key and AAD.
+func (msg *Message) DecryptReader(src io.Reader, recipient SecretExtractor) (io.Reader, error) {
+	if !msg.Streamed {
+		return nil, fmt.Errorf("%w. not a streamed message", ErrBadMessage)
+	}
+	suite, err := delphi.SuiteByID(msg.Suite)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	sec, err := recipient.ExtractSharedSecret(msg.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	defer delphi.Wipe(sec)
+	aead, err := msg.streamKey(suite, sec)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt. %w", err)
+	}
+	encryptedChunkSize := StreamChunkSize + aead.Overhead()
+	sr := &streamReader{
+		src:   bufio.NewReaderSize(src, encryptedChunkSize),
+		aead:  aead,
+		nonce: make(chunkNonce, aead.NonceSize()),
+		aad:   msg.additionalData(),
+		buf:   make([]byte, encryptedChunkSize),
+	}
+	return sr, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.chunk) == 0 {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.done {
+			return 0, io.EOF
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.chunk)
+	sr.chunk = sr.chunk[n:]
+	return n, nil
+}
+
+// next reads and opens one chunk.
+func (sr *streamReader) next() error {
+	n, err := io.ReadFull(sr.src, sr.buf)
+	last := false
+	switch {
+	case errors.Is(err, io.EOF):
+		//	the previous chunk was full but not final
+		return fmt.Errorf("could not decrypt. %w", ErrStreamTruncated)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err != nil:
+		return err
+	default:
+		//	a full chunk is the final one only if nothing follows it
+		if _, err := sr.src.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+	if n < sr.aead.Overhead() {
+		return fmt.Errorf("could not decrypt. %w", ErrStreamTruncated)
+	}
+	sr.nonce.setLast(last)
+	plain, err := sr.aead.Open(sr.buf[:0], sr.nonce, sr.buf[:n], sr.aad)
+	if err != nil {
+		if last {
+			return fmt.Errorf("could not decrypt. %w. %w", ErrStreamTruncated, err)
+		}
+		return fmt.Errorf("could not decrypt. %w", err)
+	}
+	if !last && len(plain) != StreamChunkSize {
+		return fmt.Errorf("could not decrypt. %w. short chunk", ErrBadMessage)
+	}
+	sr.done = last
+	sr.chunk = plain
+	return sr.nonce.increment()
+}
@@ -0,0 +1,132 @@
+package message
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptStream streams plain text to bob and returns the envelope and the encrypted payload.
+func encryptStream(t *testing.T, plain []byte, aad []byte) (*Message, []byte) {
+	t.Helper()
+	randy := dRand(t, 7)
+	msg := NewMessage(randy)
+	msg.AAD = aad
+	buf := new(bytes.Buffer)
+	w, err := msg.EncryptWriter(buf, randy, bob(t).PublicKey(), alice(t))
+	require.NoError(t, err)
+	_, err = io.Copy(w, bytes.NewReader(plain))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return msg, buf.Bytes()
+}
+
+func TestMessage_EncryptWriter(t *testing.T) {
+	sizes := []int{0, 1, 1000, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3 * StreamChunkSize}
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		msg, ciph := encryptStream(t, plain, []byte("some aad"))
+
+		assert.True(t, msg.Streamed)
+		assert.NotEmpty(t, msg.EphemeralKey)
+
+		r, err := msg.DecryptReader(bytes.NewReader(ciph), bob(t))
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		require.NoError(t, err, "size %d", size)
+		assert.Equal(t, plain, got, "size %d", size)
+	}
+}
+
+func TestMessage_DecryptReader(t *testing.T) {
+	plain := make([]byte, 2*StreamChunkSize+100)
+	_, _ = rand.Read(plain)
+	msg, ciph := encryptStream(t, plain, nil)
+	const encryptedChunkSize = StreamChunkSize + chacha20poly1305.Overhead
+
+	decrypt := func(ciph []byte) error {
+		r, err := msg.DecryptReader(bytes.NewReader(ciph), bob(t))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	t.Run("truncated at a chunk boundary", func(t *testing.T) {
+		err := decrypt(ciph[:2*encryptedChunkSize])
+		assert.ErrorIs(t, err, ErrStreamTruncated)
+	})
+
+	t.Run("truncated mid chunk", func(t *testing.T) {
+		err := decrypt(ciph[:encryptedChunkSize+500])
+		assert.Error(t, err)
+	})
+
+	t.Run("tampered chunk", func(t *testing.T) {
+		bad := bytes.Clone(ciph)
+		bad[encryptedChunkSize+3] ^= 0xff
+		err := decrypt(bad)
+		assert.Error(t, err)
+	})
+
+	t.Run("reordered chunks", func(t *testing.T) {
+		bad := bytes.Clone(ciph)
+		copy(bad[:encryptedChunkSize], ciph[encryptedChunkSize:2*encryptedChunkSize])
+		copy(bad[encryptedChunkSize:2*encryptedChunkSize], ciph[:encryptedChunkSize])
+		err := decrypt(bad)
+		assert.Error(t, err)
+	})
+
+	t.Run("wrong recipient", func(t *testing.T) {
+		r, err := msg.DecryptReader(bytes.NewReader(ciph), alice(t))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.Error(t, err)
+	})
+
+	t.Run("not streamed", func(t *testing.T) {
+		_, err := new(Message).DecryptReader(bytes.NewReader(ciph), bob(t))
+		assert.ErrorIs(t, err, ErrBadMessage)
+	})
+}
+
+func TestMessage_StreamEnvelope(t *testing.T) {
+	plain := bytes.Repeat([]byte("all work and no play. "), 10_000)
+	msg, ciph := encryptStream(t, plain, nil)
+	msg.CipherText = ciph
+
+	t.Run("PEM", func(t *testing.T) {
+		bin, err := msg.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(bin), "stream: true")
+
+		got := new(Message)
+		require.NoError(t, got.UnmarshalPEM(bin))
+		assert.True(t, got.Streamed)
+		require.NoError(t, got.Decrypt(bob(t)))
+		assert.Equal(t, plain, got.PlainText)
+		assert.False(t, got.Streamed)
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		got := new(Message)
+		got.Deserialize(msg.Serialize())
+		assert.True(t, got.Streamed)
+		require.NoError(t, got.Decrypt(bob(t)))
+		assert.Equal(t, plain, got.PlainText)
+	})
+
+	t.Run("closed writer", func(t *testing.T) {
+		m := NewMessage(dRand(t, 7))
+		w, err := m.EncryptWriter(io.Discard, dRand(t, 7), bob(t).PublicKey(), alice(t))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		_, err = w.Write([]byte("too late"))
+		assert.ErrorIs(t, err, ErrStreamClosed)
+	})
+}
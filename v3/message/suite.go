@@ -0,0 +1,66 @@
+package message
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// suiteDomain prefixes the suite wherever it is bound into AEAD associated data or a digest.
+const suiteDomain = "oracle/suite\x00"
+
+// isDefaultSuite reports whether a message uses the suite oracle has always used.
+// Such messages look exactly as they did before suites existed.
+func (msg *Message) isDefaultSuite() bool {
+	return msg.Suite == 0 || msg.Suite == delphi.DefaultSuite
+}
+
+// suiteBinding is the domain-separated suite ID, or nothing for the default suite.
+func (msg *Message) suiteBinding() []byte {
+	if msg.isDefaultSuite() {
+		return nil
+	}
+	return append([]byte(suiteDomain), byte(msg.Suite))
+}
+
+type sealer interface {
+	Seal([]byte, []byte, []byte, []byte) ([]byte, error)
+}
+
+// makeNonce draws a nonce for the suite, unless the message already has one of the right size.
+// A nonce of the wrong size is replaced, since NewMessage doesn't know the suite.
+func (msg *Message) makeNonce(randy io.Reader, suite delphi.Suite) error {
+	if len(msg.Nonce) != 0 && (msg.isDefaultSuite() || len(msg.Nonce) == suite.NonceSize) {
+		return nil
+	}
+	msg.Nonce = make([]byte, suite.NonceSize)
+	_, err := io.ReadFull(randy, msg.Nonce)
+	return err
+}
+
+// seal seals plainText with a shared secret. The default suite goes through the sealer, as it always has.
+func (msg *Message) seal(e sealer, suite delphi.Suite, sec, plainText, aad []byte) ([]byte, error) {
+	if msg.isDefaultSuite() {
+		return e.Seal(sec, plainText, msg.Nonce, aad)
+	}
+	return suite.Seal(sec, plainText, msg.Nonce, aad)
+}
+
+// open is the inverse of seal, for cipherText sealed to recipient with the ephemeral key eph.
+// The default suite goes through recipient.Decrypt. Other suites need the shared secret itself.
+func (msg *Message) open(recipient Decrypter, suite delphi.Suite, cipherText, eph, aad []byte) ([]byte, error) {
+	if msg.isDefaultSuite() {
+		return recipient.Decrypt(cipherText, eph, msg.Nonce, aad)
+	}
+	extractor, ok := recipient.(SecretExtractor)
+	if !ok {
+		return nil, fmt.Errorf("recipient can not decrypt %s", suite.Name)
+	}
+	sec, err := extractor.ExtractSharedSecret(eph)
+	if err != nil {
+		return nil, err
+	}
+	defer delphi.Wipe(sec)
+	return suite.Open(sec, cipherText, msg.Nonce, aad)
+}
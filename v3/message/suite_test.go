@@ -0,0 +1,141 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Suite(t *testing.T) {
+	for _, id := range []delphi.SuiteID{delphi.SuiteXChaCha20Poly1305, delphi.SuiteAES256GCM} {
+		suite, err := delphi.SuiteByID(id)
+		require.NoError(t, err)
+		t.Run(suite.Name, func(t *testing.T) {
+			msg := NewMessage(dRand(t, 1))
+			msg.Suite = id
+			msg.PlainText = []byte("hello")
+			require.NoError(t, msg.Encrypt(dRand(t, 2), bob(t).PublicKey(), alice(t)))
+			assert.Len(t, msg.Nonce, suite.NonceSize)
+			require.NoError(t, msg.Sign(alice(t)))
+
+			t.Run("round trips", func(t *testing.T) {
+				got, err := DecodeMessage(msg.Serialize())
+				require.NoError(t, err)
+				assert.Equal(t, msg, got)
+				p, err := msg.MarshalPEM()
+				require.NoError(t, err)
+				got, err = DecodeMessage(p)
+				require.NoError(t, err)
+				assert.Equal(t, msg, got)
+				j, err := msg.MarshalJSON()
+				require.NoError(t, err)
+				got = new(Message)
+				require.NoError(t, got.UnmarshalJSON(j))
+				assert.Equal(t, msg, got)
+			})
+
+			t.Run("verifies and decrypts", func(t *testing.T) {
+				clone := *msg
+				assert.True(t, clone.Verify(alice(t).PublicKey().Signing(), bob(t)))
+				require.NoError(t, clone.Decrypt(bob(t)))
+				assert.Equal(t, "hello", string(clone.PlainText))
+			})
+
+			t.Run("the suite is authenticated", func(t *testing.T) {
+				clone := *msg
+				clone.Suite = delphi.DefaultSuite
+				assert.Error(t, clone.Decrypt(bob(t)))
+				assert.False(t, clone.Verify(alice(t).PublicKey().Signing(), bob(t)))
+			})
+
+			t.Run("to many recipients", func(t *testing.T) {
+				carol := delphi.NewKeyPair(dRand(t, 5))
+				msg := NewMessage(dRand(t, 1))
+				msg.Suite = id
+				msg.PlainText = []byte("hello all")
+				require.NoError(t, msg.EncryptToMany(dRand(t, 2), []delphi.PublicKey{bob(t).PublicKey(), carol.PublicKey()}, alice(t)))
+				assert.Len(t, msg.Nonce, suite.NonceSize)
+				for _, recipient := range []*delphi.KeyPair{bob(t), &carol} {
+					clone := *msg
+					require.NoError(t, clone.Decrypt(recipient))
+					assert.Equal(t, "hello all", string(clone.PlainText))
+				}
+				clone := *msg
+				clone.Suite = delphi.DefaultSuite
+				assert.Error(t, clone.Decrypt(bob(t)))
+			})
+
+			t.Run("streamed", func(t *testing.T) {
+				plain := bytes.Repeat([]byte("stream "), StreamChunkSize/3)
+				msg := NewMessage(dRand(t, 1))
+				msg.Suite = id
+				buf := new(bytes.Buffer)
+				w, err := msg.EncryptWriter(buf, dRand(t, 2), bob(t).PublicKey(), alice(t))
+				require.NoError(t, err)
+				_, err = w.Write(plain)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				r, err := msg.DecryptReader(bytes.NewReader(buf.Bytes()), bob(t))
+				require.NoError(t, err)
+				got, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, plain, got)
+
+				clone := *msg
+				clone.Suite = delphi.DefaultSuite
+				r, err = clone.DecryptReader(bytes.NewReader(buf.Bytes()), bob(t))
+				require.NoError(t, err)
+				_, err = io.ReadAll(r)
+				assert.Error(t, err)
+			})
+
+			t.Run("hybrid", func(t *testing.T) {
+				bobHybrid, err := delphi.NewHybridKeyPair(dRand(t, 3), bob(t))
+				require.NoError(t, err)
+				bobPub, err := bobHybrid.HybridPublicKey()
+				require.NoError(t, err)
+				msg := NewMessage(dRand(t, 1))
+				msg.Suite = id
+				msg.PlainText = []byte("hello, later")
+				require.NoError(t, msg.EncryptHybrid(dRand(t, 2), bobPub, alice(t)))
+				assert.Len(t, msg.Nonce, suite.NonceSize)
+				clone := *msg
+				require.NoError(t, clone.Decrypt(bobHybrid))
+				assert.Equal(t, "hello, later", string(clone.PlainText))
+				clone = *msg
+				clone.Suite = delphi.DefaultSuite
+				assert.Error(t, clone.Decrypt(bobHybrid))
+			})
+		})
+	}
+
+	t.Run("the default suite is left out", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.PlainText = []byte("hello")
+		require.NoError(t, msg.Encrypt(dRand(t, 2), bob(t).PublicKey(), alice(t)))
+		assert.Equal(t, delphi.SuiteID(0), msg.Suite)
+		assert.Equal(t, msg.AAD, msg.additionalData())
+	})
+
+	t.Run("unknown suites are refused", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.Suite = 200
+		msg.PlainText = []byte("hello")
+		assert.ErrorIs(t, msg.Encrypt(dRand(t, 2), bob(t).PublicKey(), alice(t)), delphi.ErrUnknownSuite)
+		assert.ErrorIs(t, msg.Validate(), ErrBadMessage)
+	})
+
+	t.Run("failing randomness", func(t *testing.T) {
+		msg := NewMessage(dRand(t, 1))
+		msg.Suite = delphi.SuiteXChaCha20Poly1305
+		msg.PlainText = []byte("hello")
+		err := msg.Encrypt(iotest.ErrReader(io.ErrUnexpectedEOF), bob(t).PublicKey(), alice(t))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Nil(t, msg.CipherText)
+	})
+}
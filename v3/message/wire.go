@@ -0,0 +1,222 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+/**
+ * The binary wire format of a Message is:
+ *
+ *	magic	4 bytes, "OMSG"
+ *	version	1 byte, currently 1
+ *	fields	zero or more of:
+ *		tag	1 byte
+ *		length	uvarint
+ *		value	length bytes
+ *
+ * Fields can appear in any order, but only once, except for recipient stanzas.
+ * Empty fields are left out, except for the body: an empty body is not the same as no body.
+ * A tag with the high bit set is optional: a reader that doesn't know it skips it.
+ * Any other unknown tag is an error, so that a reader never silently drops something it should have understood.
+ * A recipient stanza is itself a uvarint-length-prefixed ephemeral key, followed by the wrapped key.
+ **/
+
+const (
+	wireMagic   = "OMSG"
+	WireVersion = 1
+)
+
+type wireTag byte
+
+const (
+	tagNonce     wireTag = 1
+	tagEph       wireTag = 2
+	tagSig       wireTag = 3
+	tagAAD       wireTag = 4
+	tagPlain     wireTag = 5
+	tagCiph      wireTag = 6
+	tagSender    wireTag = 7
+	tagStream    wireTag = 8
+	tagRecipient wireTag = 9
+	tagID        wireTag = 10
+	tagCreated   wireTag = 11
+	tagNotAfter  wireTag = 12
+	tagKEM       wireTag = 13
+	tagSuite     wireTag = 14
+	tagDigest    wireTag = 15
+
+	tagOptional wireTag = 0x80
+)
+
+func appendField(b []byte, tag wireTag, value []byte) []byte {
+	b = append(b, byte(tag))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// MarshalBinary encodes a Message in the binary wire format.
+func (msg *Message) MarshalBinary() ([]byte, error) {
+	b := []byte(wireMagic)
+	b = append(b, WireVersion)
+	for _, f := range []struct {
+		tag   wireTag
+		value []byte
+	}{
+		{tagNonce, msg.Nonce},
+		{tagEph, msg.EphemeralKey},
+		{tagSig, msg.Signature},
+		{tagAAD, msg.AAD},
+		{tagSender, msg.Sender},
+		{tagID, msg.ID},
+		{tagCreated, wireTime(msg.Created)},
+		{tagNotAfter, wireTime(msg.NotAfter)},
+		{tagKEM, msg.KEMCipher},
+	} {
+		if len(f.value) > 0 {
+			b = appendField(b, f.tag, f.value)
+		}
+	}
+	if msg.PlainText != nil {
+		b = appendField(b, tagPlain, msg.PlainText)
+	}
+	if msg.CipherText != nil {
+		b = appendField(b, tagCiph, msg.CipherText)
+	}
+	if msg.Streamed {
+		b = appendField(b, tagStream, []byte{1})
+	}
+	if msg.Suite != 0 {
+		b = appendField(b, tagSuite, []byte{byte(msg.Suite)})
+	}
+	if msg.DigestVersion != 0 {
+		b = appendField(b, tagDigest, []byte{byte(msg.DigestVersion)})
+	}
+	for _, s := range msg.Recipients {
+		stanza := binary.AppendUvarint(nil, uint64(len(s.EphemeralKey)))
+		stanza = append(stanza, s.EphemeralKey...)
+		stanza = append(stanza, s.WrappedKey...)
+		b = appendField(b, tagRecipient, stanza)
+	}
+	return b, nil
+}
+
+// IsWireFormat reports whether data looks like a Message in the binary wire format.
+func IsWireFormat(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(wireMagic))
+}
+
+// UnmarshalBinary decodes a Message from the binary wire format. It never panics on bad input.
+func (msg *Message) UnmarshalBinary(data []byte) error {
+	if !IsWireFormat(data) {
+		return fmt.Errorf("%w. missing magic", ErrBadMessage)
+	}
+	data = data[len(wireMagic):]
+	if len(data) == 0 {
+		return fmt.Errorf("%w. missing version", ErrBadMessage)
+	}
+	if data[0] != WireVersion {
+		return fmt.Errorf("%w. unsupported version %d", ErrBadMessage, data[0])
+	}
+	data = data[1:]
+
+	m := Message{}
+	seen := map[wireTag]bool{}
+	for len(data) > 0 {
+		tag := wireTag(data[0])
+		length, n := binary.Uvarint(data[1:])
+		if n <= 0 {
+			return fmt.Errorf("%w. bad length for tag %d", ErrBadMessage, tag)
+		}
+		data = data[1+n:]
+		if length > uint64(len(data)) {
+			return fmt.Errorf("%w. tag %d is %d bytes, but only %d remain", ErrBadMessage, tag, length, len(data))
+		}
+		var value []byte
+		if length > 0 {
+			value = bytes.Clone(data[:length])
+		}
+		data = data[length:]
+
+		if tag != tagRecipient && seen[tag] {
+			return fmt.Errorf("%w. duplicate tag %d", ErrBadMessage, tag)
+		}
+		seen[tag] = true
+
+		switch tag {
+		case tagNonce:
+			m.Nonce = value
+		case tagEph:
+			m.EphemeralKey = value
+		case tagSig:
+			m.Signature = value
+		case tagAAD:
+			m.AAD = value
+		case tagSender:
+			m.Sender = value
+		case tagPlain:
+			m.PlainText = append([]byte{}, value...)
+		case tagCiph:
+			m.CipherText = append([]byte{}, value...)
+		case tagID:
+			m.ID = value
+		case tagKEM:
+			m.KEMCipher = value
+		case tagSuite:
+			if len(value) != 1 || value[0] == 0 {
+				return fmt.Errorf("%w. bad suite", ErrBadMessage)
+			}
+			m.Suite = delphi.SuiteID(value[0])
+		case tagDigest:
+			if len(value) != 1 || value[0] == 0 {
+				return fmt.Errorf("%w. bad digest version", ErrBadMessage)
+			}
+			m.DigestVersion = DigestVersion(value[0])
+		case tagCreated, tagNotAfter:
+			if len(value) != 8 {
+				return fmt.Errorf("%w. tag %d should be 8 bytes", ErrBadMessage, tag)
+			}
+			t := fromUnixTime(int64(binary.BigEndian.Uint64(value)))
+			if tag == tagCreated {
+				m.Created = t
+			} else {
+				m.NotAfter = t
+			}
+		case tagStream:
+			m.Streamed = len(value) == 1 && value[0] == 1
+		case tagRecipient:
+			stanza, err := stanzaFromWire(value)
+			if err != nil {
+				return err
+			}
+			m.Recipients = append(m.Recipients, stanza)
+		default:
+			if tag&tagOptional == 0 {
+				return fmt.Errorf("%w. unknown tag %d", ErrBadMessage, tag)
+			}
+		}
+	}
+	*msg = m
+	return nil
+}
+
+func stanzaFromWire(value []byte) (Stanza, error) {
+	length, n := binary.Uvarint(value)
+	if n <= 0 || length > uint64(len(value)-n) {
+		return Stanza{}, fmt.Errorf("%w. bad recipient stanza", ErrBadMessage)
+	}
+	value = value[n:]
+	return Stanza{EphemeralKey: value[:length], WrappedKey: value[length:]}, nil
+}
+
+// wireTime is a time as 8 bytes of seconds since the epoch, or nothing for the zero Time.
+func wireTime(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(t.Unix()))
+}
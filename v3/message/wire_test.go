@@ -0,0 +1,107 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func wireMessage() *Message {
+	return &Message{
+		CipherText:   []byte("cipher text"),
+		AAD:          []byte("meta"),
+		Nonce:        []byte("123456789012"),
+		EphemeralKey: []byte("ephkey"),
+		Signature:    []byte("siggy"),
+		Sender:       []byte("sender"),
+		Streamed:     true,
+		Recipients: []Stanza{
+			{EphemeralKey: []byte("eph one"), WrappedKey: []byte("key one")},
+			{EphemeralKey: []byte("eph two"), WrappedKey: []byte("key two")},
+		},
+	}
+}
+
+func TestMessage_MarshalBinary(t *testing.T) {
+
+	t.Run("round trip", func(t *testing.T) {
+		msg := wireMessage()
+		bin, err := msg.MarshalBinary()
+		require.NoError(t, err)
+		assert.True(t, IsWireFormat(bin))
+		assert.Equal(t, byte(WireVersion), bin[len(wireMagic)])
+		got := new(Message)
+		require.NoError(t, got.UnmarshalBinary(bin))
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("empty message", func(t *testing.T) {
+		bin, err := new(Message).MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, []byte("OMSG\x01"), bin)
+		got := new(Message)
+		require.NoError(t, got.UnmarshalBinary(bin))
+		assert.Equal(t, new(Message), got)
+	})
+
+	t.Run("legacy msgpack is still readable", func(t *testing.T) {
+		msg := wireMessage()
+		legacy, err := msgpack.Marshal((*legacyMessage)(msg))
+		require.NoError(t, err)
+		assert.False(t, IsWireFormat(legacy))
+		got := new(Message)
+		got.Deserialize(legacy)
+		assert.Equal(t, msg, got)
+	})
+
+	t.Run("optional tags are skipped", func(t *testing.T) {
+		bin := appendField([]byte("OMSG\x01"), tagOptional|42, []byte("from the future"))
+		bin = appendField(bin, tagPlain, []byte("hello"))
+		got := new(Message)
+		require.NoError(t, got.UnmarshalBinary(bin))
+		assert.Equal(t, []byte("hello"), got.PlainText)
+	})
+
+	bad := map[string][]byte{
+		"no magic":            []byte("this is not a message"),
+		"no version":          []byte("OMSG"),
+		"future version":      []byte("OMSG\x02"),
+		"unknown tag":         appendField([]byte("OMSG\x01"), 42, []byte("?")),
+		"duplicate tag":       appendField(appendField([]byte("OMSG\x01"), tagNonce, []byte("a")), tagNonce, []byte("b")),
+		"truncated value":     []byte("OMSG\x01\x05\x09hello"),
+		"truncated length":    []byte("OMSG\x01\x05\xff"),
+		"bad recipient":       appendField([]byte("OMSG\x01"), tagRecipient, []byte{0x09, 'x'}),
+		"truncated recipient": appendField([]byte("OMSG\x01"), tagRecipient, nil),
+	}
+	for name, bin := range bad {
+		t.Run(name, func(t *testing.T) {
+			msg := &Message{PlainText: []byte("untouched")}
+			assert.ErrorIs(t, msg.UnmarshalBinary(bin), ErrBadMessage)
+			assert.Equal(t, []byte("untouched"), msg.PlainText)
+		})
+	}
+}
+
+// FuzzMessage_UnmarshalBinary checks that decoding never panics, and that whatever decodes re-encodes to the same Message.
+// The seed corpus lives in testdata/fuzz/FuzzMessage_UnmarshalBinary.
+func FuzzMessage_UnmarshalBinary(f *testing.F) {
+	good, _ := wireMessage().MarshalBinary()
+	f.Add(good)
+	f.Add([]byte("OMSG\x01"))
+	f.Add([]byte("OMSG\x01\x05\x09hello"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := new(Message)
+		if err := msg.UnmarshalBinary(data); err != nil {
+			return
+		}
+		again, err := msg.MarshalBinary()
+		require.NoError(t, err)
+		msg2 := new(Message)
+		require.NoError(t, msg2.UnmarshalBinary(again))
+		assert.Equal(t, msg, msg2)
+		assert.True(t, bytes.HasPrefix(again, []byte(wireMagic)))
+	})
+}
@@ -0,0 +1,416 @@
+package oracle
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	pemPrivateKey                = "ORACLE PRIVATE KEY"
+	pemEncryptedPrivateKey       = "ORACLE ENCRYPTED PRIVATE KEY"
+	pemEncryptedHybridPrivateKey = "ORACLE ENCRYPTED HYBRID PRIVATE KEY"
+)
+
+var ErrPassphraseRequired = errors.New("passphrase required")
+var ErrWrongPassphrase = errors.New("wrong passphrase")
+var ErrBadKDF = errors.New("bad kdf parameters")
+
+// A PassphraseFunc is called when a passphrase is needed, such as when prompting a user.
+type PassphraseFunc func() ([]byte, error)
+
+// kdfParams are the scrypt parameters used to turn a passphrase into a key.
+type kdfParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+var defaultKDF = kdfParams{N: 1 << 15, R: 8, P: 1}
+
+// Bounds on kdfParams. They come from the key file, so they must not be allowed to eat all the memory or CPU
+// before the passphrase has even been checked. scrypt needs 128·N·r bytes of memory.
+const (
+	maxKDFN      = 1 << 20
+	maxKDFR      = 32
+	maxKDFP      = 16
+	maxKDFMemory = 1 << 30
+)
+
+// validate checks that N is a power of two, and that nothing is out of bounds.
+func (k kdfParams) validate() error {
+	switch {
+	case k.N < 2 || k.N > maxKDFN || k.N&(k.N-1) != 0:
+		return fmt.Errorf("%w. N must be a power of two no greater than %d, not %d", ErrBadKDF, maxKDFN, k.N)
+	case k.R < 1 || k.R > maxKDFR:
+		return fmt.Errorf("%w. r must be between 1 and %d, not %d", ErrBadKDF, maxKDFR, k.R)
+	case k.P < 1 || k.P > maxKDFP:
+		return fmt.Errorf("%w. p must be between 1 and %d, not %d", ErrBadKDF, maxKDFP, k.P)
+	case 128*k.N*k.R > maxKDFMemory:
+		return fmt.Errorf("%w. N and r need more than %d bytes", ErrBadKDF, maxKDFMemory)
+	}
+	return nil
+}
+
+func (k kdfParams) String() string {
+	return fmt.Sprintf("scrypt:%d:%d:%d", k.N, k.R, k.P)
+}
+
+// sealedKeyPair is a KeyPair, or some other private key, encrypted under a passphrase.
+type sealedKeyPair struct {
+	KDF        string    `json:"kdf"`
+	Params     kdfParams `json:"params"`
+	Salt       hexBytes  `json:"salt"`
+	Nonce      hexBytes  `json:"nonce"`
+	CipherText hexBytes  `json:"ciph"`
+}
+
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *hexBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	bin, err := hex.DecodeString(s)
+	*h = bin
+	return err
+}
+
+func sealKeyPair(randy io.Reader, kp *delphi.KeyPair, passphrase []byte, params kdfParams) (*sealedKeyPair, error) {
+	plainText := kp.Bytes()
+	defer delphi.Wipe(plainText)
+	return seal(randy, plainText, passphrase, params)
+}
+
+// seal encrypts plainText under a key derived from passphrase. The caller wipes plainText.
+func seal(randy io.Reader, plainText, passphrase []byte, params kdfParams) (*sealedKeyPair, error) {
+	s := &sealedKeyPair{
+		KDF:    "scrypt",
+		Params: params,
+		Salt:   make([]byte, 16),
+		Nonce:  make([]byte, chacha20poly1305.NonceSize),
+	}
+	if _, err := io.ReadFull(randy, s.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(randy, s.Nonce); err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	s.CipherText = aead.Seal(nil, s.Nonce, plainText, []byte(params.String()))
+	return s, nil
+}
+
+// validate is called before asking for a passphrase, so a hostile key file fails fast.
+func (s *sealedKeyPair) validate() error {
+	if s.KDF != "scrypt" {
+		return fmt.Errorf("unsupported kdf %q", s.KDF)
+	}
+	return s.Params.validate()
+}
+
+func (s *sealedKeyPair) aead(passphrase []byte) (cipher.AEAD, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, s.Salt, s.Params.N, s.Params.R, s.Params.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	defer delphi.Wipe(key)
+	return chacha20poly1305.New(key)
+}
+
+func (s *sealedKeyPair) open(passphrase []byte) (delphi.KeyPair, error) {
+	bin, err := s.openBytes(passphrase)
+	if err != nil {
+		return delphi.ZeroKeyPair, err
+	}
+	defer delphi.Wipe(bin)
+	return delphi.KeyPairFromBytes(bin)
+}
+
+// openBytes is the inverse of seal. The caller wipes what it returns.
+func (s *sealedKeyPair) openBytes(passphrase []byte) ([]byte, error) {
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	bin, err := aead.Open(nil, s.Nonce, s.CipherText, []byte(s.Params.String()))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return bin, nil
+}
+
+func (s *sealedKeyPair) headers() map[string]string {
+	return map[string]string{
+		"kdf":   s.KDF,
+		"kdf-n": strconv.Itoa(s.Params.N),
+		"kdf-r": strconv.Itoa(s.Params.R),
+		"kdf-p": strconv.Itoa(s.Params.P),
+		"salt":  hex.EncodeToString(s.Salt),
+		"nonce": hex.EncodeToString(s.Nonce),
+	}
+}
+
+// sealedFromHeaders reads KDF parameters out of PEM headers, removing them as it goes.
+func sealedFromHeaders(headers map[string]string, cipherText []byte) (*sealedKeyPair, error) {
+	s := &sealedKeyPair{KDF: headers["kdf"], CipherText: cipherText}
+	var err error
+	for name, ptr := range map[string]*int{"kdf-n": &s.Params.N, "kdf-r": &s.Params.R, "kdf-p": &s.Params.P} {
+		if *ptr, err = strconv.Atoi(headers[name]); err != nil {
+			return nil, fmt.Errorf("could not decode %s. %w", name, err)
+		}
+	}
+	if s.Salt, err = hex.DecodeString(headers["salt"]); err != nil {
+		return nil, fmt.Errorf("could not decode salt. %w", err)
+	}
+	if s.Nonce, err = hex.DecodeString(headers["nonce"]); err != nil {
+		return nil, fmt.Errorf("could not decode nonce. %w", err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	for name := range s.headers() {
+		delete(headers, name)
+	}
+	return s, nil
+}
+
+// MarshalEncryptedPEM is like MarshalPEM, but the KeyPair is encrypted under a passphrase.
+func (pr *Principal) MarshalEncryptedPEM(randy io.Reader, passphrase []byte) ([]byte, error) {
+	sealed, err := sealKeyPair(randy, &pr.KeyPair, passphrase, defaultKDF)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt private key. %w", err)
+	}
+	pr.expound()
+	//	KDF headers take precedence over any Props with the same name
+	headers := make(map[string]string, len(pr.Props))
+	for k, v := range pr.Props {
+		headers[k] = v
+	}
+	for k, v := range sealed.headers() {
+		headers[k] = v
+	}
+	block := &pem.Block{
+		Type:    pemEncryptedPrivateKey,
+		Headers: headers,
+		Bytes:   sealed.CipherText,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEMWithPassphrase reads either a plain or an encrypted private key.
+// getPass is only called if the key is encrypted.
+func (pr *Principal) UnmarshalPEMWithPassphrase(data []byte, getPass PassphraseFunc) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type == pemPrivateKey {
+		return pr.UnmarshalPEM(data)
+	}
+	if block.Type != pemEncryptedPrivateKey {
+		return errors.New("wrong PEM type: " + block.Type)
+	}
+	sealed, err := sealedFromHeaders(block.Headers, block.Bytes)
+	if err != nil {
+		return err
+	}
+	passphrase, err := getPass()
+	if err != nil {
+		return err
+	}
+	bin, err := sealed.openBytes(passphrase)
+	if err != nil {
+		return err
+	}
+	defer delphi.Wipe(bin)
+	return pr.fromPEM(bin, block.Headers)
+}
+
+// MarshalEncryptedHybridPEM is like HybridKeyPair.MarshalPEM, but the KeyPair and the ML-KEM seed are encrypted under a passphrase.
+func MarshalEncryptedHybridPEM(randy io.Reader, h *delphi.HybridKeyPair, passphrase []byte) ([]byte, error) {
+	bin, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer delphi.Wipe(bin)
+	sealed, err := seal(randy, bin, passphrase, defaultKDF)
+	if err != nil {
+		return nil, fmt.Errorf("could not encrypt private key. %w", err)
+	}
+	block := &pem.Block{
+		Type:    pemEncryptedHybridPrivateKey,
+		Headers: sealed.headers(),
+		Bytes:   sealed.CipherText,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalHybridPEMWithPassphrase reads either a plain or an encrypted hybrid private key.
+// getPass is only called if the key is encrypted.
+func UnmarshalHybridPEMWithPassphrase(data []byte, getPass PassphraseFunc) (*delphi.HybridKeyPair, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("PEM decode failed")
+	}
+	h := new(delphi.HybridKeyPair)
+	if block.Type == delphi.PEMHybridPrivateKey {
+		if err := h.UnmarshalPEM(data); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	if block.Type != pemEncryptedHybridPrivateKey {
+		return nil, errors.New("wrong PEM type: " + block.Type)
+	}
+	sealed, err := sealedFromHeaders(block.Headers, block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := getPass()
+	if err != nil {
+		return nil, err
+	}
+	bin, err := sealed.openBytes(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer delphi.Wipe(bin)
+	if err := h.UnmarshalBinary(bin); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// encryptedPrincipal is the JSON form of a Principal whose KeyPairs are encrypted.
+type encryptedPrincipal struct {
+	Props        Props              `json:"Props"`
+	Sealed       *sealedKeyPair     `json:"sealed_keypair"`
+	Peers        PeerStore          `json:"peers"`
+	Retired      []sealedRetiredKey `json:"retired,omitempty"`
+	Endorsements EndorsementStore   `json:"endorsements,omitempty"`
+	Derivations  DerivationStore    `json:"derivations,omitempty"`
+	Verity       *Verity            `json:"verity"`
+}
+
+// sealedRetiredKey is a RetiredKey whose KeyPair is encrypted.
+type sealedRetiredKey struct {
+	Sealed *sealedKeyPair `json:"sealed_keypair"`
+	Until  time.Time      `json:"until"`
+}
+
+// SaveEncryptedJSON is like SaveJSON, but the KeyPair is encrypted under a passphrase.
+func (pr *Principal) SaveEncryptedJSON(w io.Writer, randy io.Reader, passphrase []byte) error {
+	pr.MustBeValid()
+	sealed, err := sealKeyPair(randy, &pr.KeyPair, passphrase, defaultKDF)
+	if err != nil {
+		return fmt.Errorf("could not encrypt private key. %w", err)
+	}
+	ep := encryptedPrincipal{Props: pr.Props, Sealed: sealed, Peers: pr.Peers, Endorsements: pr.Endorsements, Derivations: pr.Derivations}
+	for i := range pr.Retired {
+		r := &pr.Retired[i]
+		sealed, err := sealKeyPair(randy, &r.KeyPair, passphrase, defaultKDF)
+		if err != nil {
+			return fmt.Errorf("could not encrypt retired key. %w", err)
+		}
+		ep.Retired = append(ep.Retired, sealedRetiredKey{Sealed: sealed, Until: r.Until})
+	}
+	pr.expound()
+	ep.Verity, err = pr.SignConfig(randy)
+	if err != nil {
+		return err
+	}
+	pr.unsigned = false
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(ep)
+}
+
+// LoadJSONWithPassphrase reads either a plain or an encrypted JSON config.
+// getPass is only called if the KeyPair is encrypted. Like LoadJSON, the config must carry a Verity.
+func LoadJSONWithPassphrase(r io.Reader, getPass PassphraseFunc) (*Principal, error) {
+	return loadJSONWithPassphrase(r, getPass, false)
+}
+
+// LoadUnsignedJSONWithPassphrase is like LoadJSONWithPassphrase, but also accepts a config with no Verity.
+// See LoadUnsignedJSON.
+func LoadUnsignedJSONWithPassphrase(r io.Reader, getPass PassphraseFunc) (*Principal, error) {
+	return loadJSONWithPassphrase(r, getPass, true)
+}
+
+func loadJSONWithPassphrase(r io.Reader, getPass PassphraseFunc, allowUnsigned bool) (*Principal, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ep := encryptedPrincipal{Peers: make(PeerStore)}
+	if err := json.Unmarshal(data, &ep); err != nil {
+		return nil, err
+	}
+	if ep.Sealed == nil {
+		return loadJSON(bytes.NewReader(data), allowUnsigned)
+	}
+	if err := ep.Sealed.validate(); err != nil {
+		return nil, err
+	}
+	for _, r := range ep.Retired {
+		if r.Sealed != nil {
+			if err := r.Sealed.validate(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	passphrase, err := getPass()
+	if err != nil {
+		return nil, err
+	}
+	kp, err := ep.Sealed.open(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	p := &Principal{KeyPair: kp, Props: ep.Props, Peers: ep.Peers, Endorsements: ep.Endorsements, Derivations: ep.Derivations}
+	for _, r := range ep.Retired {
+		if r.Sealed == nil {
+			return nil, errors.New("retired key is missing its sealed keypair")
+		}
+		kp, err := r.Sealed.open(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt retired key. %w", err)
+		}
+		p.Retired = append(p.Retired, RetiredKey{KeyPair: kp, Until: r.Until})
+	}
+	if p.Props == nil {
+		p.Props = make(Props)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	p.condense()
+	if ep.Verity == nil && allowUnsigned {
+		p.unsigned = true
+		return p, nil
+	}
+	return p, p.VerifyConfig(ep.Verity)
+}
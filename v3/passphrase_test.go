@@ -0,0 +1,227 @@
+package oracle
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"maps"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func passphrase(s string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return []byte(s), nil
+	}
+}
+
+func TestPrincipal_MarshalEncryptedPEM(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	alice.Props["favourite colour"] = "blue"
+	bin, err := alice.MarshalEncryptedPEM(fakeRand(2), []byte("correct horse"))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(bin), "ORACLE ENCRYPTED PRIVATE KEY")
+	assert.Contains(t, string(bin), "kdf: scrypt")
+	assert.Contains(t, string(bin), "kdf-n: 32768")
+	assert.False(t, bytes.Contains(bin, alice.KeyPair.Bytes()))
+
+	t.Run("right passphrase", func(t *testing.T) {
+		got := new(Principal)
+		err := got.UnmarshalPEMWithPassphrase(bin, passphrase("correct horse"))
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair, got.KeyPair)
+		assert.Equal(t, "blue", got.Props["favourite colour"])
+		assert.Empty(t, got.Props["salt"])
+		assert.Empty(t, got.Props["nick"])
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		got := new(Principal)
+		err := got.UnmarshalPEMWithPassphrase(bin, passphrase("battery staple"))
+		assert.ErrorIs(t, err, ErrWrongPassphrase)
+	})
+
+	t.Run("callback fails", func(t *testing.T) {
+		boom := errors.New("no tty")
+		got := new(Principal)
+		err := got.UnmarshalPEMWithPassphrase(bin, func() ([]byte, error) { return nil, boom })
+		assert.ErrorIs(t, err, boom)
+	})
+
+	t.Run("plain UnmarshalPEM refuses", func(t *testing.T) {
+		got := new(Principal)
+		err := got.UnmarshalPEM(bin)
+		assert.ErrorIs(t, err, ErrPassphraseRequired)
+	})
+
+	t.Run("plain keys need no passphrase", func(t *testing.T) {
+		plain, err := alice.MarshalPEM()
+		require.NoError(t, err)
+		got := new(Principal)
+		err = got.UnmarshalPEMWithPassphrase(plain, func() ([]byte, error) {
+			t.Fatal("passphrase should not be asked for")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair, got.KeyPair)
+	})
+
+	t.Run("tampered KDF params", func(t *testing.T) {
+		tampered := bytes.Replace(bin, []byte("kdf-p: 1"), []byte("kdf-p: 2"), 1)
+		got := new(Principal)
+		err := got.UnmarshalPEMWithPassphrase(tampered, passphrase("correct horse"))
+		assert.ErrorIs(t, err, ErrWrongPassphrase)
+	})
+
+	t.Run("hostile KDF params", func(t *testing.T) {
+		for _, hostile := range []string{
+			"kdf-n: 4294967296",
+			"kdf-n: 1048577",
+			"kdf-n: 0",
+			"kdf-n: -32768",
+			"kdf-r: 1024",
+			"kdf-r: 0",
+			"kdf-p: 1073741823",
+		} {
+			name, _, _ := strings.Cut(hostile, ":")
+			tampered := regexp.MustCompile(name+`: \d+`).ReplaceAll(bin, []byte(hostile))
+			require.Contains(t, string(tampered), hostile)
+			got := new(Principal)
+			err := got.UnmarshalPEMWithPassphrase(tampered, func() ([]byte, error) {
+				t.Fatal("passphrase should not be asked for")
+				return nil, nil
+			})
+			assert.ErrorIs(t, err, ErrBadKDF, hostile)
+		}
+	})
+
+	//	both paths must check the key, and that the headers agree with it
+	sealedPEM := func(t *testing.T, keyPair []byte, headers map[string]string) []byte {
+		t.Helper()
+		sealed, err := seal(fakeRand(2), keyPair, []byte("correct horse"), defaultKDF)
+		require.NoError(t, err)
+		h := sealed.headers()
+		maps.Copy(h, headers)
+		return pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivateKey, Headers: h, Bytes: sealed.CipherText})
+	}
+	plainPEM := func(keyPair []byte, headers map[string]string) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Headers: headers, Bytes: keyPair})
+	}
+
+	t.Run("malformed key", func(t *testing.T) {
+		//	a public key that doesn't belong to the private key
+		mismatched := alice.KeyPair.Bytes()
+		mismatched[0] ^= 0xff
+		for name, data := range map[string][]byte{
+			"sealed": sealedPEM(t, mismatched, nil),
+			"plain":  plainPEM(mismatched, nil),
+		} {
+			err := new(Principal).UnmarshalPEMWithPassphrase(data, passphrase("correct horse"))
+			assert.Error(t, err, name)
+		}
+		err := new(Principal).UnmarshalPEMWithPassphrase(sealedPEM(t, alice.KeyPair.Bytes()[:100], nil), passphrase("correct horse"))
+		assert.ErrorIs(t, err, delphi.ErrWrongSize)
+	})
+
+	t.Run("false capabilities claim", func(t *testing.T) {
+		claim := map[string]string{"capabilities": "encrypt"}
+		for name, data := range map[string][]byte{
+			"sealed": sealedPEM(t, alice.KeyPair.Bytes(), claim),
+			"plain":  plainPEM(alice.KeyPair.Bytes(), claim),
+		} {
+			err := new(Principal).UnmarshalPEMWithPassphrase(data, passphrase("correct horse"))
+			assert.ErrorIs(t, err, delphi.ErrCapability, name)
+		}
+	})
+}
+
+func TestMarshalEncryptedHybridPEM(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	hybrid, err := delphi.NewHybridKeyPair(fakeRand(3), &alice.KeyPair)
+	require.NoError(t, err)
+	plain, err := hybrid.MarshalPEM()
+	require.NoError(t, err)
+	bin, err := MarshalEncryptedHybridPEM(fakeRand(2), hybrid, []byte("correct horse"))
+	require.NoError(t, err)
+
+	assert.Contains(t, string(bin), "ORACLE ENCRYPTED HYBRID PRIVATE KEY")
+	assert.Contains(t, string(bin), "kdf: scrypt")
+	secret, err := hybrid.MarshalBinary()
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(bin, secret[len(secret)-16:]))
+	assert.False(t, bytes.Contains(bin, alice.KeyPair.Bytes()))
+
+	t.Run("right passphrase", func(t *testing.T) {
+		got, err := UnmarshalHybridPEMWithPassphrase(bin, passphrase("correct horse"))
+		require.NoError(t, err)
+		assert.Equal(t, hybrid, got)
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := UnmarshalHybridPEMWithPassphrase(bin, passphrase("battery staple"))
+		assert.ErrorIs(t, err, ErrWrongPassphrase)
+	})
+
+	t.Run("plain keys need no passphrase", func(t *testing.T) {
+		got, err := UnmarshalHybridPEMWithPassphrase(plain, func() ([]byte, error) {
+			t.Fatal("asked for a passphrase")
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, hybrid, got)
+	})
+
+	t.Run("plain UnmarshalPEM refuses", func(t *testing.T) {
+		assert.ErrorIs(t, new(delphi.HybridKeyPair).UnmarshalPEM(bin), delphi.ErrNotHybrid)
+	})
+
+	t.Run("not a hybrid key", func(t *testing.T) {
+		sealed, err := alice.MarshalEncryptedPEM(fakeRand(2), []byte("correct horse"))
+		require.NoError(t, err)
+		_, err = UnmarshalHybridPEMWithPassphrase(sealed, passphrase("correct horse"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPrincipal_SaveEncryptedJSON(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	alice.AddPeer(NewPrincipal(fakeRand(3)).AsPeer())
+	buf := new(bytes.Buffer)
+	err := alice.SaveEncryptedJSON(buf, fakeRand(2), []byte("correct horse"))
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), alice.KeyPair.String())
+	assert.Contains(t, buf.String(), "sealed_keypair")
+
+	t.Run("right passphrase", func(t *testing.T) {
+		got, err := LoadJSONWithPassphrase(bytes.NewReader(buf.Bytes()), passphrase("correct horse"))
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair, got.KeyPair)
+		assert.Len(t, got.Peers, 1)
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		_, err := LoadJSONWithPassphrase(bytes.NewReader(buf.Bytes()), passphrase("nope"))
+		assert.ErrorIs(t, err, ErrWrongPassphrase)
+	})
+
+	t.Run("plain configs need no passphrase", func(t *testing.T) {
+		plain := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(plain))
+		got, err := LoadJSONWithPassphrase(plain, nil)
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair, got.KeyPair)
+	})
+
+	t.Run("hostile KDF params", func(t *testing.T) {
+		tampered := strings.Replace(buf.String(), `"n": 32768`, `"n": 1073741824`, 1)
+		require.NotEqual(t, buf.String(), tampered)
+		_, err := LoadJSONWithPassphrase(strings.NewReader(tampered), passphrase("correct horse"))
+		assert.ErrorIs(t, err, ErrBadKDF)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"github.com/sean9999/go-oracle/v3/delphi"
 	"io"
 )
@@ -13,12 +14,27 @@ type Peer struct {
 	PublicKey delphi.PublicKey  `json:"pubkey"`
 }
 
+// NickName is a friendly label. It is not unique. Use Fingerprint for that.
 func (p *Peer) NickName() string {
 	return p.PublicKey.Nickname()
 }
 
+func (p *Peer) Fingerprint() delphi.Fingerprint {
+	return p.PublicKey.Fingerprint()
+}
+
+// Validate returns an error if the Peer has no key, or wants a suite we don't know.
+func (p *Peer) Validate() error {
+	if err := delphi.Key(p.PublicKey).Validate(); err != nil {
+		return err
+	}
+	return checkSuite(p.Props)
+}
+
 func (p *Peer) Save(w io.Writer) error {
-	delphi.Key(p.PublicKey).MustBeValid()
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("could not save peer. %w", err)
+	}
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "\t")
 	return enc.Encode(p)
@@ -44,6 +60,12 @@ func PeerFromPem(p pem.Block) (*Peer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := checkCapabilities(p.Headers, delphi.PublicKey(kb).Capabilities()); err != nil {
+		return nil, err
+	}
+	if err := checkSuite(p.Headers); err != nil {
+		return nil, err
+	}
 	return &Peer{
 		PublicKey: delphi.PublicKey(kb),
 		Props:     p.Headers,
@@ -62,6 +84,12 @@ func (p *Peer) UnmarshalPEM(data []byte) error {
 	if err != nil {
 		return err
 	}
+	if err := checkCapabilities(block.Headers, p.Capabilities()); err != nil {
+		return err
+	}
+	if err := checkSuite(block.Headers); err != nil {
+		return err
+	}
 	p.Props = block.Headers
 	p.condense()
 	return nil
@@ -70,12 +98,15 @@ func (p *Peer) UnmarshalPEM(data []byte) error {
 // expound adds derived values to Props.
 // Good for situations where you want maximum context.
 func (p *Peer) expound() {
-	nick := p.NickName()
-	p.Props["nick"] = nick
+	p.Props["nick"] = p.NickName()
+	p.Props["fingerprint"] = p.Fingerprint().String()
+	expoundCapabilities(p.Props, p.Capabilities())
 }
 
 // condense removes derived values from Props.
 // Good for brevity and avoiding confusion about which values are derived and which are not.
 func (p *Peer) condense() {
-	delete(p.Props, "nick")
+	for _, k := range derivedProps {
+		delete(p.Props, k)
+	}
 }
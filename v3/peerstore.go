@@ -2,11 +2,16 @@ package oracle
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/sean9999/go-oracle/v3/delphi"
 )
 
 type PeerStore map[delphi.PublicKey]Props
 
+var ErrNoSuchPeer = errors.New("no such peer")
+var ErrAmbiguousPeer = errors.New("ambiguous peer")
+
 func (ps PeerStore) MarshalJSON() ([]byte, error) {
 	m := make(map[string]Props)
 	for k, v := range ps {
@@ -16,6 +21,9 @@ func (ps PeerStore) MarshalJSON() ([]byte, error) {
 }
 
 func (psPtr *PeerStore) UnmarshalJSON(b []byte) error {
+	if *psPtr == nil {
+		*psPtr = make(PeerStore)
+	}
 	ps := *psPtr
 	var m map[string]Props
 	if err := json.Unmarshal(b, &m); err != nil {
@@ -30,3 +38,32 @@ func (psPtr *PeerStore) UnmarshalJSON(b []byte) error {
 	}
 	return nil
 }
+
+// Get returns the peer with a given Fingerprint.
+func (ps PeerStore) Get(fp delphi.Fingerprint) (Peer, bool) {
+	for pub, props := range ps {
+		if pub.Fingerprint() == fp {
+			return Peer{PublicKey: pub, Props: props}, true
+		}
+	}
+	return Peer{}, false
+}
+
+// Find returns the one peer matching query, which is a Fingerprint prefix, a full public key, or a nickname.
+// Since nicknames are not unique, a query matching more than one peer is an error.
+func (ps PeerStore) Find(query string) (Peer, error) {
+	var matches []Peer
+	for pub, props := range ps {
+		if pub.Fingerprint().HasPrefix(query) || pub.String() == query || pub.Nickname() == query {
+			matches = append(matches, Peer{PublicKey: pub, Props: props})
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Peer{}, fmt.Errorf("%w: %s", ErrNoSuchPeer, query)
+	case 1:
+		return matches[0], nil
+	default:
+		return Peer{}, fmt.Errorf("%w: %q matches %d peers", ErrAmbiguousPeer, query, len(matches))
+	}
+}
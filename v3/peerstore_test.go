@@ -425,3 +425,48 @@ func TestPeerStore_JSONCompatibility(t *testing.T) {
 		assert.Equal(t, restored1, restored2)
 	})
 }
+
+func TestPeerStore_Find(t *testing.T) {
+	ps := make(PeerStore)
+	alice := delphi.PublicKey(delphi.NewKey(fakeRand(1)))
+	bob := delphi.PublicKey(delphi.NewKey(fakeRand(2)))
+	ps[alice] = Props{"name": "Alice"}
+	ps[bob] = Props{"name": "Bob"}
+
+	t.Run("get by fingerprint", func(t *testing.T) {
+		p, ok := ps.Get(alice.Fingerprint())
+		assert.True(t, ok)
+		assert.Equal(t, "Alice", p.Props["name"])
+		_, ok = ps.Get(delphi.ZeroFingerprint)
+		assert.False(t, ok)
+	})
+
+	t.Run("find by short fingerprint", func(t *testing.T) {
+		p, err := ps.Find(bob.Fingerprint().Short())
+		require.NoError(t, err)
+		assert.Equal(t, bob, p.PublicKey)
+	})
+
+	t.Run("find by nickname", func(t *testing.T) {
+		p, err := ps.Find(alice.Nickname())
+		require.NoError(t, err)
+		assert.Equal(t, alice, p.PublicKey)
+	})
+
+	t.Run("nicknames are not unique", func(t *testing.T) {
+		twin := alice
+		twin[1][0] ^= 1
+		ps[twin] = Props{}
+		defer delete(ps, twin)
+		_, err := ps.Find(alice.Nickname())
+		assert.ErrorIs(t, err, ErrAmbiguousPeer)
+		p, err := ps.Find(twin.Fingerprint().String())
+		require.NoError(t, err)
+		assert.Equal(t, twin, p.PublicKey)
+	})
+
+	t.Run("no such peer", func(t *testing.T) {
+		_, err := ps.Find("nobody")
+		assert.ErrorIs(t, err, ErrNoSuchPeer)
+	})
+}
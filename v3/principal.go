@@ -1,11 +1,15 @@
 package oracle
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
 	"io"
+	"maps"
 )
 
 type Props = map[string]string
@@ -14,12 +18,18 @@ type Principal struct {
 	Props   Props          `json:"Props"`
 	KeyPair delphi.KeyPair `json:"keypair"`
 	Peers   PeerStore      `json:"peers"`
+	Retired []RetiredKey   `json:"retired,omitempty"`
+	//	Endorsements of peers, and of peers of peers, keyed by whom they endorse
+	Endorsements EndorsementStore `json:"endorsements,omitempty"`
+	//	Derivations of keys that aren't peers, keyed by the child
+	Derivations DerivationStore `json:"derivations,omitempty"`
+	unsigned    bool
 }
 
 func (pr *Principal) MarshalPEM() ([]byte, error) {
 	pr.expound()
 	block := &pem.Block{
-		Type:    "ORACLE PRIVATE KEY",
+		Type:    pemPrivateKey,
 		Headers: pr.Props,
 		Bytes:   pr.KeyPair.Bytes(),
 	}
@@ -32,18 +42,31 @@ func (pr *Principal) UnmarshalPEM(data []byte) error {
 	if block == nil {
 		return errors.New("PEM decode failed")
 	}
-	if block.Type != "ORACLE PRIVATE KEY" {
+	if block.Type == pemEncryptedPrivateKey {
+		return ErrPassphraseRequired
+	}
+	if block.Type != pemPrivateKey {
 		return errors.New("wrong PEM type: " + block.Type)
 	}
 
-	kp := delphi.KeyPair{}
+	return pr.fromPEM(block.Bytes, block.Headers)
+}
 
-	_, err := kp.Write(block.Bytes)
+// fromPEM sets up a Principal from the body and headers of a private key PEM, once any encryption is removed.
+// The headers must agree with the key.
+func (pr *Principal) fromPEM(keyPair []byte, headers map[string]string) error {
+	kp, err := delphi.KeyPairFromBytes(keyPair)
 	if err != nil {
 		return err
 	}
+	if err := checkCapabilities(headers, kp.Capabilities()); err != nil {
+		return err
+	}
+	if err := checkSuite(headers); err != nil {
+		return err
+	}
 	pr.KeyPair = kp
-	pr.Props = block.Headers
+	pr.Props = headers
 	pr.Peers = make(PeerStore)
 	pr.condense()
 	return nil
@@ -52,9 +75,20 @@ func (pr *Principal) UnmarshalPEM(data []byte) error {
 func (pr *Principal) SaveJSON(w io.Writer) error {
 	pr.MustBeValid()
 	pr.expound()
+	verity, err := pr.SignConfig(rand.Reader)
+	if err != nil {
+		return err
+	}
+	pr.unsigned = false
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "\t")
-	return enc.Encode(pr)
+	return enc.Encode(signedConfig{Principal: pr, Verity: verity})
+}
+
+// signedConfig is the JSON form of a Principal, along with its Verity.
+type signedConfig struct {
+	*Principal
+	Verity *Verity `json:"verity"`
 }
 
 func (pr *Principal) initialize() {
@@ -62,28 +96,55 @@ func (pr *Principal) initialize() {
 	pr.Peers = make(PeerStore)
 }
 
+// derivedProps are Props computed from the public key, rather than stored.
+var derivedProps = []string{"nick", "fingerprint", propCapabilities}
+
 // expound() adds derived values to Props.
 // Good for situations where you want maximum context.
 func (pr *Principal) expound() {
 	pr.MustBeValid()
-	nick := pr.NickName()
-	pr.Props["nick"] = nick
+	pr.Props["nick"] = pr.NickName()
+	pr.Props["fingerprint"] = pr.Fingerprint().String()
+	expoundCapabilities(pr.Props, pr.Capabilities())
 }
 
 // condense() removes derived values from Props.
 // Good for brevity and avoiding confusion about which values are derived and which are not.
 func (pr *Principal) condense() {
 	pr.MustBeValid()
-	delete(pr.Props, "nick")
+	for _, k := range derivedProps {
+		delete(pr.Props, k)
+	}
 }
 
+// LoadJSON reads a JSON config. The config must carry a Verity, or ErrUnsignedConfig is returned.
 func LoadJSON(r io.Reader) (*Principal, error) {
+	return loadJSON(r, false)
+}
+
+// LoadUnsignedJSON is like LoadJSON, but also accepts a config with no Verity, as written before configs were signed.
+// Nothing in such a config is protected, so it should only be used to migrate a config, by saving it again.
+func LoadUnsignedJSON(r io.Reader) (*Principal, error) {
+	return loadJSON(r, true)
+}
+
+func loadJSON(r io.Reader, allowUnsigned bool) (*Principal, error) {
 	p := new(Principal)
 	p.initialize()
 	dec := json.NewDecoder(r)
-	err := dec.Decode(p)
+	conf := signedConfig{Principal: p}
+	if err := dec.Decode(&conf); err != nil {
+		return p, err
+	}
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
 	p.condense()
-	return p, err
+	if conf.Verity == nil && allowUnsigned {
+		p.unsigned = true
+		return p, nil
+	}
+	return p, p.VerifyConfig(conf.Verity)
 }
 
 func NewPrincipal(randy io.Reader) *Principal {
@@ -98,6 +159,62 @@ func NewPrincipal(randy io.Reader) *Principal {
 	return p
 }
 
+// NewPrincipalFromMnemonic recovers a Principal from a phrase made by delphi.NewMnemonic.
+// Only the keys are recovered. Props and peers are not part of the phrase.
+func NewPrincipalFromMnemonic(phrase string) (*Principal, error) {
+	keypair, err := delphi.KeyPairFromMnemonic(phrase)
+	if err != nil {
+		return nil, err
+	}
+	p := &Principal{KeyPair: keypair}
+	p.initialize()
+	p.expound()
+	return p, nil
+}
+
+// Destroy wipes the Principal's KeyPair, and its retired KeyPairs. The Principal is unusable afterwards.
+// Copies made by passing the KeyPair around by value are not wiped.
+func (pr *Principal) Destroy() {
+	pr.KeyPair.Destroy()
+	for i := range pr.Retired {
+		pr.Retired[i].KeyPair.Destroy()
+	}
+	pr.Retired = nil
+}
+
+var (
+	ErrNilProps = errors.New("nil props")
+	ErrNilPeers = errors.New("nil peers")
+)
+
+// Validate is like MustBeValid, but returns an error instead of panicking.
+// It also checks that the KeyPair is whole and self-consistent, and that every suite in Props is known.
+func (pr *Principal) Validate() error {
+	if pr.Props == nil {
+		return ErrNilProps
+	}
+	if pr.Peers == nil {
+		return ErrNilPeers
+	}
+	if err := pr.KeyPair.Validate(); err != nil {
+		return fmt.Errorf("invalid keypair. %w", err)
+	}
+	for i := range pr.Retired {
+		if err := pr.Retired[i].KeyPair.Validate(); err != nil {
+			return fmt.Errorf("invalid retired keypair. %w", err)
+		}
+	}
+	if err := checkSuite(pr.Props); err != nil {
+		return err
+	}
+	for pub, props := range pr.Peers {
+		if err := checkSuite(props); err != nil {
+			return fmt.Errorf("invalid peer %s. %w", pub.Fingerprint().Short(), err)
+		}
+	}
+	return nil
+}
+
 func (pr *Principal) MustBeValid() {
 	//pr.KeyPair[0].MustBeValid()
 	//pr.KeyPair[1].MustBeValid()
@@ -109,15 +226,20 @@ func (pr *Principal) MustBeValid() {
 	}
 }
 
+// NickName is a friendly label. It is not unique. Use ID or Fingerprint for that.
 func (pr *Principal) NickName() string {
 	//pr.MustBeValid()
 	return pr.KeyPair.PublicKey().Nickname()
 }
 
+func (pr *Principal) Fingerprint() delphi.Fingerprint {
+	return pr.KeyPair.PublicKey().Fingerprint()
+}
+
 // ID produces a string that uniquely identifies a Principal
 func (pr *Principal) ID() string {
 	pr.MustBeValid()
-	return pr.NickName()
+	return pr.Fingerprint().String()
 }
 
 func (pr *Principal) AsPeer() Peer {
@@ -128,12 +250,46 @@ func (pr *Principal) AsPeer() Peer {
 	}
 }
 
+// AddPeer adds or replaces a peer. A peer that has been revoked stays revoked.
 func (pr *Principal) AddPeer(peer Peer) {
 	//pr.MustBeValid()
-	pr.Peers[peer.PublicKey] = peer.Props
+	props := peer.Props
+	if old, revoked := pr.Peers[peer.PublicKey]; revoked && old[propRevoked] != "" {
+		props = maps.Clone(props)
+		if props == nil {
+			props = make(Props)
+		}
+		for _, k := range revocationProps {
+			props[k] = old[k]
+		}
+	}
+	pr.Peers[peer.PublicKey] = props
 }
 
 func (pr *Principal) HasPeer(pub delphi.PublicKey) bool {
 	_, ok := pr.Peers[pub]
 	return ok
 }
+
+var ErrUnknownSender = errors.New("unknown sender")
+var ErrBadSignature = message.ErrBadSignature
+
+// AuthenticateSender checks that a message declares a sender, that the sender is one of our peers,
+// and that the sender really signed it.
+func (pr *Principal) AuthenticateSender(msg *message.Message) (Peer, error) {
+	pub, err := msg.SenderKey()
+	if err != nil {
+		return Peer{}, err
+	}
+	props, ok := pr.Peers[pub]
+	if !ok {
+		return Peer{}, fmt.Errorf("%w: %s", ErrUnknownSender, pub.Nickname())
+	}
+	if err := pr.CheckRevoked(pub); err != nil {
+		return Peer{}, err
+	}
+	if !msg.VerifySender(&pr.KeyPair) {
+		return Peer{}, fmt.Errorf("%w from %s", ErrBadSignature, pub.Nickname())
+	}
+	return Peer{PublicKey: pub, Props: props}, nil
+}
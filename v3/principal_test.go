@@ -3,10 +3,14 @@ package oracle
 import (
 	"bytes"
 	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewPrincipal(t *testing.T) {
@@ -63,6 +67,53 @@ func TestPrincipal_MustBeValid(t *testing.T) {
 	})
 }
 
+func TestPrincipal_Validate(t *testing.T) {
+	assert.NoError(t, NewPrincipal(fakeRand(1)).Validate())
+
+	malory := NewPrincipal(fakeRand(1))
+	malory.Props = nil
+	assert.ErrorIs(t, malory.Validate(), ErrNilProps)
+
+	malory = NewPrincipal(fakeRand(1))
+	malory.Peers = nil
+	assert.ErrorIs(t, malory.Validate(), ErrNilPeers)
+
+	malory = NewPrincipal(fakeRand(1))
+	malory.KeyPair[1] = delphi.Key{}
+	assert.ErrorIs(t, malory.Validate(), delphi.ErrZeroKey)
+
+	malory = NewPrincipal(fakeRand(1))
+	malory.KeyPair[0] = NewPrincipal(fakeRand(3)).KeyPair[0]
+	assert.ErrorIs(t, malory.Validate(), delphi.ErrKeyMismatch)
+}
+
+func TestLoadJSON_Invalid(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	kp, err := alice.KeyPair.MarshalJSON()
+	assert.NoError(t, err)
+
+	t.Run("null props", func(t *testing.T) {
+		data := `{"Props": null, "keypair": ` + string(kp) + `, "peers": {}}`
+		assert.NotPanics(t, func() {
+			_, err := LoadJSON(strings.NewReader(data))
+			assert.ErrorIs(t, err, ErrNilProps)
+		})
+	})
+
+	t.Run("null peers", func(t *testing.T) {
+		data := `{"Props": {}, "keypair": ` + string(kp) + `, "peers": null}`
+		got, err := LoadUnsignedJSON(strings.NewReader(data))
+		require.NoError(t, err)
+		assert.NotNil(t, got.Peers)
+		assert.True(t, got.Unsigned())
+	})
+
+	t.Run("missing keypair", func(t *testing.T) {
+		_, err := LoadJSON(strings.NewReader(`{"Props": {}, "peers": {}}`))
+		assert.ErrorIs(t, err, delphi.ErrZeroKey)
+	})
+}
+
 func TestPrincipal_Save(t *testing.T) {
 	alice := getTestPrincipal(t, "alice")
 	alice.Props["favourite band"] = "Nirvana"
@@ -132,6 +183,22 @@ func TestPrincipal_MarshalPEM(t *testing.T) {
 	})
 }
 
+func TestPrincipal_ID(t *testing.T) {
+	dawn := getTestPrincipal(t, "falling-dawn")
+	assert.Equal(t, dawn.KeyPair.PublicKey().Fingerprint().String(), dawn.ID())
+	peer := dawn.AsPeer()
+	assert.Equal(t, dawn.Fingerprint(), peer.Fingerprint())
+
+	//	the fingerprint is written out, but as a derived value it is not read back in
+	bin, err := dawn.MarshalPEM()
+	assert.NoError(t, err)
+	assert.Contains(t, string(bin), "fingerprint: "+dawn.ID())
+	again := new(Principal)
+	assert.NoError(t, again.UnmarshalPEM(bin))
+	assert.Equal(t, "", again.Props["fingerprint"])
+	assert.Equal(t, dawn.ID(), again.ID())
+}
+
 func TestPrincipal_UnmarshalPEM(t *testing.T) {
 	bin, err := os.ReadFile("testdata/falling-dawn.principal.pem")
 	assert.NoError(t, err)
@@ -144,3 +211,75 @@ func TestPrincipal_UnmarshalPEM(t *testing.T) {
 	prince.AddPeer(jack)
 	assert.Len(t, prince.Peers, 1)
 }
+
+func TestPrincipal_AuthenticateSender(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	bob.AddPeer(alice.AsPeer())
+
+	signed := func(from *Principal) *message.Message {
+		msg := message.NewMessage(fakeRand(7))
+		msg.PlainText = []byte("hello bob")
+		msg.SetSender(from.KeyPair.PublicKey())
+		err := msg.Sign(&from.KeyPair)
+		assert.NoError(t, err)
+		return msg
+	}
+
+	t.Run("known sender", func(t *testing.T) {
+		peer, err := bob.AuthenticateSender(signed(alice))
+		assert.NoError(t, err)
+		assert.Equal(t, alice.NickName(), peer.NickName())
+	})
+
+	t.Run("unknown sender", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(4))
+		_, err := bob.AuthenticateSender(signed(carol))
+		assert.ErrorIs(t, err, ErrUnknownSender)
+	})
+
+	t.Run("impersonation", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(4))
+		msg := signed(carol)
+		msg.SetSender(alice.KeyPair.PublicKey())
+		_, err := bob.AuthenticateSender(msg)
+		assert.ErrorIs(t, err, ErrBadSignature)
+		assert.ErrorIs(t, err, message.ErrBadSignature)
+	})
+
+	t.Run("no sender", func(t *testing.T) {
+		msg := message.NewMessage(fakeRand(7))
+		msg.PlainText = []byte("anonymous")
+		_, err := bob.AuthenticateSender(msg)
+		assert.ErrorIs(t, err, message.ErrNoSender)
+	})
+}
+
+func TestNewPrincipalFromMnemonic(t *testing.T) {
+	phrase, err := delphi.NewMnemonic(fakeRand(1))
+	require.NoError(t, err)
+	alice, err := NewPrincipalFromMnemonic(phrase)
+	require.NoError(t, err)
+	require.NoError(t, alice.Validate())
+
+	again, err := NewPrincipalFromMnemonic(phrase)
+	require.NoError(t, err)
+	assert.Equal(t, alice.AsPeer().PublicKey, again.AsPeer().PublicKey)
+	assert.Equal(t, alice.NickName(), again.NickName())
+
+	_, err = NewPrincipalFromMnemonic("hello world")
+	assert.ErrorIs(t, err, delphi.ErrBadMnemonic)
+}
+
+func TestPrincipal_Destroy(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	_, err := alice.Rotate(fakeRand(2), time.Now(), time.Hour)
+	require.NoError(t, err)
+	require.Len(t, alice.Retired, 1)
+	retired := alice.Retired[:1]
+
+	alice.Destroy()
+	assert.Equal(t, delphi.ZeroKeyPair, alice.KeyPair)
+	assert.Empty(t, alice.Retired)
+	assert.Equal(t, delphi.ZeroKeyPair, retired[0].KeyPair)
+}
@@ -0,0 +1,173 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+)
+
+var ErrRelay = errors.New("relay error")
+
+// A Client talks to a relay Server. Anyone can Send, but only a Client that has logged in can read and delete mail.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+
+	mu      sync.Mutex
+	mailbox delphi.Fingerprint
+	token   string
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), HTTP: http.DefaultClient}
+}
+
+// responseError turns an unhappy response into an error wrapping the matching sentinel.
+func responseError(resp *http.Response) error {
+	var e errorResponse
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+	sentinel := ErrRelay
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		sentinel = ErrNoSuchMessage
+	case http.StatusUnauthorized, http.StatusForbidden:
+		sentinel = ErrUnauthorized
+	case http.StatusTooManyRequests:
+		sentinel = ErrTooMany
+	case http.StatusInsufficientStorage:
+		sentinel = ErrMailboxFull
+	case http.StatusServiceUnavailable:
+		sentinel = ErrRelayFull
+	}
+	return fmt.Errorf("%w. %s: %s", sentinel, resp.Status, e.Error)
+}
+
+// do sends a request, and decodes a JSON response into out, if out is not nil.
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, auth bool, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if auth {
+		c.mu.Lock()
+		token := c.token
+		c.mu.Unlock()
+		if token == "" {
+			return fmt.Errorf("%w. log in first", ErrUnauthorized)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w. %w", ErrRelay, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if b, ok := out.(*[]byte); ok {
+		*b, err = io.ReadAll(resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) mailboxPath() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == "" {
+		return "", fmt.Errorf("%w. log in first", ErrUnauthorized)
+	}
+	return "/v1/mail/" + c.mailbox.String(), nil
+}
+
+// Send drops off an encrypted message for a recipient, returning its ID.
+func (c *Client) Send(ctx context.Context, to delphi.PublicKey, msg *message.Message) (string, error) {
+	if !msg.IsEncrypted() {
+		return "", ErrNotEncrypted
+	}
+	var resp putResponse
+	err := c.do(ctx, http.MethodPost, "/v1/mail/"+to.Fingerprint().String(), bytes.NewReader(msg.Serialize()), false, &resp)
+	return resp.ID, err
+}
+
+// Login proves that we hold kp by signing a challenge, and keeps the resulting token for later requests.
+func (c *Client) Login(ctx context.Context, kp *delphi.KeyPair) error {
+	pub := kp.PublicKey()
+	fp := pub.Fingerprint()
+	var challenge challengeResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/challenge/"+fp.String(), nil, false, &challenge); err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(challenge.Challenge)
+	if err != nil {
+		return fmt.Errorf("%w. could not decode challenge. %w", ErrRelay, err)
+	}
+	sig, err := kp.Sign(nil, challengeData(fp, raw), nil)
+	if err != nil {
+		return fmt.Errorf("could not sign challenge. %w", err)
+	}
+	body, err := json.Marshal(sessionRequest{
+		PublicKey: pub,
+		Challenge: challenge.Challenge,
+		Signature: hex.EncodeToString(sig),
+	})
+	if err != nil {
+		return err
+	}
+	var sess sessionResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/session/"+fp.String(), bytes.NewReader(body), false, &sess); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.mailbox = fp
+	c.token = sess.Token
+	c.mu.Unlock()
+	return nil
+}
+
+// List returns the IDs of the messages waiting for us, oldest first.
+func (c *Client) List(ctx context.Context) ([]string, error) {
+	path, err := c.mailboxPath()
+	if err != nil {
+		return nil, err
+	}
+	var resp listResponse
+	err = c.do(ctx, http.MethodGet, path, nil, true, &resp)
+	return resp.IDs, err
+}
+
+// Fetch gets a message. It stays on the server until it is deleted.
+func (c *Client) Fetch(ctx context.Context, id string) (*message.Message, error) {
+	path, err := c.mailboxPath()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := c.do(ctx, http.MethodGet, path+"/"+url.PathEscape(id), nil, true, &data); err != nil {
+		return nil, err
+	}
+	return message.DecodeMessage(data)
+}
+
+func (c *Client) Delete(ctx context.Context, id string) error {
+	path, err := c.mailboxPath()
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodDelete, path+"/"+url.PathEscape(id), nil, true, nil)
+}
@@ -0,0 +1,234 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRelay(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	srv := NewServer(NewMemoryStore(), rand.Reader)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return srv, ts
+}
+
+func encryptedFor(t *testing.T, to delphi.PublicKey, text string) *message.Message {
+	t.Helper()
+	sender := delphi.NewKeyPair(rand.Reader)
+	msg := message.NewMessage(rand.Reader)
+	msg.PlainText = []byte(text)
+	require.NoError(t, msg.Encrypt(rand.Reader, to, &sender))
+	return msg
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	_, ts := newRelay(t)
+	alice := delphi.NewKeyPair(rand.Reader)
+	mallory := delphi.NewKeyPair(rand.Reader)
+
+	sender := NewClient(ts.URL)
+	first, err := sender.Send(ctx, alice.PublicKey(), encryptedFor(t, alice.PublicKey(), "hello"))
+	require.NoError(t, err)
+	second, err := sender.Send(ctx, alice.PublicKey(), encryptedFor(t, alice.PublicKey(), "again"))
+	require.NoError(t, err)
+
+	t.Run("plain messages are refused", func(t *testing.T) {
+		msg := message.NewMessage(rand.Reader)
+		msg.PlainText = []byte("hello")
+		_, err := sender.Send(ctx, alice.PublicKey(), msg)
+		assert.ErrorIs(t, err, ErrNotEncrypted)
+		resp, err := http.Post(ts.URL+"/v1/mail/"+alice.PublicKey().Fingerprint().String(), "", strings.NewReader(string(msg.Serialize())))
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("strangers can't read", func(t *testing.T) {
+		_, err := sender.List(ctx)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+		resp, err := http.Get(ts.URL + "/v1/mail/" + alice.PublicKey().Fingerprint().String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		//	mallory can log in, but only to her own mailbox
+		eve := NewClient(ts.URL)
+		require.NoError(t, eve.Login(ctx, &mallory))
+		ids, err := eve.List(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+		_, err = eve.Fetch(ctx, first)
+		assert.ErrorIs(t, err, ErrNoSuchMessage)
+	})
+
+	t.Run("recipient reads and deletes", func(t *testing.T) {
+		c := NewClient(ts.URL)
+		require.NoError(t, c.Login(ctx, &alice))
+		ids, err := c.List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{first, second}, ids)
+
+		msg, err := c.Fetch(ctx, first)
+		require.NoError(t, err)
+		require.NoError(t, msg.Decrypt(&alice))
+		assert.Equal(t, "hello", string(msg.PlainText))
+
+		require.NoError(t, c.Delete(ctx, first))
+		ids, err = c.List(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{second}, ids)
+		assert.ErrorIs(t, c.Delete(ctx, first), ErrNoSuchMessage)
+	})
+}
+
+func TestServer_Session(t *testing.T) {
+	ctx := context.Background()
+	srv, ts := newRelay(t)
+	alice := delphi.NewKeyPair(rand.Reader)
+	mallory := delphi.NewKeyPair(rand.Reader)
+	fp := alice.PublicKey().Fingerprint()
+
+	challenge := func() []byte {
+		t.Helper()
+		var c challengeResponse
+		require.NoError(t, NewClient(ts.URL).do(ctx, http.MethodPost, "/v1/challenge/"+fp.String(), nil, false, &c))
+		return []byte(c.Challenge)
+	}
+
+	t.Run("wrong key for the mailbox", func(t *testing.T) {
+		c := NewClient(ts.URL)
+		body := `{"pubkey":"` + mallory.PublicKey().String() + `","challenge":"` + string(challenge()) + `","sig":"00"}`
+		err := c.do(ctx, http.MethodPost, "/v1/session/"+fp.String(), strings.NewReader(body), false, nil)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+
+	t.Run("bad signature", func(t *testing.T) {
+		c := NewClient(ts.URL)
+		body := `{"pubkey":"` + alice.PublicKey().String() + `","challenge":"` + string(challenge()) + `","sig":"00"}`
+		err := c.do(ctx, http.MethodPost, "/v1/session/"+fp.String(), strings.NewReader(body), false, nil)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+
+	t.Run("sessions expire", func(t *testing.T) {
+		c := NewClient(ts.URL)
+		require.NoError(t, c.Login(ctx, &alice))
+		_, err := c.List(ctx)
+		require.NoError(t, err)
+		srv.mu.Lock()
+		srv.now = func() time.Time { return time.Now().Add(DefaultSessionTTL + time.Second) }
+		srv.mu.Unlock()
+		_, err = c.List(ctx)
+		assert.ErrorIs(t, err, ErrUnauthorized)
+	})
+}
+
+func TestServer_Limits(t *testing.T) {
+	ctx := context.Background()
+	srv, ts := newRelay(t)
+	alice := delphi.NewKeyPair(rand.Reader)
+	bob := delphi.NewKeyPair(rand.Reader)
+	askFor := func(fp delphi.Fingerprint) error {
+		var c challengeResponse
+		return NewClient(ts.URL).do(ctx, http.MethodPost, "/v1/challenge/"+fp.String(), nil, false, &c)
+	}
+
+	t.Run("challenges per mailbox", func(t *testing.T) {
+		for range DefaultMaxMailboxChallenges {
+			require.NoError(t, askFor(alice.PublicKey().Fingerprint()))
+		}
+		assert.ErrorIs(t, askFor(alice.PublicKey().Fingerprint()), ErrTooMany)
+		//	not even the owner can log in until some expire
+		assert.ErrorIs(t, NewClient(ts.URL).Login(ctx, &alice), ErrTooMany)
+		require.NoError(t, askFor(bob.PublicKey().Fingerprint()))
+	})
+
+	t.Run("expired challenges are swept", func(t *testing.T) {
+		srv.mu.Lock()
+		srv.now = func() time.Time { return time.Now().Add(DefaultChallengeTTL + time.Second) }
+		srv.mu.Unlock()
+		require.NoError(t, NewClient(ts.URL).Login(ctx, &alice))
+		srv.mu.Lock()
+		assert.Empty(t, srv.challenges)
+		assert.Empty(t, srv.outstanding)
+		srv.mu.Unlock()
+	})
+
+	t.Run("challenges in all", func(t *testing.T) {
+		srv.mu.Lock()
+		srv.MaxChallenges = 2
+		srv.mu.Unlock()
+		var fp delphi.Fingerprint
+		for i := range 2 {
+			fp[0] = byte(i)
+			require.NoError(t, askFor(fp))
+		}
+		fp[0] = 9
+		assert.ErrorIs(t, askFor(fp), ErrTooMany)
+	})
+
+	t.Run("mailbox quota", func(t *testing.T) {
+		srv.MaxMailboxMessages = 2
+		sender := NewClient(ts.URL)
+		to := bob.PublicKey()
+		_, err := sender.Send(ctx, to, encryptedFor(t, to, "one"))
+		require.NoError(t, err)
+		_, err = sender.Send(ctx, to, encryptedFor(t, to, "two"))
+		require.NoError(t, err)
+		_, err = sender.Send(ctx, to, encryptedFor(t, to, "three"))
+		assert.ErrorIs(t, err, ErrMailboxFull)
+
+		carolKeys := delphi.NewKeyPair(rand.Reader)
+		carol := carolKeys.PublicKey()
+		first := encryptedFor(t, carol, "small")
+		srv.MaxMailboxBytes = int64(len(first.Serialize())) + 10
+		_, err = sender.Send(ctx, carol, first)
+		require.NoError(t, err)
+		_, err = sender.Send(ctx, carol, encryptedFor(t, carol, "small"))
+		assert.ErrorIs(t, err, ErrMailboxFull)
+	})
+
+	t.Run("relay quota", func(t *testing.T) {
+		srv.MaxMailboxMessages = DefaultMaxMailboxMessages
+		srv.MaxMailboxBytes = DefaultMaxMailboxBytes
+		count, _, err := srv.Store.TotalUsage()
+		require.NoError(t, err)
+		srv.MaxTotalMessages = count + 2
+		sender := NewClient(ts.URL)
+		//	a new mailbox for every message
+		for range 2 {
+			strangerKeys := delphi.NewKeyPair(rand.Reader)
+			stranger := strangerKeys.PublicKey()
+			_, err := sender.Send(ctx, stranger, encryptedFor(t, stranger, "hello"))
+			require.NoError(t, err)
+		}
+		strangerKeys := delphi.NewKeyPair(rand.Reader)
+		stranger := strangerKeys.PublicKey()
+		_, err = sender.Send(ctx, stranger, encryptedFor(t, stranger, "hello"))
+		assert.ErrorIs(t, err, ErrRelayFull)
+
+		srv.MaxTotalMessages = DefaultMaxTotalMessages
+		_, used, err := srv.Store.TotalUsage()
+		require.NoError(t, err)
+		srv.MaxTotalBytes = used
+		_, err = sender.Send(ctx, stranger, encryptedFor(t, stranger, "hello"))
+		assert.ErrorIs(t, err, ErrRelayFull)
+	})
+
+	t.Run("request bodies are capped", func(t *testing.T) {
+		body := strings.NewReader(`{"challenge":"` + strings.Repeat("0", 8192) + `"}`)
+		err := NewClient(ts.URL).do(ctx, http.MethodPost, "/v1/session/"+alice.PublicKey().Fingerprint().String(), body, false, nil)
+		assert.ErrorIs(t, err, ErrRelay)
+	})
+}
@@ -0,0 +1,433 @@
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+)
+
+const (
+	challengeDomain = "oracle/relay\x00"
+	challengeSize   = 32
+	tokenSize       = 32
+)
+
+// Defaults for a Server.
+const (
+	DefaultMaxMessageSize       = 16 << 20
+	DefaultChallengeTTL         = time.Minute
+	DefaultSessionTTL           = 15 * time.Minute
+	DefaultMaxChallenges        = 1 << 14
+	DefaultMaxMailboxChallenges = 8
+	DefaultMaxSessions          = 1 << 14
+	DefaultMaxMailboxMessages   = 1 << 10
+	DefaultMaxMailboxBytes      = 256 << 20
+	DefaultMaxTotalMessages     = 1 << 16
+	DefaultMaxTotalBytes        = 4 << 30
+)
+
+var (
+	ErrNotEncrypted  = errors.New("only encrypted messages can be relayed")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrBadChallenge  = errors.New("bad challenge")
+	ErrWrongMailbox  = errors.New("public key does not match mailbox")
+	ErrTooMany       = errors.New("too many outstanding challenges or sessions")
+	ErrMailboxFull   = errors.New("mailbox is full")
+	ErrRelayFull     = errors.New("relay is full")
+	errTokenRequired = errors.New("bearer token required")
+)
+
+// challengeData is what a recipient signs to prove they hold the key for a mailbox.
+func challengeData(to delphi.Fingerprint, challenge []byte) []byte {
+	b := []byte(challengeDomain)
+	b = append(b, to[:]...)
+	return append(b, challenge...)
+}
+
+type challengeResponse struct {
+	Challenge string `json:"challenge"`
+}
+
+type sessionRequest struct {
+	PublicKey delphi.PublicKey `json:"pubkey"`
+	Challenge string           `json:"challenge"`
+	Signature string           `json:"sig"`
+}
+
+type sessionResponse struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+type putResponse struct {
+	ID string `json:"id"`
+}
+
+type listResponse struct {
+	IDs []string `json:"ids"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type pending struct {
+	to      delphi.Fingerprint
+	expires time.Time
+}
+
+// A Server is an http.Handler that relays encrypted messages.
+//
+// Since anyone can drop off mail and ask for challenges, everything a stranger can make the Server hold is capped:
+// outstanding challenges, in all and per mailbox, sessions, and the number and size of messages waiting,
+// in each mailbox and in all.
+//
+//	POST   /v1/mail/{fingerprint}       drop off a message. Anyone can do this
+//	POST   /v1/challenge/{fingerprint}  get a challenge to sign
+//	POST   /v1/session/{fingerprint}    exchange a signed challenge for a bearer token
+//	GET    /v1/mail/{fingerprint}       list message IDs
+//	GET    /v1/mail/{fingerprint}/{id}  fetch a message, in the binary wire format
+//	DELETE /v1/mail/{fingerprint}/{id}  delete a message
+type Server struct {
+	Store                Store
+	MaxMessageSize       int64
+	ChallengeTTL         time.Duration
+	SessionTTL           time.Duration
+	MaxChallenges        int
+	MaxMailboxChallenges int
+	MaxSessions          int
+	MaxMailboxMessages   int
+	MaxMailboxBytes      int64
+	MaxTotalMessages     int
+	MaxTotalBytes        int64
+
+	randy io.Reader
+	now   func() time.Time
+	mux   *http.ServeMux
+
+	mu         sync.Mutex
+	challenges map[string]pending
+	//	outstanding counts challenges per mailbox
+	outstanding map[delphi.Fingerprint]int
+	sessions    map[string]pending
+
+	//	putMu makes checking a mailbox's quota and filling it one step
+	putMu sync.Mutex
+}
+
+// NewServer returns a Server backed by store. randy is used for challenges, tokens and message IDs.
+func NewServer(store Store, randy io.Reader) *Server {
+	if randy == nil {
+		randy = rand.Reader
+	}
+	s := &Server{
+		Store:                store,
+		MaxMessageSize:       DefaultMaxMessageSize,
+		ChallengeTTL:         DefaultChallengeTTL,
+		SessionTTL:           DefaultSessionTTL,
+		MaxChallenges:        DefaultMaxChallenges,
+		MaxMailboxChallenges: DefaultMaxMailboxChallenges,
+		MaxSessions:          DefaultMaxSessions,
+		MaxMailboxMessages:   DefaultMaxMailboxMessages,
+		MaxMailboxBytes:      DefaultMaxMailboxBytes,
+		MaxTotalMessages:     DefaultMaxTotalMessages,
+		MaxTotalBytes:        DefaultMaxTotalBytes,
+		randy:                randy,
+		now:                  time.Now,
+		mux:                  http.NewServeMux(),
+		challenges:           map[string]pending{},
+		outstanding:          map[delphi.Fingerprint]int{},
+		sessions:             map[string]pending{},
+	}
+	s.mux.HandleFunc("POST /v1/mail/{fp}", s.put)
+	s.mux.HandleFunc("POST /v1/challenge/{fp}", s.challenge)
+	s.mux.HandleFunc("POST /v1/session/{fp}", s.session)
+	s.mux.HandleFunc("GET /v1/mail/{fp}", s.authenticated(s.list))
+	s.mux.HandleFunc("GET /v1/mail/{fp}/{id}", s.authenticated(s.get))
+	s.mux.HandleFunc("DELETE /v1/mail/{fp}/{id}", s.authenticated(s.delete))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxMessageSize)
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(s.randy, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newID produces an ID that sorts in order of arrival.
+func (s *Server) newID() (string, error) {
+	suffix, err := s.random(8)
+	if err != nil {
+		return "", err
+	}
+	ts := binary.BigEndian.AppendUint64(nil, uint64(s.now().UnixNano()))
+	return hex.EncodeToString(ts) + suffix, nil
+}
+
+func mailbox(w http.ResponseWriter, r *http.Request) (delphi.Fingerprint, bool) {
+	fp, err := delphi.FingerprintFromString(r.PathValue("fp"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return fp, false
+	}
+	return fp, true
+}
+
+// expire forgets challenges and sessions that have run out. The caller must hold s.mu.
+// Both are capped, so this sweep is too.
+func (s *Server) expire(now time.Time) {
+	for k, p := range s.challenges {
+		if now.After(p.expires) {
+			s.forgetChallenge(k)
+		}
+	}
+	for k, p := range s.sessions {
+		if now.After(p.expires) {
+			delete(s.sessions, k)
+		}
+	}
+}
+
+// forgetChallenge removes a challenge, if there is one. The caller must hold s.mu.
+func (s *Server) forgetChallenge(c string) (pending, bool) {
+	p, ok := s.challenges[c]
+	if !ok {
+		return p, false
+	}
+	delete(s.challenges, c)
+	if s.outstanding[p.to]--; s.outstanding[p.to] <= 0 {
+		delete(s.outstanding, p.to)
+	}
+	return p, true
+}
+
+// checkQuota refuses a message that would take a mailbox, or the whole relay, over its limits.
+// Without the second, a stranger could fill the disk by writing to ever more mailboxes. The caller must hold s.putMu.
+func (s *Server) checkQuota(to delphi.Fingerprint, size int) error {
+	count, used, err := s.Store.Usage(to)
+	if err != nil {
+		return err
+	}
+	if count >= s.MaxMailboxMessages || used+int64(size) > s.MaxMailboxBytes {
+		return fmt.Errorf("%w. it holds %d messages in %d bytes", ErrMailboxFull, count, used)
+	}
+	count, used, err = s.Store.TotalUsage()
+	if err != nil {
+		return err
+	}
+	if count >= s.MaxTotalMessages || used+int64(size) > s.MaxTotalBytes {
+		return fmt.Errorf("%w. it holds %d messages in %d bytes", ErrRelayFull, count, used)
+	}
+	return nil
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	to, ok := mailbox(w, r)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.MaxMessageSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	msg, err := message.DecodeMessage(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !msg.IsEncrypted() {
+		writeError(w, http.StatusBadRequest, ErrNotEncrypted)
+		return
+	}
+	id, err := s.newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	bin := msg.Serialize()
+	s.putMu.Lock()
+	defer s.putMu.Unlock()
+	if err := s.checkQuota(to, len(bin)); err != nil {
+		switch {
+		case errors.Is(err, ErrMailboxFull):
+			writeError(w, http.StatusInsufficientStorage, err)
+		case errors.Is(err, ErrRelayFull):
+			writeError(w, http.StatusServiceUnavailable, err)
+		default:
+			storeError(w, err)
+		}
+		return
+	}
+	if err := s.Store.Put(to, id, bin); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, putResponse{ID: id})
+}
+
+func (s *Server) challenge(w http.ResponseWriter, r *http.Request) {
+	to, ok := mailbox(w, r)
+	if !ok {
+		return
+	}
+	c, err := s.random(challengeSize)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := s.now()
+	s.mu.Lock()
+	s.expire(now)
+	if len(s.challenges) >= s.MaxChallenges || s.outstanding[to] >= s.MaxMailboxChallenges {
+		s.mu.Unlock()
+		writeError(w, http.StatusTooManyRequests, ErrTooMany)
+		return
+	}
+	s.challenges[c] = pending{to: to, expires: now.Add(s.ChallengeTTL)}
+	s.outstanding[to]++
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, challengeResponse{Challenge: c})
+}
+
+func (s *Server) session(w http.ResponseWriter, r *http.Request) {
+	to, ok := mailbox(w, r)
+	if !ok {
+		return
+	}
+	var req sessionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.PublicKey.Fingerprint() != to {
+		writeError(w, http.StatusForbidden, ErrWrongMailbox)
+		return
+	}
+	now := s.now()
+	s.mu.Lock()
+	s.expire(now)
+	//	a challenge can only be used once, whether or not it succeeds
+	c, ok := s.forgetChallenge(req.Challenge)
+	s.mu.Unlock()
+	if !ok || c.to != to {
+		writeError(w, http.StatusForbidden, ErrBadChallenge)
+		return
+	}
+	challenge, err1 := hex.DecodeString(req.Challenge)
+	sig, err2 := hex.DecodeString(req.Signature)
+	if err := errors.Join(err1, err2); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !new(delphi.KeyPair).Verify(req.PublicKey.Signing(), challengeData(to, challenge), sig) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%w. signature", ErrBadChallenge))
+		return
+	}
+	token, err := s.random(tokenSize)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	expires := now.Add(s.SessionTTL)
+	s.mu.Lock()
+	if len(s.sessions) >= s.MaxSessions {
+		s.mu.Unlock()
+		writeError(w, http.StatusTooManyRequests, ErrTooMany)
+		return
+	}
+	s.sessions[token] = pending{to: to, expires: expires}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, sessionResponse{Token: token, Expires: expires.UTC()})
+}
+
+// authenticated only lets a request through if it has a live bearer token for its mailbox.
+func (s *Server) authenticated(next func(http.ResponseWriter, *http.Request, delphi.Fingerprint)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		to, ok := mailbox(w, r)
+		if !ok {
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			writeError(w, http.StatusUnauthorized, errTokenRequired)
+			return
+		}
+		now := s.now()
+		s.mu.Lock()
+		s.expire(now)
+		sess, ok := s.sessions[token]
+		s.mu.Unlock()
+		if !ok || sess.to != to {
+			writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+			return
+		}
+		next(w, r, to)
+	}
+}
+
+func storeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoSuchMessage):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrBadID):
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, _ *http.Request, to delphi.Fingerprint) {
+	ids, err := s.Store.List(to)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, listResponse{IDs: ids})
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, to delphi.Fingerprint) {
+	data, err := s.Store.Get(to, r.PathValue("id"))
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request, to delphi.Fingerprint) {
+	if err := s.Store.Delete(to, r.PathValue("id")); err != nil {
+		storeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,276 @@
+// Package relay is a mailbox for encrypted messages, and a client for it.
+//
+// Anyone can drop a message for a recipient, addressed by the fingerprint of the recipient's public key.
+// Only the recipient can list, fetch and delete their mail, having first proven they hold the key by signing a challenge.
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+const fileExt = ".msg"
+
+var (
+	ErrNoSuchMessage = errors.New("no such message")
+	ErrBadID         = errors.New("bad message id")
+)
+
+var validID = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+
+func checkID(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrBadID, id)
+	}
+	return nil
+}
+
+// A Store holds messages for recipients. Messages are opaque bytes, identified by an ID that is unique per recipient.
+// List returns IDs in the order they sort, which the Server arranges to be the order they arrived in.
+// Usage reports how many messages a recipient has waiting, and their total size in bytes.
+// TotalUsage is the same, for every recipient together.
+type Store interface {
+	Put(to delphi.Fingerprint, id string, data []byte) error
+	List(to delphi.Fingerprint) ([]string, error)
+	Get(to delphi.Fingerprint, id string) ([]byte, error)
+	Delete(to delphi.Fingerprint, id string) error
+	Usage(to delphi.Fingerprint) (count int, size int64, err error)
+	TotalUsage() (count int, size int64, err error)
+}
+
+// MemoryStore keeps messages in memory. They are lost when the process ends.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	boxes map[delphi.Fingerprint]map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{boxes: map[delphi.Fingerprint]map[string][]byte{}}
+}
+
+func (m *MemoryStore) Put(to delphi.Fingerprint, id string, data []byte) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	box, ok := m.boxes[to]
+	if !ok {
+		box = map[string][]byte{}
+		m.boxes[to] = box
+	}
+	box[id] = slices.Clone(data)
+	return nil
+}
+
+func (m *MemoryStore) List(to delphi.Fingerprint) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.boxes[to]))
+	for id := range m.boxes[to] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) Get(to delphi.Fingerprint, id string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.boxes[to][id]
+	if !ok {
+		return nil, ErrNoSuchMessage
+	}
+	return slices.Clone(data), nil
+}
+
+func (m *MemoryStore) Usage(to delphi.Fingerprint) (int, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var size int64
+	for _, data := range m.boxes[to] {
+		size += int64(len(data))
+	}
+	return len(m.boxes[to]), size, nil
+}
+
+func (m *MemoryStore) TotalUsage() (int, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var count int
+	var size int64
+	for _, box := range m.boxes {
+		count += len(box)
+		for _, data := range box {
+			size += int64(len(data))
+		}
+	}
+	return count, size, nil
+}
+
+func (m *MemoryStore) Delete(to delphi.Fingerprint, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.boxes[to][id]; !ok {
+		return ErrNoSuchMessage
+	}
+	delete(m.boxes[to], id)
+	if len(m.boxes[to]) == 0 {
+		delete(m.boxes, to)
+	}
+	return nil
+}
+
+// FileStore keeps messages on disk, one directory per recipient and one file per message.
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create mailbox directory. %w", err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+func (f *FileStore) path(to delphi.Fingerprint, id string) (string, error) {
+	if err := checkID(id); err != nil {
+		return "", err
+	}
+	return filepath.Join(f.Dir, to.String(), id+fileExt), nil
+}
+
+func (f *FileStore) Put(to delphi.Fingerprint, id string, data []byte) error {
+	path, err := f.path(to, id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	//	write to a temporary file first, so that a reader never sees half a message
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".incoming-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileStore) List(to delphi.Fingerprint) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(f.Dir, to.String()))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), fileExt)
+		if ok && checkID(id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (f *FileStore) Usage(to delphi.Fingerprint) (int, int64, error) {
+	return dirUsage(filepath.Join(f.Dir, to.String()))
+}
+
+// TotalUsage adds up the Usage of every mailbox directory. Anything else in Dir is ignored.
+func (f *FileStore) TotalUsage() (int, int64, error) {
+	entries, err := os.ReadDir(f.Dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, entry := range entries {
+		if _, err := delphi.FingerprintFromString(entry.Name()); err != nil || !entry.IsDir() {
+			continue
+		}
+		c, n, err := dirUsage(filepath.Join(f.Dir, entry.Name()))
+		if err != nil {
+			return 0, 0, err
+		}
+		count += c
+		size += n
+	}
+	return count, size, nil
+}
+
+// dirUsage counts the messages in a mailbox directory, and their size.
+func dirUsage(dir string) (int, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), fileExt)
+		if !ok || checkID(id) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		count++
+		size += info.Size()
+	}
+	return count, size, nil
+}
+
+func (f *FileStore) Get(to delphi.Fingerprint, id string) ([]byte, error) {
+	path, err := f.path(to, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSuchMessage
+	}
+	return data, err
+}
+
+func (f *FileStore) Delete(to delphi.Fingerprint, id string) error {
+	path, err := f.path(to, id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoSuchMessage
+	}
+	return err
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
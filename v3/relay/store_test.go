@@ -0,0 +1,61 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	stores := map[string]Store{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+	}
+	var alice, bob delphi.Fingerprint
+	alice[0], bob[0] = 1, 2
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ids, err := store.List(alice)
+			require.NoError(t, err)
+			assert.Empty(t, ids)
+
+			require.NoError(t, store.Put(alice, "02", []byte("second")))
+			require.NoError(t, store.Put(alice, "01", []byte("first")))
+			require.NoError(t, store.Put(bob, "01", []byte("for bob")))
+
+			ids, err = store.List(alice)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"01", "02"}, ids)
+
+			count, size, err := store.Usage(alice)
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+			assert.Equal(t, int64(len("second")+len("first")), size)
+			count, size, err = store.TotalUsage()
+			require.NoError(t, err)
+			assert.Equal(t, 3, count)
+			assert.Equal(t, int64(len("second")+len("first")+len("for bob")), size)
+
+			data, err := store.Get(alice, "01")
+			require.NoError(t, err)
+			assert.Equal(t, "first", string(data))
+			data, err = store.Get(bob, "01")
+			require.NoError(t, err)
+			assert.Equal(t, "for bob", string(data))
+
+			require.NoError(t, store.Delete(alice, "01"))
+			_, err = store.Get(alice, "01")
+			assert.ErrorIs(t, err, ErrNoSuchMessage)
+			assert.ErrorIs(t, store.Delete(alice, "01"), ErrNoSuchMessage)
+
+			assert.ErrorIs(t, store.Put(alice, "../../etc/passwd", nil), ErrBadID)
+			_, err = store.Get(alice, "../01")
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package oracle
+
+import (
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+)
+
+const (
+	pemRevocation    = "ORACLE REVOCATION"
+	revocationDomain = "oracle/revocation\x00"
+)
+
+// Props that mark a peer as revoked. Together they hold the whole Revocation, so it can be re-verified.
+const (
+	propRevoked       = "revoked"
+	propRevokedReason = "revoked-reason"
+	propRevokedSig    = "revoked-sig"
+)
+
+var revocationProps = []string{propRevoked, propRevokedReason, propRevokedSig}
+
+var ErrBadRevocation = errors.New("bad revocation")
+
+// A RevocationReason says why a key was revoked.
+type RevocationReason uint8
+
+const (
+	ReasonUnspecified RevocationReason = iota
+	ReasonKeyCompromise
+	ReasonSuperseded
+	ReasonRetired
+)
+
+var revocationReasons = map[RevocationReason]string{
+	ReasonUnspecified:   "unspecified",
+	ReasonKeyCompromise: "key-compromise",
+	ReasonSuperseded:    "superseded",
+	ReasonRetired:       "retired",
+}
+
+func (r RevocationReason) String() string {
+	if s, ok := revocationReasons[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("reason(%d)", uint8(r))
+}
+
+func reasonFromString(s string) (RevocationReason, error) {
+	for r, name := range revocationReasons {
+		if name == s {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("%w. unknown reason %q", ErrBadRevocation, s)
+}
+
+// A Revocation is a statement, signed by a key, that the key should no longer be used.
+// Since it is signed by the key it revokes, it should be generated ahead of time and kept somewhere safe.
+type Revocation struct {
+	Key       delphi.PublicKey
+	Reason    RevocationReason
+	Timestamp time.Time
+	Signature []byte
+}
+
+func (r *Revocation) signedData() []byte {
+	b := []byte(revocationDomain)
+	b = append(b, r.Key.Bytes()...)
+	b = append(b, byte(r.Reason))
+	return binary.BigEndian.AppendUint64(b, uint64(r.Timestamp.Unix()))
+}
+
+// Verify checks that the revoked key signed the Revocation.
+func (r *Revocation) Verify() error {
+	if !new(delphi.KeyPair).Verify(r.Key.Signing(), r.signedData(), r.Signature) {
+		return fmt.Errorf("%w. signature", ErrBadRevocation)
+	}
+	return nil
+}
+
+// Revoke produces a Revocation of the Principal's own key.
+func (pr *Principal) Revoke(reason RevocationReason, now time.Time) (*Revocation, error) {
+	r := &Revocation{
+		Key:       pr.KeyPair.PublicKey(),
+		Reason:    reason,
+		Timestamp: now.UTC().Truncate(time.Second),
+	}
+	sig, err := pr.KeyPair.Sign(nil, r.signedData(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not revoke. %w", err)
+	}
+	r.Signature = sig
+	return r, nil
+}
+
+// ImportRevocation marks a peer as revoked. The revoked key must already be a peer.
+func (pr *Principal) ImportRevocation(r *Revocation) error {
+	if err := r.Verify(); err != nil {
+		return err
+	}
+	props, ok := pr.Peers[r.Key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoSuchPeer, r.Key.Fingerprint().Short())
+	}
+	//	Props may be shared with whoever handed us the peer, so don't write to them in place
+	props = maps.Clone(props)
+	if props == nil {
+		props = make(Props)
+	}
+	pr.Peers[r.Key] = props
+	props[propRevoked] = r.Timestamp.Format(time.RFC3339)
+	props[propRevokedReason] = r.Reason.String()
+	props[propRevokedSig] = hex.EncodeToString(r.Signature)
+	return nil
+}
+
+// Revocation returns the Revocation of a peer, if it has one.
+func (pr *Principal) Revocation(pub delphi.PublicKey) (*Revocation, bool) {
+	props := pr.Peers[pub]
+	if props[propRevoked] == "" {
+		return nil, false
+	}
+	r := &Revocation{Key: pub}
+	var err error
+	r.Timestamp, err = time.Parse(time.RFC3339, props[propRevoked])
+	if err != nil {
+		return nil, false
+	}
+	r.Reason, err = reasonFromString(props[propRevokedReason])
+	if err != nil {
+		return nil, false
+	}
+	r.Signature, err = hex.DecodeString(props[propRevokedSig])
+	if err != nil || r.Verify() != nil {
+		return nil, false
+	}
+	return r, true
+}
+
+// IsRevoked reports whether a peer has been revoked.
+func (pr *Principal) IsRevoked(pub delphi.PublicKey) bool {
+	_, revoked := pr.Revocation(pub)
+	return revoked
+}
+
+// CheckRevoked returns an error if pub belongs to a revoked peer, or to a peer derived from one.
+// pub can be a whole public key, or either of its SubKeys.
+// A restricted key is checked against every peer it shares a SubKey with, so restricting a revoked key doesn't launder it.
+func (pr *Principal) CheckRevoked(pub crypto.PublicKey) error {
+	var candidates []delphi.PublicKey
+	sharing := func(sub delphi.SubKey) {
+		if sub.IsZero() {
+			return
+		}
+		for peer := range pr.Peers {
+			if peer.Signing() == sub || peer.Encryption() == sub {
+				candidates = append(candidates, peer)
+			}
+		}
+	}
+	switch k := pub.(type) {
+	case delphi.PublicKey:
+		candidates = append(candidates, k)
+		sharing(k.Encryption())
+		sharing(k.Signing())
+	case delphi.SubKey:
+		sharing(k)
+	}
+	for _, k := range candidates {
+		if r, revoked := pr.Revocation(k); revoked {
+			return fmt.Errorf("%w: %s (%s)", message.ErrRevoked, k.Fingerprint().Short(), r.Reason)
+		}
+		if parent, revoked := pr.revokedAncestor(k); revoked {
+			return fmt.Errorf("%w: %s (derived from %s)", message.ErrRevoked, k.Fingerprint().Short(), parent.Fingerprint().Short())
+		}
+	}
+	return nil
+}
+
+var _ message.RevocationChecker = (*Principal)(nil)
+
+//	A Principal can stand in for its KeyPair when encrypting and verifying,
+//	in which case revoked peers are refused.
+
+func (pr *Principal) Seal(sec, plainText, nonce, aad []byte) ([]byte, error) {
+	return pr.KeyPair.Seal(sec, plainText, nonce, aad)
+}
+
+func (pr *Principal) GenerateSharedSecret(randy io.Reader, pub delphi.PublicKey) ([]byte, []byte, error) {
+	return pr.KeyPair.GenerateSharedSecret(randy, pub)
+}
+
+func (pr *Principal) GenerateHybridSecret(randy io.Reader, pub delphi.HybridPublicKey) ([]byte, []byte, []byte, error) {
+	return pr.KeyPair.GenerateHybridSecret(randy, pub)
+}
+
+func (pr *Principal) Verify(pubKey crypto.PublicKey, digest []byte, signature []byte) bool {
+	return pr.KeyPair.Verify(pubKey, digest, signature)
+}
+
+// MarshalPEM encodes a Revocation as an "ORACLE REVOCATION" PEM block, whose body is the revoked key.
+func (r *Revocation) MarshalPEM() ([]byte, error) {
+	block := &pem.Block{
+		Type: pemRevocation,
+		Headers: map[string]string{
+			"reason":    r.Reason.String(),
+			"timestamp": r.Timestamp.UTC().Format(time.RFC3339),
+			"sig":       hex.EncodeToString(r.Signature),
+		},
+		Bytes: r.Key.Bytes(),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEM decodes an "ORACLE REVOCATION" PEM block. It does not verify it.
+func (r *Revocation) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type != pemRevocation {
+		return errors.New("wrong PEM type: " + block.Type)
+	}
+	key, err := delphi.KeyFromBytes(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not decode key. %w", err)
+	}
+	reason, err := reasonFromString(block.Headers["reason"])
+	if err != nil {
+		return err
+	}
+	ts, err := time.Parse(time.RFC3339, block.Headers["timestamp"])
+	if err != nil {
+		return fmt.Errorf("could not decode timestamp. %w", err)
+	}
+	sig, err := hex.DecodeString(block.Headers["sig"])
+	if err != nil {
+		return fmt.Errorf("could not decode sig. %w", err)
+	}
+	r.Key = delphi.PublicKey(key)
+	r.Reason = reason
+	r.Timestamp = ts.UTC()
+	r.Signature = sig
+	return nil
+}
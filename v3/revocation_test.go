@@ -0,0 +1,112 @@
+package oracle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var revokedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestPrincipal_Revoke(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	bob.AddPeer(alice.AsPeer())
+
+	signed := message.NewMessage(fakeRand(7))
+	signed.PlainText = []byte("hello bob")
+	require.NoError(t, signed.Sign(&alice.KeyPair))
+
+	//	alice generates her revocation ahead of time, and later publishes it
+	revocation, err := alice.Revoke(ReasonKeyCompromise, revokedAt)
+	require.NoError(t, err)
+	data, err := revocation.MarshalPEM()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "ORACLE REVOCATION")
+	assert.Contains(t, string(data), "reason: key-compromise")
+
+	t.Run("before revocation", func(t *testing.T) {
+		assert.False(t, bob.IsRevoked(alice.KeyPair.PublicKey()))
+		assert.NoError(t, signed.CheckSignature(alice.KeyPair.PublicKey().Signing(), bob))
+	})
+
+	got := new(Revocation)
+	require.NoError(t, got.UnmarshalPEM(data))
+	assert.Equal(t, revocation, got)
+	require.NoError(t, bob.ImportRevocation(got))
+
+	t.Run("peer is marked revoked", func(t *testing.T) {
+		r, revoked := bob.Revocation(alice.KeyPair.PublicKey())
+		require.True(t, revoked)
+		assert.Equal(t, ReasonKeyCompromise, r.Reason)
+	})
+
+	t.Run("encrypt to a revoked key", func(t *testing.T) {
+		msg := message.NewMessage(fakeRand(7))
+		msg.PlainText = []byte("are you there?")
+		err := msg.Encrypt(fakeRand(8), alice.KeyPair.PublicKey(), bob)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		err = msg.EncryptToMany(fakeRand(8), []delphi.PublicKey{alice.KeyPair.PublicKey()}, bob)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		aliceHybrid, err := delphi.NewHybridKeyPair(fakeRand(9), &alice.KeyPair)
+		require.NoError(t, err)
+		hybrid, err := aliceHybrid.HybridPublicKey()
+		require.NoError(t, err)
+		err = msg.EncryptHybrid(fakeRand(8), hybrid, bob)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+	})
+
+	t.Run("encrypt to a restricted form of a revoked key", func(t *testing.T) {
+		restricted, err := alice.KeyPair.PublicKey().Restrict(delphi.CanEncrypt)
+		require.NoError(t, err)
+		msg := message.NewMessage(fakeRand(7))
+		msg.PlainText = []byte("are you there?")
+		assert.ErrorIs(t, msg.Encrypt(fakeRand(8), restricted, bob), message.ErrRevoked)
+		assert.ErrorIs(t, bob.CheckRevoked(restricted), message.ErrRevoked)
+		//	a restricted key of someone else's is fine
+		carol := NewPrincipal(fakeRand(4))
+		other, err := carol.KeyPair.PublicKey().Restrict(delphi.CanEncrypt)
+		require.NoError(t, err)
+		assert.NoError(t, bob.CheckRevoked(other))
+	})
+
+	t.Run("verify from a revoked key", func(t *testing.T) {
+		err := signed.CheckSignature(alice.KeyPair.PublicKey().Signing(), bob)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		assert.False(t, signed.Verify(alice.KeyPair.PublicKey().Signing(), bob))
+		signed.SetSender(alice.KeyPair.PublicKey())
+		require.NoError(t, signed.Sign(&alice.KeyPair))
+		_, err = bob.AuthenticateSender(signed)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+	})
+
+	t.Run("re-adding a revoked peer does not clear the revocation", func(t *testing.T) {
+		bob.AddPeer(alice.AsPeer())
+		assert.True(t, bob.IsRevoked(alice.KeyPair.PublicKey()))
+	})
+
+	t.Run("revoked peers are not trusted", func(t *testing.T) {
+		_, ok := bob.Trusts(alice.KeyPair.PublicKey(), DefaultTrustPolicy)
+		assert.False(t, ok)
+	})
+
+	t.Run("forged revocation", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(4))
+		mallory := NewPrincipal(fakeRand(5))
+		carol.AddPeer(alice.AsPeer())
+		forged, err := mallory.Revoke(ReasonKeyCompromise, revokedAt)
+		require.NoError(t, err)
+		forged.Key = alice.KeyPair.PublicKey()
+		assert.ErrorIs(t, carol.ImportRevocation(forged), ErrBadRevocation)
+		assert.False(t, carol.IsRevoked(alice.KeyPair.PublicKey()))
+	})
+
+	t.Run("only peers can be revoked", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(4))
+		assert.ErrorIs(t, carol.ImportRevocation(revocation), ErrNoSuchPeer)
+	})
+}
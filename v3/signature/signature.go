@@ -0,0 +1,225 @@
+// Package signature produces and checks detached signatures over arbitrary data, such as release artifacts.
+//
+// A detached signature holds the signer's public key, the hash algorithm, a timestamp,
+// and an ed25519 signature over a hash of the data. It can be encoded as PEM or as compact binary.
+package signature
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+const (
+	PEMType = "ORACLE SIGNATURE"
+	domain  = "oracle/signature\x00"
+	magic   = "OSIG"
+	version = 1
+	sigSize = 64
+	keySize = 64
+)
+
+// binarySize is magic, version, hash, timestamp, public key and signature.
+const binarySize = len(magic) + 1 + 1 + 8 + keySize + sigSize
+
+var ErrBadSignature = delphi.ErrBadSignature
+var ErrUnknownHash = errors.New("unknown hash")
+var ErrMalformed = errors.New("malformed signature")
+
+// A Hash identifies the hash algorithm a file is digested with before signing.
+type Hash uint8
+
+const (
+	SHA256 Hash = 1
+	SHA512 Hash = 2
+)
+
+func (h Hash) String() string {
+	switch h {
+	case SHA256:
+		return "sha256"
+	case SHA512:
+		return "sha512"
+	default:
+		return fmt.Sprintf("hash(%d)", uint8(h))
+	}
+}
+
+func (h Hash) new() (hash.Hash, error) {
+	switch h {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHash, h)
+	}
+}
+
+func hashFromString(s string) (Hash, error) {
+	for _, h := range []Hash{SHA256, SHA512} {
+		if h.String() == s {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownHash, s)
+}
+
+// A Signature is a detached signature.
+type Signature struct {
+	Signer    delphi.PublicKey
+	Hash      Hash
+	Timestamp time.Time
+	Sig       []byte
+}
+
+// digest streams r through h.
+func digest(r io.Reader, h Hash) ([]byte, error) {
+	hasher, err := h.new()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// signedData binds the hash algorithm and timestamp to the digest, so neither can be swapped out.
+func (s *Signature) signedData(sum []byte) []byte {
+	b := []byte(domain)
+	b = append(b, byte(s.Hash))
+	b = binary.BigEndian.AppendUint64(b, uint64(s.Timestamp.Unix()))
+	return append(b, sum...)
+}
+
+// SignReader signs everything read from r. The data is hashed as it streams, so it can be of any size.
+// The timestamp is truncated to the second.
+func SignReader(r io.Reader, kp *delphi.KeyPair, h Hash, at time.Time) (*Signature, error) {
+	sum, err := digest(r, h)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign. %w", err)
+	}
+	s := &Signature{
+		Signer:    kp.PublicKey(),
+		Hash:      h,
+		Timestamp: at.UTC().Truncate(time.Second),
+	}
+	s.Sig, err = kp.Sign(nil, s.signedData(sum), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign. %w", err)
+	}
+	return s, nil
+}
+
+// VerifyReader checks that s is a valid signature, by s.Signer, over everything read from r.
+// It is up to the caller to decide whether s.Signer is someone they trust.
+func VerifyReader(r io.Reader, s *Signature) error {
+	sum, err := digest(r, s.Hash)
+	if err != nil {
+		return fmt.Errorf("could not verify. %w", err)
+	}
+	if !new(delphi.KeyPair).Verify(s.Signer.Signing(), s.signedData(sum), s.Sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// MarshalPEM encodes a Signature as an "ORACLE SIGNATURE" PEM block.
+func (s *Signature) MarshalPEM() ([]byte, error) {
+	block := &pem.Block{
+		Type: PEMType,
+		Headers: map[string]string{
+			"signer":    s.Signer.String(),
+			"hash":      s.Hash.String(),
+			"timestamp": s.Timestamp.UTC().Format(time.RFC3339),
+		},
+		Bytes: s.Sig,
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEM decodes an "ORACLE SIGNATURE" PEM block.
+func (s *Signature) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("%w. PEM decode failed", ErrMalformed)
+	}
+	if block.Type != PEMType {
+		return fmt.Errorf("%w. wrong PEM type: %s", ErrMalformed, block.Type)
+	}
+	key, err := delphi.KeyFromString(block.Headers["signer"])
+	if err != nil {
+		return fmt.Errorf("%w. could not decode signer. %w", ErrMalformed, err)
+	}
+	h, err := hashFromString(block.Headers["hash"])
+	if err != nil {
+		return err
+	}
+	ts, err := time.Parse(time.RFC3339, block.Headers["timestamp"])
+	if err != nil {
+		return fmt.Errorf("%w. could not decode timestamp. %w", ErrMalformed, err)
+	}
+	if len(block.Bytes) != sigSize {
+		return fmt.Errorf("%w. signature should be %d bytes, but it is %d", ErrMalformed, sigSize, len(block.Bytes))
+	}
+	s.Signer = delphi.PublicKey(key)
+	s.Hash = h
+	s.Timestamp = ts.UTC()
+	s.Sig = block.Bytes
+	return nil
+}
+
+// MarshalBinary encodes a Signature in a compact, fixed-size binary form.
+func (s *Signature) MarshalBinary() ([]byte, error) {
+	if len(s.Sig) != sigSize {
+		return nil, fmt.Errorf("%w. signature should be %d bytes, but it is %d", ErrMalformed, sigSize, len(s.Sig))
+	}
+	b := make([]byte, 0, binarySize)
+	b = append(b, magic...)
+	b = append(b, version, byte(s.Hash))
+	b = binary.BigEndian.AppendUint64(b, uint64(s.Timestamp.Unix()))
+	b = append(b, s.Signer.Bytes()...)
+	b = append(b, s.Sig...)
+	return b, nil
+}
+
+// UnmarshalBinary decodes the form produced by MarshalBinary.
+func (s *Signature) UnmarshalBinary(data []byte) error {
+	if len(data) != binarySize {
+		return fmt.Errorf("%w. should be %d bytes, but it is %d", ErrMalformed, binarySize, len(data))
+	}
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return fmt.Errorf("%w. bad magic %q", ErrMalformed, hex.EncodeToString(data[:len(magic)]))
+	}
+	data = data[len(magic):]
+	if data[0] != version {
+		return fmt.Errorf("%w. unsupported version %d", ErrMalformed, data[0])
+	}
+	h := Hash(data[1])
+	if _, err := h.new(); err != nil {
+		return err
+	}
+	data = data[2:]
+	ts := time.Unix(int64(binary.BigEndian.Uint64(data[:8])), 0).UTC()
+	data = data[8:]
+	var pub delphi.PublicKey
+	if _, err := pub.Write(data[:keySize]); err != nil {
+		return fmt.Errorf("%w. %w", ErrMalformed, err)
+	}
+	s.Signer = pub
+	s.Hash = h
+	s.Timestamp = ts
+	s.Sig = bytes.Clone(data[keySize:])
+	return nil
+}
@@ -0,0 +1,137 @@
+package signature
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type deterministicReader byte
+
+func (d deterministicReader) Read(p []byte) (n int, err error) {
+	for i := range p {
+		p[i] = byte(d)
+	}
+	return len(p), nil
+}
+
+func alice(t *testing.T) *delphi.KeyPair {
+	t.Helper()
+	kp := delphi.NewKeyPair(deterministicReader(3))
+	return &kp
+}
+
+var when = time.Date(2024, 5, 6, 7, 8, 9, 500, time.UTC)
+
+// bigFile is larger than any buffer io.Copy might use
+var bigFile = bytes.Repeat([]byte("all work and no play makes jack a dull boy\n"), 10_000)
+
+func TestSignReader(t *testing.T) {
+
+	for _, h := range []Hash{SHA256, SHA512} {
+		t.Run(h.String(), func(t *testing.T) {
+			sig, err := SignReader(bytes.NewReader(bigFile), alice(t), h, when)
+			require.NoError(t, err)
+			assert.Equal(t, alice(t).PublicKey(), sig.Signer)
+			assert.Equal(t, when.Truncate(time.Second), sig.Timestamp)
+			assert.NoError(t, VerifyReader(bytes.NewReader(bigFile), sig))
+		})
+	}
+
+	t.Run("altered file", func(t *testing.T) {
+		sig, err := SignReader(bytes.NewReader(bigFile), alice(t), SHA256, when)
+		require.NoError(t, err)
+		altered := bytes.Clone(bigFile)
+		altered[12345] ^= 1
+		assert.ErrorIs(t, VerifyReader(bytes.NewReader(altered), sig), ErrBadSignature)
+		assert.ErrorIs(t, VerifyReader(bytes.NewReader(altered), sig), delphi.ErrBadSignature)
+	})
+
+	t.Run("altered timestamp", func(t *testing.T) {
+		sig, err := SignReader(bytes.NewReader(bigFile), alice(t), SHA256, when)
+		require.NoError(t, err)
+		sig.Timestamp = sig.Timestamp.Add(time.Hour)
+		assert.ErrorIs(t, VerifyReader(bytes.NewReader(bigFile), sig), ErrBadSignature)
+	})
+
+	t.Run("wrong signer", func(t *testing.T) {
+		sig, err := SignReader(bytes.NewReader(bigFile), alice(t), SHA256, when)
+		require.NoError(t, err)
+		mallory := delphi.NewKeyPair(deterministicReader(4))
+		sig.Signer = mallory.PublicKey()
+		assert.ErrorIs(t, VerifyReader(bytes.NewReader(bigFile), sig), ErrBadSignature)
+	})
+
+	t.Run("unknown hash", func(t *testing.T) {
+		_, err := SignReader(bytes.NewReader(bigFile), alice(t), Hash(9), when)
+		assert.ErrorIs(t, err, ErrUnknownHash)
+	})
+
+}
+
+func TestSignature_PEM(t *testing.T) {
+
+	sig, err := SignReader(bytes.NewReader(bigFile), alice(t), SHA512, when)
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := sig.MarshalPEM()
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(data, []byte("-----BEGIN ORACLE SIGNATURE-----")))
+		assert.Contains(t, string(data), "hash: sha512")
+		got := new(Signature)
+		require.NoError(t, got.UnmarshalPEM(data))
+		assert.Equal(t, sig, got)
+		assert.NoError(t, VerifyReader(bytes.NewReader(bigFile), got))
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		data, err := sig.MarshalPEM()
+		require.NoError(t, err)
+		data = []byte(strings.ReplaceAll(string(data), PEMType, "ORACLE MESSAGE"))
+		assert.ErrorIs(t, new(Signature).UnmarshalPEM(data), ErrMalformed)
+	})
+
+	t.Run("unknown hash", func(t *testing.T) {
+		data, err := sig.MarshalPEM()
+		require.NoError(t, err)
+		data = []byte(strings.ReplaceAll(string(data), "sha512", "md5"))
+		assert.ErrorIs(t, new(Signature).UnmarshalPEM(data), ErrUnknownHash)
+	})
+
+}
+
+func TestSignature_Binary(t *testing.T) {
+
+	sig, err := SignReader(bytes.NewReader(bigFile), alice(t), SHA256, when)
+	require.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		data, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		assert.Len(t, data, binarySize)
+		got := new(Signature)
+		require.NoError(t, got.UnmarshalBinary(data))
+		assert.Equal(t, sig, got)
+		assert.NoError(t, VerifyReader(bytes.NewReader(bigFile), got))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		assert.ErrorIs(t, new(Signature).UnmarshalBinary(data[:len(data)-1]), ErrMalformed)
+	})
+
+	t.Run("bad magic", func(t *testing.T) {
+		data, err := sig.MarshalBinary()
+		require.NoError(t, err)
+		data[0] = 'X'
+		assert.ErrorIs(t, new(Signature).UnmarshalBinary(data), ErrMalformed)
+	})
+
+}
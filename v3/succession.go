@@ -0,0 +1,222 @@
+package oracle
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/sean9999/go-oracle/v3/message"
+)
+
+const (
+	pemSuccession    = "ORACLE KEY SUCCESSION"
+	successionDomain = "oracle/succession\x00"
+)
+
+// DefaultGracePeriod is how long a retired KeyPair can still decrypt, if you don't say otherwise.
+const DefaultGracePeriod = 30 * 24 * time.Hour
+
+var ErrBadSuccession = errors.New("bad key succession")
+
+// A Succession is a statement that Old has been replaced by New.
+// It is signed by both keys, so it can only be made by someone holding both.
+type Succession struct {
+	Old       delphi.PublicKey
+	New       delphi.PublicKey
+	Timestamp time.Time
+	OldSig    []byte
+	NewSig    []byte
+}
+
+// A RetiredKey is a KeyPair that has been rotated out, but can still decrypt until its grace period is over.
+type RetiredKey struct {
+	KeyPair delphi.KeyPair `json:"keypair"`
+	Until   time.Time      `json:"until"`
+}
+
+func (s *Succession) signedData() []byte {
+	b := []byte(successionDomain)
+	b = append(b, s.Old.Bytes()...)
+	b = append(b, s.New.Bytes()...)
+	return binary.BigEndian.AppendUint64(b, uint64(s.Timestamp.Unix()))
+}
+
+// Verify checks that both the old and the new key signed the Succession.
+func (s *Succession) Verify() error {
+	if s.Old == s.New {
+		return fmt.Errorf("%w. old and new keys are the same", ErrBadSuccession)
+	}
+	data := s.signedData()
+	v := delphi.KeyPair{}
+	if !v.Verify(s.Old.Signing(), data, s.OldSig) {
+		return fmt.Errorf("%w. old key signature", ErrBadSuccession)
+	}
+	if !v.Verify(s.New.Signing(), data, s.NewSig) {
+		return fmt.Errorf("%w. new key signature", ErrBadSuccession)
+	}
+	return nil
+}
+
+// Rotate replaces the Principal's KeyPair with a new one, and returns a Succession for peers to import.
+// The old KeyPair is retired, and can still decrypt messages until grace has passed.
+func (pr *Principal) Rotate(randy io.Reader, now time.Time, grace time.Duration) (*Succession, error) {
+	pr.MustBeValid()
+	old := &pr.KeyPair
+	next, err := delphi.GenerateKeyPair(randy)
+	if err != nil {
+		return nil, fmt.Errorf("could not rotate. %w", err)
+	}
+	//	next is copied into pr below. This copy is wiped either way
+	defer next.Destroy()
+	s := &Succession{
+		Old:       old.PublicKey(),
+		New:       next.PublicKey(),
+		Timestamp: now.UTC().Truncate(time.Second),
+	}
+	data := s.signedData()
+	if s.OldSig, err = old.Sign(nil, data, nil); err != nil {
+		return nil, fmt.Errorf("could not rotate. %w", err)
+	}
+	if s.NewSig, err = next.Sign(nil, data, nil); err != nil {
+		return nil, fmt.Errorf("could not rotate. %w", err)
+	}
+	if err := s.Verify(); err != nil {
+		return nil, fmt.Errorf("could not rotate. %w", err)
+	}
+	pr.Retired = append(pr.Retired, RetiredKey{KeyPair: *old, Until: now.Add(grace).UTC()})
+	pr.KeyPair = next
+	return s, nil
+}
+
+// PruneRetired forgets retired KeyPairs whose grace period is over, wiping them.
+func (pr *Principal) PruneRetired(now time.Time) {
+	kept := pr.Retired[:0]
+	for i := range pr.Retired {
+		if now.Before(pr.Retired[i].Until) {
+			kept = append(kept, pr.Retired[i])
+		}
+	}
+	//	wipe what was pruned, including the stale copies past the end of kept
+	for i := len(kept); i < len(pr.Retired); i++ {
+		pr.Retired[i].KeyPair.Destroy()
+	}
+	pr.Retired = kept
+}
+
+// Decrypt decrypts a message with the current KeyPair or,
+// failing that, with any retired KeyPair that is still in its grace period.
+func (pr *Principal) Decrypt(msg *message.Message, now time.Time) error {
+	err := msg.Decrypt(&pr.KeyPair)
+	if err == nil {
+		return nil
+	}
+	for i := range pr.Retired {
+		r := &pr.Retired[i]
+		if !now.Before(r.Until) {
+			continue
+		}
+		if msg.Decrypt(&r.KeyPair) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ImportSuccession moves a peer from its old key to its new one, keeping the Props that aren't tied to the old key.
+// The old key must already be a peer, and must not be revoked, since whoever revoked it may no longer be the one holding it.
+// Nor can the new key be revoked. If it is already a peer, the old key's Props are merged into its own.
+func (pr *Principal) ImportSuccession(s *Succession) (Peer, error) {
+	if err := s.Verify(); err != nil {
+		return Peer{}, err
+	}
+	props, ok := pr.Peers[s.Old]
+	if !ok {
+		return Peer{}, fmt.Errorf("%w: %s", ErrNoSuchPeer, s.Old.Fingerprint().Short())
+	}
+	if err := pr.CheckRevoked(s.Old); err != nil {
+		return Peer{}, fmt.Errorf("could not import succession. %w", err)
+	}
+	if err := pr.CheckRevoked(s.New); err != nil {
+		return Peer{}, fmt.Errorf("could not import succession. %w", err)
+	}
+	props = successorProps(props)
+	if existing, ok := pr.Peers[s.New]; ok && existing != nil {
+		//	what the new key already has, including anything derived from or signed for it, wins
+		maps.Copy(props, existing)
+	}
+	delete(pr.Peers, s.Old)
+	pr.Peers[s.New] = props
+	return Peer{PublicKey: s.New, Props: props}, nil
+}
+
+// successorProps are the Props that follow a peer to its new key.
+// Those derived from the old key, or signed for it, stay behind.
+func successorProps(props Props) Props {
+	next := maps.Clone(props)
+	if next == nil {
+		next = make(Props)
+	}
+	for _, k := range slices.Concat(derivedProps, revocationProps, derivationProps) {
+		delete(next, k)
+	}
+	return next
+}
+
+// MarshalPEM encodes a Succession as an "ORACLE KEY SUCCESSION" PEM block, whose body is the new public key.
+func (s *Succession) MarshalPEM() ([]byte, error) {
+	block := &pem.Block{
+		Type: pemSuccession,
+		Headers: map[string]string{
+			"old":       s.Old.String(),
+			"timestamp": s.Timestamp.UTC().Format(time.RFC3339),
+			"old-sig":   hex.EncodeToString(s.OldSig),
+			"new-sig":   hex.EncodeToString(s.NewSig),
+		},
+		Bytes: s.New.Bytes(),
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// UnmarshalPEM decodes an "ORACLE KEY SUCCESSION" PEM block. It does not verify it.
+func (s *Succession) UnmarshalPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("PEM decode failed")
+	}
+	if block.Type != pemSuccession {
+		return errors.New("wrong PEM type: " + block.Type)
+	}
+	next, err := delphi.KeyFromBytes(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not decode new key. %w", err)
+	}
+	old, err := delphi.KeyFromString(block.Headers["old"])
+	if err != nil {
+		return fmt.Errorf("could not decode old key. %w", err)
+	}
+	ts, err := time.Parse(time.RFC3339, block.Headers["timestamp"])
+	if err != nil {
+		return fmt.Errorf("could not decode timestamp. %w", err)
+	}
+	oldSig, err := hex.DecodeString(block.Headers["old-sig"])
+	if err != nil {
+		return fmt.Errorf("could not decode old-sig. %w", err)
+	}
+	newSig, err := hex.DecodeString(block.Headers["new-sig"])
+	if err != nil {
+		return fmt.Errorf("could not decode new-sig. %w", err)
+	}
+	s.Old = delphi.PublicKey(old)
+	s.New = delphi.PublicKey(next)
+	s.Timestamp = ts.UTC()
+	s.OldSig = oldSig
+	s.NewSig = newSig
+	return nil
+}
@@ -0,0 +1,179 @@
+package oracle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var rotatedAt = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+func TestPrincipal_Rotate(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+	bob := NewPrincipal(fakeRand(3))
+	bob.AddPeer(alice.AsPeer())
+	bob.Peers[alice.KeyPair.PublicKey()]["nick name"] = "allie"
+
+	oldKey := alice.KeyPair
+	//	a message sent to alice before she rotated
+	inFlight := message.NewMessage(fakeRand(7))
+	inFlight.PlainText = []byte("hello alice")
+	require.NoError(t, inFlight.Encrypt(fakeRand(8), oldKey.PublicKey(), &bob.KeyPair))
+
+	succession, err := alice.Rotate(fakeRand(9), rotatedAt, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldKey, alice.KeyPair)
+	assert.Equal(t, oldKey.PublicKey(), succession.Old)
+	assert.Equal(t, alice.KeyPair.PublicKey(), succession.New)
+	require.Len(t, alice.Retired, 1)
+	assert.NoError(t, succession.Verify())
+
+	t.Run("in-flight messages decrypt during grace", func(t *testing.T) {
+		msg := *inFlight
+		require.NoError(t, alice.Decrypt(&msg, rotatedAt.Add(time.Minute)))
+		assert.Equal(t, []byte("hello alice"), msg.PlainText)
+	})
+
+	t.Run("but not after", func(t *testing.T) {
+		msg := *inFlight
+		assert.Error(t, alice.Decrypt(&msg, rotatedAt.Add(2*time.Hour)))
+	})
+
+	t.Run("peers import the succession", func(t *testing.T) {
+		data, err := succession.MarshalPEM()
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "ORACLE KEY SUCCESSION")
+		got := new(Succession)
+		require.NoError(t, got.UnmarshalPEM(data))
+		assert.Equal(t, succession, got)
+
+		peer, err := bob.ImportSuccession(got)
+		require.NoError(t, err)
+		assert.Equal(t, alice.KeyPair.PublicKey(), peer.PublicKey)
+		assert.Equal(t, "allie", peer.Props["nick name"])
+		assert.NotContains(t, peer.Props, "nick")
+		assert.NotContains(t, peer.Props, "fingerprint")
+		assert.False(t, bob.HasPeer(oldKey.PublicKey()))
+		assert.True(t, bob.HasPeer(alice.KeyPair.PublicKey()))
+	})
+
+	t.Run("only peers can be succeeded", func(t *testing.T) {
+		carol := NewPrincipal(fakeRand(4))
+		_, err := carol.ImportSuccession(succession)
+		assert.ErrorIs(t, err, ErrNoSuchPeer)
+	})
+
+	t.Run("forged succession", func(t *testing.T) {
+		//	mallory can sign with her own key, but not with alice's old one
+		mallory := NewPrincipal(fakeRand(5))
+		forged := *succession
+		forged.New = mallory.KeyPair.PublicKey()
+		forged.NewSig, err = mallory.KeyPair.Sign(nil, forged.signedData(), nil)
+		require.NoError(t, err)
+		carl := NewPrincipal(fakeRand(6))
+		carl.AddPeer(Peer{PublicKey: oldKey.PublicKey(), Props: Props{}})
+		_, err := carl.ImportSuccession(&forged)
+		assert.ErrorIs(t, err, ErrBadSuccession)
+		assert.True(t, carl.HasPeer(oldKey.PublicKey()))
+	})
+
+	t.Run("revoked, then rotated", func(t *testing.T) {
+		//	dave's key is stolen. he revokes it, but the thief rotates it to a key of their own
+		dave := NewPrincipal(fakeRand(10))
+		erin := NewPrincipal(fakeRand(11))
+		erin.AddPeer(dave.AsPeer())
+		revocation, err := dave.Revoke(ReasonKeyCompromise, rotatedAt)
+		require.NoError(t, err)
+		require.NoError(t, erin.ImportRevocation(revocation))
+
+		thief := &Principal{KeyPair: dave.KeyPair}
+		thief.initialize()
+		laundered, err := thief.Rotate(fakeRand(12), rotatedAt.Add(time.Minute), time.Hour)
+		require.NoError(t, err)
+		_, err = erin.ImportSuccession(laundered)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		assert.False(t, erin.HasPeer(laundered.New))
+		assert.ErrorIs(t, erin.CheckRevoked(dave.KeyPair.PublicKey()), message.ErrRevoked)
+	})
+
+	t.Run("a succession can't un-revoke its new key", func(t *testing.T) {
+		//	mallory's second key is revoked. she rotates her first key onto it
+		mallory := NewPrincipal(fakeRand(13))
+		burned := NewPrincipal(fakeRand(14))
+		frank := NewPrincipal(fakeRand(15))
+		frank.AddPeer(mallory.AsPeer())
+		frank.AddPeer(burned.AsPeer())
+		revocation, err := burned.Revoke(ReasonKeyCompromise, rotatedAt)
+		require.NoError(t, err)
+		require.NoError(t, frank.ImportRevocation(revocation))
+
+		s := &Succession{Old: mallory.KeyPair.PublicKey(), New: burned.KeyPair.PublicKey(), Timestamp: rotatedAt}
+		s.OldSig, err = mallory.KeyPair.Sign(nil, s.signedData(), nil)
+		require.NoError(t, err)
+		s.NewSig, err = burned.KeyPair.Sign(nil, s.signedData(), nil)
+		require.NoError(t, err)
+		_, err = frank.ImportSuccession(s)
+		assert.ErrorIs(t, err, message.ErrRevoked)
+		assert.True(t, frank.IsRevoked(burned.KeyPair.PublicKey()))
+		assert.True(t, frank.HasPeer(mallory.KeyPair.PublicKey()))
+	})
+
+	t.Run("a succession onto an existing peer merges", func(t *testing.T) {
+		grace := NewPrincipal(fakeRand(16))
+		heidi := NewPrincipal(fakeRand(17))
+		ivan := NewPrincipal(fakeRand(18))
+		ivan.AddPeer(grace.AsPeer())
+		ivan.Peers[grace.KeyPair.PublicKey()]["team"] = "blue"
+		ivan.Peers[grace.KeyPair.PublicKey()]["nick name"] = "gracie"
+		ivan.AddPeer(heidi.AsPeer())
+		ivan.Peers[heidi.KeyPair.PublicKey()]["nick name"] = "heidi"
+
+		s := &Succession{Old: grace.KeyPair.PublicKey(), New: heidi.KeyPair.PublicKey(), Timestamp: rotatedAt}
+		var err error
+		s.OldSig, err = grace.KeyPair.Sign(nil, s.signedData(), nil)
+		require.NoError(t, err)
+		s.NewSig, err = heidi.KeyPair.Sign(nil, s.signedData(), nil)
+		require.NoError(t, err)
+		peer, err := ivan.ImportSuccession(s)
+		require.NoError(t, err)
+		assert.Equal(t, "blue", peer.Props["team"])
+		assert.Equal(t, "heidi", peer.Props["nick name"])
+		assert.Equal(t, heidi.KeyPair.PublicKey().Nickname(), peer.Props["nick"])
+		assert.False(t, ivan.HasPeer(grace.KeyPair.PublicKey()))
+	})
+
+	t.Run("retired keys survive a round trip", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(buf))
+		got, err := LoadJSON(buf)
+		require.NoError(t, err)
+		require.Len(t, got.Retired, 1)
+		assert.Equal(t, oldKey, got.Retired[0].KeyPair)
+
+		buf.Reset()
+		require.NoError(t, alice.SaveEncryptedJSON(buf, fakeRand(2), []byte("correct horse")))
+		assert.False(t, bytes.Contains(buf.Bytes(), []byte(oldKey.PrivateKey().Encryption().String())))
+		got, err = LoadJSONWithPassphrase(buf, passphrase("correct horse"))
+		require.NoError(t, err)
+		require.Len(t, got.Retired, 1)
+		assert.Equal(t, oldKey, got.Retired[0].KeyPair)
+		assert.True(t, rotatedAt.Add(time.Hour).Equal(got.Retired[0].Until))
+	})
+
+	t.Run("randomness fails", func(t *testing.T) {
+		before := alice.KeyPair
+		_, err := alice.Rotate(bytes.NewReader(nil), rotatedAt, time.Hour)
+		assert.Error(t, err)
+		assert.Equal(t, before, alice.KeyPair)
+		assert.Len(t, alice.Retired, 1)
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		alice.PruneRetired(rotatedAt.Add(2 * time.Hour))
+		assert.Empty(t, alice.Retired)
+	})
+}
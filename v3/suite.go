@@ -0,0 +1,58 @@
+package oracle
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// propSuite records the cipher suite a key's owner wants messages encrypted with.
+// It travels in Props, so it appears in PEM headers and is carried into a Peer.
+const propSuite = "suite"
+
+func suiteFromProps(props Props) (delphi.SuiteID, error) {
+	s, ok := props[propSuite]
+	if !ok {
+		return delphi.DefaultSuite, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", delphi.ErrUnknownSuite, s)
+	}
+	suite, err := delphi.SuiteByID(delphi.SuiteID(n))
+	if err != nil {
+		return 0, err
+	}
+	return suite.ID, nil
+}
+
+// checkSuite makes sure that a suite in Props, if there is one, is one we know.
+// Better to refuse a config or PEM when it is loaded than a message when it is encrypted.
+func checkSuite(props Props) error {
+	_, err := suiteFromProps(props)
+	return err
+}
+
+// Suite is the cipher suite the Peer wants messages encrypted with.
+func (p *Peer) Suite() (delphi.SuiteID, error) {
+	return suiteFromProps(p.Props)
+}
+
+// Suite is the cipher suite the Principal wants messages encrypted with.
+func (pr *Principal) Suite() (delphi.SuiteID, error) {
+	return suiteFromProps(pr.Props)
+}
+
+// SetSuite records a preferred cipher suite, which peers will learn from AsPeer.
+func (pr *Principal) SetSuite(id delphi.SuiteID) error {
+	suite, err := delphi.SuiteByID(id)
+	if err != nil {
+		return err
+	}
+	if pr.Props == nil {
+		pr.Props = make(Props)
+	}
+	pr.Props[propSuite] = strconv.Itoa(int(suite.ID))
+	return nil
+}
@@ -0,0 +1,69 @@
+package oracle
+
+import (
+	"bytes"
+	"encoding/pem"
+	"maps"
+	"testing"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipal_Suite(t *testing.T) {
+	alice := NewPrincipal(fakeRand(1))
+
+	got, err := alice.Suite()
+	require.NoError(t, err)
+	assert.Equal(t, delphi.DefaultSuite, got)
+
+	require.NoError(t, alice.SetSuite(delphi.SuiteAES256GCM))
+	assert.ErrorIs(t, alice.SetSuite(200), delphi.ErrUnknownSuite)
+
+	t.Run("peers learn it from PEM", func(t *testing.T) {
+		allie := alice.AsPeer()
+		data, err := allie.MarshalPEM()
+		require.NoError(t, err)
+		peer := new(Peer)
+		require.NoError(t, peer.UnmarshalPEM(data))
+		got, err := peer.Suite()
+		require.NoError(t, err)
+		assert.Equal(t, delphi.SuiteAES256GCM, got)
+	})
+
+	t.Run("unknown suites are an error", func(t *testing.T) {
+		peer := Peer{Props: Props{"suite": "200"}}
+		_, err := peer.Suite()
+		assert.ErrorIs(t, err, delphi.ErrUnknownSuite)
+		peer.Props["suite"] = "xchacha"
+		_, err = peer.Suite()
+		assert.ErrorIs(t, err, delphi.ErrUnknownSuite)
+	})
+
+	t.Run("and are refused when loading", func(t *testing.T) {
+		bob := NewPrincipal(fakeRand(2))
+		bob.AddPeer(Peer{PublicKey: alice.KeyPair.PublicKey(), Props: Props{"suite": "200"}})
+		buf := new(bytes.Buffer)
+		require.NoError(t, bob.SaveJSON(buf))
+		_, err := LoadJSON(buf)
+		assert.ErrorIs(t, err, delphi.ErrUnknownSuite)
+
+		allie := alice.AsPeer()
+		allie.Props = maps.Clone(allie.Props)
+		allie.Props["suite"] = "200"
+		data, err := allie.MarshalPEM()
+		require.NoError(t, err)
+		assert.ErrorIs(t, new(Peer).UnmarshalPEM(data), delphi.ErrUnknownSuite)
+		block, _ := pem.Decode(data)
+		_, err = PeerFromPem(*block)
+		assert.ErrorIs(t, err, delphi.ErrUnknownSuite)
+		assert.ErrorIs(t, allie.Validate(), delphi.ErrUnknownSuite)
+
+		carol := NewPrincipal(fakeRand(3))
+		carol.Props["suite"] = "200"
+		data, err = carol.MarshalPEM()
+		require.NoError(t, err)
+		assert.ErrorIs(t, new(Principal).UnmarshalPEM(data), delphi.ErrUnknownSuite)
+	})
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"sync"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/sean9999/go-oracle/v3/delphi"
+)
+
+// A Conn is an encrypted, authenticated net.Conn between two Principals.
+// Reads and writes are each safe for concurrent use.
+type Conn struct {
+	net.Conn
+	peer oracle.Peer
+
+	rmu     sync.Mutex
+	recv    cipher.AEAD
+	rn      uint64
+	pending []byte
+
+	wmu  sync.Mutex
+	send cipher.AEAD
+	wn   uint64
+}
+
+// Client performs the initiator's side of the handshake over conn.
+// randy is used for the ephemeral key. Whatever is at the other end must be one of pr's peers.
+func Client(conn net.Conn, pr *oracle.Principal, randy io.Reader) (*Conn, error) {
+	hs, err := newHandshake(conn, pr, randy)
+	if err != nil {
+		return nil, err
+	}
+	send, recv, err := hs.initiate()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, pr, hs.remote, send, recv), nil
+}
+
+// Server performs the responder's side of the handshake over conn.
+// randy is used for the ephemeral key. Whatever is at the other end must be one of pr's peers.
+func Server(conn net.Conn, pr *oracle.Principal, randy io.Reader) (*Conn, error) {
+	hs, err := newHandshake(conn, pr, randy)
+	if err != nil {
+		return nil, err
+	}
+	send, recv, err := hs.respond()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, pr, hs.remote, send, recv), nil
+}
+
+func newConn(conn net.Conn, pr *oracle.Principal, remote delphi.PublicKey, send, recv cipher.AEAD) *Conn {
+	return &Conn{
+		Conn: conn,
+		peer: oracle.Peer{PublicKey: remote, Props: maps.Clone(pr.Peers[remote])},
+		send: send,
+		recv: recv,
+	}
+}
+
+// Peer is who is at the other end, as they are known to the local PeerStore.
+func (c *Conn) Peer() oracle.Peer {
+	return c.peer
+}
+
+// Read decrypts the next record, if there isn't one already waiting.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.pending) == 0 {
+		record, err := readFrame(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		plainText, err := c.recv.Open(record[:0], nonce(c.rn), record, nil)
+		if err != nil {
+			return 0, fmt.Errorf("%w. %w", ErrBadRecord, err)
+		}
+		c.rn++
+		c.pending = plainText
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write encrypts p as one or more records.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxPlain)]
+		record := c.send.Seal(nil, nonce(c.wn), chunk, nil)
+		c.wn++
+		if err := writeFrame(c.Conn, record); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+var _ net.Conn = (*Conn)(nil)
@@ -0,0 +1,308 @@
+// Package transport wraps a net.Conn in an authenticated, encrypted channel between two Principals.
+//
+// The handshake follows the Noise XX pattern over the X25519 encryption sub-keys:
+//
+//	-> e
+//	<- e, ee, s, es
+//	-> s, se
+//
+// A static key is a whole oracle public key. Whoever sends one also signs the handshake hash with its ed25519 sub-key,
+// so both halves of the key are proven. The remote key must be a peer, and must not be revoked.
+// After the handshake, each record is a 2-byte length followed by a ChaCha20-Poly1305 ciphertext.
+package transport
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	protocolName = "Noise_XX_25519_ChaChaPoly_SHA256+oracle"
+	sigDomain    = "oracle/transport\x00"
+	dhSize       = 32
+	keySize      = 64
+	sigSize      = 64
+	maxRecord    = math.MaxUint16
+	maxPlain     = maxRecord - chacha20poly1305.Overhead
+)
+
+var (
+	ErrHandshake = errors.New("handshake failed")
+	ErrBadRecord = errors.New("bad record")
+)
+
+// symmetricState is the chaining key, handshake hash and cipher of a Noise handshake.
+type symmetricState struct {
+	ck, h []byte
+	aead  cipher.AEAD
+	n     uint64
+}
+
+func newSymmetricState() *symmetricState {
+	h := sha256.Sum256([]byte(protocolName))
+	return &symmetricState{ck: h[:], h: h[:]}
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+// hkdf2 derives two 32-byte keys from a chaining key and some input key material.
+func hkdf2(ck, ikm []byte) ([]byte, []byte) {
+	r := hkdf.New(sha256.New, ikm, ck, nil)
+	out := make([]byte, 64)
+	//	hkdf can produce far more than 64 bytes, so this can't fail
+	_, _ = io.ReadFull(r, out)
+	return out[:32], out[32:]
+}
+
+func newAEAD(k []byte) cipher.AEAD {
+	//	k is always 32 bytes, so this can't fail
+	aead, _ := chacha20poly1305.New(k)
+	return aead
+}
+
+// mixKey consumes a DH secret, wiping it once it has been mixed in.
+func (s *symmetricState) mixKey(ikm []byte) {
+	ck, k := hkdf2(s.ck, ikm)
+	delphi.Wipe(ikm)
+	s.ck = ck
+	s.aead = newAEAD(k)
+	delphi.Wipe(k)
+	s.n = 0
+}
+
+// nonce encodes a counter the way Noise does: four zero bytes, then the counter in little-endian.
+func nonce(n uint64) []byte {
+	b := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(b[4:], n)
+	return b
+}
+
+func (s *symmetricState) encryptAndHash(plainText []byte) []byte {
+	cipherText := s.aead.Seal(nil, nonce(s.n), plainText, s.h)
+	s.n++
+	s.mixHash(cipherText)
+	return cipherText
+}
+
+func (s *symmetricState) decryptAndHash(cipherText []byte) ([]byte, error) {
+	plainText, err := s.aead.Open(nil, nonce(s.n), cipherText, s.h)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	s.mixHash(cipherText)
+	return plainText, nil
+}
+
+// split produces the initiator's and the responder's sending ciphers.
+func (s *symmetricState) split() (cipher.AEAD, cipher.AEAD) {
+	k1, k2 := hkdf2(s.ck, nil)
+	defer delphi.Wipe(k1)
+	defer delphi.Wipe(k2)
+	//	the chaining key is no longer needed, and could derive both ciphers
+	delphi.Wipe(s.ck)
+	return newAEAD(k1), newAEAD(k2)
+}
+
+type handshake struct {
+	pr     *oracle.Principal
+	rw     io.ReadWriter
+	ss     *symmetricState
+	e      *ecdh.PrivateKey
+	s      *ecdh.PrivateKey
+	remote delphi.PublicKey
+}
+
+func newHandshake(rw io.ReadWriter, pr *oracle.Principal, randy io.Reader) (*handshake, error) {
+	if err := pr.KeyPair.Validate(); err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	e, err := ecdh.X25519().GenerateKey(randy)
+	if err != nil {
+		return nil, fmt.Errorf("%w. could not generate ephemeral key. %w", ErrHandshake, err)
+	}
+	s, err := ecdh.X25519().NewPrivateKey(pr.KeyPair.PrivateKey().Encryption().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	return &handshake{pr: pr, rw: rw, ss: newSymmetricState(), e: e, s: s}, nil
+}
+
+func dh(priv *ecdh.PrivateKey, pub []byte) ([]byte, error) {
+	remote, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(remote)
+}
+
+// writeStatic encrypts our public key, mixes in a DH with it, then signs the handshake hash as it stood before the DH.
+func (hs *handshake) writeStatic(remoteEph []byte, ours *ecdh.PrivateKey) ([]byte, error) {
+	out := hs.ss.encryptAndHash(hs.pr.KeyPair.PublicKey().Bytes())
+	signed := append([]byte(sigDomain), hs.ss.h...)
+	secret, err := dh(ours, remoteEph)
+	if err != nil {
+		return nil, err
+	}
+	hs.ss.mixKey(secret)
+	sig, err := hs.pr.KeyPair.Sign(nil, signed, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, hs.ss.encryptAndHash(sig)...), nil
+}
+
+// readStatic is the mirror of writeStatic. It leaves the remote key in hs.remote, and checks that it is a peer.
+func (hs *handshake) readStatic(data []byte, ours *ecdh.PrivateKey) error {
+	sealedKey := keySize + chacha20poly1305.Overhead
+	if len(data) != sealedKey+sigSize+chacha20poly1305.Overhead {
+		return fmt.Errorf("%w. static key is the wrong size", ErrHandshake)
+	}
+	pubBytes, err := hs.ss.decryptAndHash(data[:sealedKey])
+	if err != nil {
+		return fmt.Errorf("%w. could not decrypt static key. %w", ErrHandshake, err)
+	}
+	pub, err := delphi.KeyFromBytes(pubBytes)
+	if err != nil {
+		return fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	remote := delphi.PublicKey(pub)
+	signed := append([]byte(sigDomain), hs.ss.h...)
+	secret, err := dh(ours, remote.Encryption().Bytes())
+	if err != nil {
+		return fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	hs.ss.mixKey(secret)
+	sig, err := hs.ss.decryptAndHash(data[sealedKey:])
+	if err != nil {
+		return fmt.Errorf("%w. could not decrypt signature. %w", ErrHandshake, err)
+	}
+	if !hs.pr.KeyPair.Verify(remote.Signing(), signed, sig) {
+		return fmt.Errorf("%w. bad signature", ErrHandshake)
+	}
+	if _, ok := hs.pr.Peers[remote]; !ok {
+		return fmt.Errorf("%w. %w: %s", ErrHandshake, oracle.ErrNoSuchPeer, remote.Fingerprint().Short())
+	}
+	if err := hs.pr.CheckRevoked(remote); err != nil {
+		return fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	hs.remote = remote
+	return nil
+}
+
+// initiate runs the initiator's side of the handshake, returning the sending and receiving ciphers.
+func (hs *handshake) initiate() (cipher.AEAD, cipher.AEAD, error) {
+	//	-> e
+	ephemeral := hs.e.PublicKey().Bytes()
+	hs.ss.mixHash(ephemeral)
+	if err := writeFrame(hs.rw, ephemeral); err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+
+	//	<- e, ee, s, es
+	msg, err := readFrame(hs.rw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	if len(msg) < dhSize {
+		return nil, nil, fmt.Errorf("%w. short message", ErrHandshake)
+	}
+	remoteEph := msg[:dhSize]
+	hs.ss.mixHash(remoteEph)
+	secret, err := dh(hs.e, remoteEph)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	hs.ss.mixKey(secret)
+	if err := hs.readStatic(msg[dhSize:], hs.e); err != nil {
+		return nil, nil, err
+	}
+
+	//	-> s, se
+	msg, err = hs.writeStatic(remoteEph, hs.s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	if err := writeFrame(hs.rw, msg); err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	send, recv := hs.ss.split()
+	return send, recv, nil
+}
+
+// respond runs the responder's side of the handshake, returning the sending and receiving ciphers.
+func (hs *handshake) respond() (cipher.AEAD, cipher.AEAD, error) {
+	//	-> e
+	remoteEph, err := readFrame(hs.rw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	if len(remoteEph) != dhSize {
+		return nil, nil, fmt.Errorf("%w. ephemeral key is the wrong size", ErrHandshake)
+	}
+	hs.ss.mixHash(remoteEph)
+
+	//	<- e, ee, s, es
+	ephemeral := hs.e.PublicKey().Bytes()
+	hs.ss.mixHash(ephemeral)
+	secret, err := dh(hs.e, remoteEph)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	hs.ss.mixKey(secret)
+	static, err := hs.writeStatic(remoteEph, hs.s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	if err := writeFrame(hs.rw, append(ephemeral, static...)); err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+
+	//	-> s, se
+	msg, err := readFrame(hs.rw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w. %w", ErrHandshake, err)
+	}
+	if err := hs.readStatic(msg, hs.e); err != nil {
+		return nil, nil, err
+	}
+	recv, send := hs.ss.split()
+	return send, recv, nil
+}
+
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > maxRecord {
+		return fmt.Errorf("frame of %d bytes is too big", len(data))
+	}
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len(data)))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
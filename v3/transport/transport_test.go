@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	oracle "github.com/sean9999/go-oracle/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type result struct {
+	conn *Conn
+	err  error
+}
+
+// dial runs a handshake between a client and a server over net.Pipe.
+func dial(t *testing.T, client, server *oracle.Principal) (*Conn, error, *Conn, error) {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	done := make(chan result, 1)
+	go func() {
+		conn, err := Server(s, server, rand.Reader)
+		if err != nil {
+			//	unblock the client
+			s.Close()
+		}
+		done <- result{conn, err}
+	}()
+	cc, cerr := Client(c, client, rand.Reader)
+	if cerr != nil {
+		c.Close()
+	}
+	r := <-done
+	return cc, cerr, r.conn, r.err
+}
+
+func friends() (*oracle.Principal, *oracle.Principal) {
+	alice := oracle.NewPrincipal(rand.Reader)
+	bob := oracle.NewPrincipal(rand.Reader)
+	alice.Props["email"] = "alice@example.com"
+	alice.AddPeer(bob.AsPeer())
+	bob.AddPeer(alice.AsPeer())
+	return alice, bob
+}
+
+func TestHandshake(t *testing.T) {
+	alice, bob := friends()
+
+	t.Run("happy path", func(t *testing.T) {
+		ac, aerr, bc, berr := dial(t, alice, bob)
+		require.NoError(t, aerr)
+		require.NoError(t, berr)
+		assert.Equal(t, bob.KeyPair.PublicKey(), ac.Peer().PublicKey)
+		assert.Equal(t, alice.KeyPair.PublicKey(), bc.Peer().PublicKey)
+		assert.Equal(t, "alice@example.com", bc.Peer().Props["email"])
+
+		go func() {
+			_, _ = ac.Write([]byte("hello bob"))
+		}()
+		buf := make([]byte, 64)
+		n, err := bc.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello bob", string(buf[:n]))
+
+		go func() {
+			_, _ = bc.Write([]byte("hello alice"))
+		}()
+		n, err = ac.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello alice", string(buf[:n]))
+	})
+
+	t.Run("large writes span records", func(t *testing.T) {
+		ac, aerr, bc, berr := dial(t, alice, bob)
+		require.NoError(t, aerr)
+		require.NoError(t, berr)
+		big := make([]byte, 3*maxRecord+17)
+		_, _ = rand.Read(big)
+		go func() {
+			_, _ = ac.Write(big)
+			ac.Close()
+		}()
+		got, err := io.ReadAll(bc)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(big, got))
+	})
+
+	t.Run("strangers are refused", func(t *testing.T) {
+		mallory := oracle.NewPrincipal(rand.Reader)
+		mallory.AddPeer(bob.AsPeer())
+		_, _, _, berr := dial(t, mallory, bob)
+		assert.ErrorIs(t, berr, ErrHandshake)
+		assert.ErrorIs(t, berr, oracle.ErrNoSuchPeer)
+
+		_, aerr, _, _ := dial(t, bob, mallory)
+		assert.ErrorIs(t, aerr, oracle.ErrNoSuchPeer)
+	})
+
+	t.Run("revoked peers are refused", func(t *testing.T) {
+		carol := oracle.NewPrincipal(rand.Reader)
+		dave := oracle.NewPrincipal(rand.Reader)
+		carol.AddPeer(dave.AsPeer())
+		dave.AddPeer(carol.AsPeer())
+		r, err := carol.Revoke(oracle.ReasonKeyCompromise, time.Now())
+		require.NoError(t, err)
+		require.NoError(t, dave.ImportRevocation(r))
+		_, _, _, berr := dial(t, carol, dave)
+		assert.ErrorIs(t, berr, ErrHandshake)
+	})
+
+	t.Run("tampered records are refused", func(t *testing.T) {
+		_, aerr, bc, berr := dial(t, alice, bob)
+		require.NoError(t, aerr)
+		require.NoError(t, berr)
+		c, s := net.Pipe()
+		defer c.Close()
+		bc.Conn = s
+		go func() {
+			_ = writeFrame(c, []byte("this is not a ciphertext"))
+		}()
+		_, err := bc.Read(make([]byte, 8))
+		assert.ErrorIs(t, err, ErrBadRecord)
+	})
+}
+
+func TestSymmetricState(t *testing.T) {
+	a, b := newSymmetricState(), newSymmetricState()
+	a.mixKey([]byte("secret"))
+	b.mixKey([]byte("secret"))
+	cipherText := a.encryptAndHash([]byte("hello"))
+	plainText, err := b.decryptAndHash(cipherText)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(plainText))
+	assert.Equal(t, a.h, b.h)
+
+	//	once the hashes diverge, nothing decrypts
+	a.mixHash([]byte("x"))
+	_, err = b.decryptAndHash(a.encryptAndHash([]byte("hello")))
+	assert.Error(t, err)
+}
@@ -33,7 +33,8 @@ func getTestPrincipal(t testing.TB, name string) *Principal {
 	t.Helper()
 	f, err := os.Open("testdata/" + name + ".priv.json")
 	assert.NoError(t, err)
-	p, err := LoadJSON(f)
+	//	the configs in testdata predate signing
+	p, err := LoadUnsignedJSON(f)
 	assert.NoError(t, err)
 	return p
 }
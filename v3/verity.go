@@ -0,0 +1,209 @@
+package oracle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sean9999/go-oracle/v3/delphi"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	verityDomain  = "oracle/config"
+	verityMACInfo = "oracle/config/hmac"
+)
+
+var ErrTamperedConfig = errors.New("config has been tampered with")
+
+// ErrUnsignedConfig is returned for a config with no Verity. Such a config can't be told apart from one whose Verity was stripped.
+var ErrUnsignedConfig = fmt.Errorf("%w. it is not signed", ErrTamperedConfig)
+
+// A Verity makes a config tamper-evident. It is a signature, by the Principal itself,
+// over its public key, its Props, its PeerStore, its retired keys, its Endorsements and its Derivations.
+// A Principal that can't sign uses an HMAC instead, keyed from its private encryption key.
+type Verity struct {
+	Nonce     hexBytes `json:"nonce"`
+	Signature hexBytes `json:"sig"`
+}
+
+// appendString appends a length-prefixed string, so that concatenated fields can't be confused with each other.
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// canonicalProps encodes Props in lexical order. Derived values are left out.
+func canonicalProps(b []byte, props Props) []byte {
+	return appendProps(b, props, derivedProps)
+}
+
+// appendProps encodes Props in lexical order, leaving out those in skip.
+func appendProps(b []byte, props Props, skip []string) []byte {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if slices.Contains(skip, k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, props[k])
+	}
+	return b
+}
+
+// canonicalPeers encodes a PeerStore ordered by public key.
+func canonicalPeers(b []byte, peers PeerStore) []byte {
+	keys := make([]delphi.PublicKey, 0, len(peers))
+	for k := range peers {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b delphi.PublicKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = append(b, k.Bytes()...)
+		b = canonicalProps(b, peers[k])
+	}
+	return b
+}
+
+// canonicalRetired encodes retired keys by their public keys, in the order they were retired.
+func canonicalRetired(b []byte, retired []RetiredKey) []byte {
+	b = binary.AppendUvarint(b, uint64(len(retired)))
+	for i := range retired {
+		r := &retired[i]
+		b = append(b, r.KeyPair.PublicKey().Bytes()...)
+		b = appendString(b, r.Until.UTC().Format(time.RFC3339Nano))
+	}
+	return b
+}
+
+// canonicalEndorsements encodes an EndorsementStore ordered by subject.
+// Every field is covered, including those an Endorsement's own signature leaves out.
+func canonicalEndorsements(b []byte, es EndorsementStore) []byte {
+	keys := slices.Collect(maps.Keys(es))
+	slices.SortFunc(keys, func(a, b delphi.PublicKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = append(b, k.Bytes()...)
+		b = binary.AppendUvarint(b, uint64(len(es[k])))
+		for _, e := range es[k] {
+			b = append(b, e.Endorser.Bytes()...)
+			b = append(b, e.Subject.Bytes()...)
+			b = appendString(b, e.Timestamp.UTC().Format(time.RFC3339Nano))
+			b = appendProps(b, e.Props, nil)
+			b = appendString(b, string(e.Signature))
+		}
+	}
+	return b
+}
+
+// canonicalDerivations encodes a DerivationStore ordered by child.
+func canonicalDerivations(b []byte, ds DerivationStore) []byte {
+	keys := slices.Collect(maps.Keys(ds))
+	slices.SortFunc(keys, func(a, b delphi.PublicKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		d := ds[k]
+		b = append(b, d.Parent.Bytes()...)
+		b = append(b, d.Child.Bytes()...)
+		b = appendString(b, d.Label)
+		b = appendString(b, d.Timestamp.UTC().Format(time.RFC3339Nano))
+		b = appendString(b, string(d.Signature))
+	}
+	return b
+}
+
+// configDigest is what a Verity signs: everything in a config but the private keys themselves.
+// Retired private keys are bound through their public keys, which Validate checks them against.
+func configDigest(nonce []byte, pr *Principal) []byte {
+	b := []byte(verityDomain)
+	b = appendString(b, string(nonce))
+	b = append(b, pr.KeyPair.PublicKey().Bytes()...)
+	b = canonicalProps(b, pr.Props)
+	b = canonicalPeers(b, pr.Peers)
+	b = canonicalRetired(b, pr.Retired)
+	b = canonicalEndorsements(b, pr.Endorsements)
+	//	left out when empty, so configs signed before there were Derivations still verify
+	if len(pr.Derivations) > 0 {
+		b = canonicalDerivations(b, pr.Derivations)
+	}
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// SignConfig produces a Verity over everything in the Principal's config.
+func (pr *Principal) SignConfig(randy io.Reader) (*Verity, error) {
+	nonce := make([]byte, 16)
+	if _, err := io.ReadFull(randy, nonce); err != nil {
+		return nil, fmt.Errorf("could not sign config. %w", err)
+	}
+	digest := configDigest(nonce, pr)
+	if !pr.KeyPair.Capabilities().Has(delphi.CanSign) {
+		mac, err := pr.configMAC(digest)
+		if err != nil {
+			return nil, fmt.Errorf("could not sign config. %w", err)
+		}
+		return &Verity{Nonce: nonce, Signature: mac}, nil
+	}
+	sig, err := pr.KeyPair.Sign(nil, digest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not sign config. %w", err)
+	}
+	return &Verity{Nonce: nonce, Signature: sig}, nil
+}
+
+// VerifyConfig checks that a Verity matches everything in the Principal's config.
+func (pr *Principal) VerifyConfig(v *Verity) error {
+	if v == nil || len(v.Signature) == 0 {
+		return ErrUnsignedConfig
+	}
+	pub := pr.KeyPair.PublicKey()
+	digest := configDigest(v.Nonce, pr)
+	if !pr.KeyPair.Capabilities().Has(delphi.CanSign) {
+		mac, err := pr.configMAC(digest)
+		if err != nil || !hmac.Equal(mac, v.Signature) {
+			return fmt.Errorf("%w. bad MAC", ErrTamperedConfig)
+		}
+		return nil
+	}
+	if !pr.KeyPair.Verify(pub.Signing(), digest, v.Signature) {
+		return fmt.Errorf("%w. bad signature", ErrTamperedConfig)
+	}
+	return nil
+}
+
+// Unsigned reports whether the Principal was loaded by LoadUnsignedJSON from a config with no Verity,
+// and has not been saved since. Nothing in such a config is protected until it is saved again.
+func (pr *Principal) Unsigned() bool {
+	return pr.unsigned
+}
+
+// configMAC authenticates a config digest for a Principal that has no signing key.
+func (pr *Principal) configMAC(digest []byte) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	h := hkdf.New(sha256.New, pr.KeyPair.PrivateKey().Encryption().Bytes(), nil, []byte(verityMACInfo))
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(digest)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,197 @@
+package oracle
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipal_Verity(t *testing.T) {
+
+	signed := func(t *testing.T) []byte {
+		t.Helper()
+		alice := getTestPrincipal(t, "alice")
+		alice.Props["favourite band"] = "Nirvana"
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(buf))
+		return buf.Bytes()
+	}
+
+	//	tamper decodes a config into a generic map, lets f change it, and encodes it again
+	tamper := func(t *testing.T, data []byte, f func(conf map[string]any)) []byte {
+		t.Helper()
+		conf := map[string]any{}
+		require.NoError(t, json.Unmarshal(data, &conf))
+		f(conf)
+		out, err := json.Marshal(conf)
+		require.NoError(t, err)
+		return out
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		got, err := LoadJSON(bytes.NewReader(signed(t)))
+		require.NoError(t, err)
+		assert.Equal(t, "Nirvana", got.Props["favourite band"])
+		assert.Len(t, got.Peers, 3)
+	})
+
+	t.Run("derived values are not covered", func(t *testing.T) {
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			delete(conf["Props"].(map[string]any), "nick")
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.NoError(t, err)
+	})
+
+	t.Run("altered props", func(t *testing.T) {
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			conf["Props"].(map[string]any)["favourite band"] = "Creed"
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("added peer", func(t *testing.T) {
+		eve := NewPrincipal(fakeRand(9)).AsPeer()
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			conf["peers"].(map[string]any)[eve.PublicKey.String()] = map[string]any{}
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("removed peer", func(t *testing.T) {
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			peers := conf["peers"].(map[string]any)
+			for k := range peers {
+				delete(peers, k)
+				break
+			}
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("stripped signature", func(t *testing.T) {
+		//	indistinguishable from a legacy config, so it is refused unless the caller opts in
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			delete(conf, "verity")
+			conf["Props"].(map[string]any)["favourite band"] = "Creed"
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrUnsignedConfig)
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+		_, err = LoadJSONWithPassphrase(bytes.NewReader(data), nil)
+		assert.ErrorIs(t, err, ErrUnsignedConfig)
+		got, err := LoadUnsignedJSON(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.True(t, got.Unsigned())
+	})
+
+	t.Run("emptied signature", func(t *testing.T) {
+		data := tamper(t, signed(t), func(conf map[string]any) {
+			conf["verity"].(map[string]any)["sig"] = ""
+		})
+		_, err := LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("altered retired key", func(t *testing.T) {
+		alice := getTestPrincipal(t, "alice")
+		_, err := alice.Rotate(fakeRand(6), time.Now(), time.Hour)
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(buf))
+		_, err = LoadJSON(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+
+		data := tamper(t, buf.Bytes(), func(conf map[string]any) {
+			retired := conf["retired"].([]any)[0].(map[string]any)
+			retired["until"] = time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+		})
+		_, err = LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+
+		data = tamper(t, buf.Bytes(), func(conf map[string]any) {
+			conf["retired"] = []any{}
+		})
+		_, err = LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("altered endorsement", func(t *testing.T) {
+		alice := getTestPrincipal(t, "alice")
+		bob := NewPrincipal(fakeRand(7)).AsPeer()
+		bob.Props["email"] = "bob@example.com"
+		_, err := alice.Endorse(bob, []string{"email"}, time.Now())
+		require.NoError(t, err)
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveJSON(buf))
+		_, err = LoadJSON(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+
+		//	derived props are not covered by the Endorsement's own signature, but are by the Verity
+		data := tamper(t, buf.Bytes(), func(conf map[string]any) {
+			for _, list := range conf["endorsements"].(map[string]any) {
+				list.([]any)[0].(map[string]any)["Props"].(map[string]any)["nick"] = "mallory"
+			}
+		})
+		_, err = LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+
+		data = tamper(t, buf.Bytes(), func(conf map[string]any) {
+			delete(conf, "endorsements")
+		})
+		_, err = LoadJSON(bytes.NewReader(data))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+	})
+
+	t.Run("legacy configs", func(t *testing.T) {
+		//	configs in testdata predate signing
+		for _, name := range []string{"alice", "crimson-meadow", "falling-dawn"} {
+			data, err := os.ReadFile("testdata/" + name + ".priv.json")
+			require.NoError(t, err)
+			require.NotContains(t, string(data), "verity")
+			_, err = LoadJSON(bytes.NewReader(data))
+			require.ErrorIs(t, err, ErrUnsignedConfig, name)
+			got, err := LoadUnsignedJSON(bytes.NewReader(data))
+			require.NoError(t, err, name)
+			assert.True(t, got.Unsigned(), name)
+
+			//	signed on the next save
+			buf := new(bytes.Buffer)
+			require.NoError(t, got.SaveJSON(buf))
+			assert.False(t, got.Unsigned())
+			again, err := LoadJSON(buf)
+			require.NoError(t, err, name)
+			assert.False(t, again.Unsigned())
+			assert.Equal(t, got.KeyPair, again.KeyPair)
+		}
+	})
+
+	t.Run("encrypted config", func(t *testing.T) {
+		alice := getTestPrincipal(t, "alice")
+		buf := new(bytes.Buffer)
+		require.NoError(t, alice.SaveEncryptedJSON(buf, fakeRand(5), []byte("correct horse")))
+		data := tamper(t, buf.Bytes(), func(conf map[string]any) {
+			conf["Props"].(map[string]any)["favourite band"] = "Creed"
+		})
+		_, err := LoadJSONWithPassphrase(bytes.NewReader(data), passphrase("correct horse"))
+		assert.ErrorIs(t, err, ErrTamperedConfig)
+
+		stripped := tamper(t, buf.Bytes(), func(conf map[string]any) {
+			delete(conf, "verity")
+		})
+		_, err = LoadJSONWithPassphrase(bytes.NewReader(stripped), passphrase("correct horse"))
+		assert.ErrorIs(t, err, ErrUnsignedConfig)
+		got, err := LoadUnsignedJSONWithPassphrase(bytes.NewReader(stripped), passphrase("correct horse"))
+		require.NoError(t, err)
+		assert.True(t, got.Unsigned())
+	})
+
+}